@@ -0,0 +1,15 @@
+// Command actiondocs prints the action registry reference (docs/actions.md)
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+func main() {
+	if err := models.WriteActionDocs(os.Stdout); err != nil {
+		log.Fatalf("failed to write action docs: %v", err)
+	}
+}
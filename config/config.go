@@ -3,32 +3,124 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
 	// NATS Configuration
-	NatsURL         string
-	NatsCredsFile   string
+	NatsURL       string
+	NatsCredsFile string
 
 	// DynamoDB Configuration
-	AWSRegion       string
-	NotifTableName  string
+	AWSRegion      string
+	NotifTableName string
+	PrefsTableName string
+
+	// Table Bootstrap Configuration
+	TableBootstrap bool // Create and migrate the notifications table on startup
+	TableCheck     bool // Refuse to start if the table's key schema or indexes are wrong
+
+	// NotificationsFile keeps notifications in a JSON file instead of
+	// DynamoDB (dev mode and local CLI use)
+	NotificationsFile string
 
 	// AWS Credentials (optional if using IAM roles)
-	AWSAccessKeyID  string
-	AWSSecretKey    string
+	AWSAccessKeyID string
+	AWSSecretKey   string
 
 	// Pusher Configuration (for real-time notifications)
-	PusherAppID     string
-	PusherKey       string
-	PusherSecret    string
-	PusherCluster   string
+	PusherAppID   string
+	PusherKey     string
+	PusherSecret  string
+	PusherCluster string
+
+	// SMTP Configuration (for email digests)
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPFrom       string
+	SMTPTLSMode    string // none, starttls or tls
+	DigestInterval time.Duration
+
+	// Web Push Configuration (VAPID, RFC 8292)
+	VAPIDPublicKey  string
+	VAPIDPrivateKey string
+	VAPIDSubject    string
+	PushSubsTable   string
+	WebPushOffline  bool // Only push to users not connected to Pusher
+
+	// Mobile Push Configuration (FCM HTTP v1 and APNs)
+	DevicesTable   string
+	FCMCredentials string // Service account key file
+	FCMBaseURL     string
+	APNsKeyFile    string // .p8 signing key
+	APNsKeyID      string
+	APNsTeamID     string
+	APNsTopic      string // App bundle id
+	APNsBaseURL    string
+
+	// Webhook Configuration (outgoing integrations)
+	WebhooksEnabled     bool
+	WebhookEndpoints    string // "url|secret,url|secret" - uses an in-memory store
+	WebhooksTable       string
+	WebhookLogTable     string
+	WebhookAttempts     int
+	WebhookBackoff      time.Duration
+	WebhookDisableAfter int // Consecutive failed deliveries before disabling an endpoint
+
+	// Rendering Configuration
+	DefaultLocale string // Locale used when events don't carry one
+
+	// HTTP Configuration (metrics and health endpoints)
+	HTTPAddr      string
+	AdminAddr     string        // Listen address for /admin endpoints, keep it private; disabled when empty
+	DrainDelay    time.Duration // How long /readyz fails before the worker stops
+	RequirePusher bool          // Fail readiness without Pusher credentials
+
+	// Dead Letter Queue Configuration
+	DLQEnabled bool   // Record events that fail processing
+	DLQTable   string // DynamoDB table for dead letters
+
+	// Idempotency Configuration
+	IdempotencyTable string        // DynamoDB table for processed event ids, in-memory when empty
+	IdempotencyTTL   time.Duration // How long processed event ids are remembered
+
+	// Checkpoint Configuration
+	CheckpointsTable string // DynamoDB table for long-running job progress, in-memory when empty
+
+	// Mention Configuration
+	MentionsEnabled bool          // Notify users @mentioned in content.created events
+	HandlesTable    string        // DynamoDB table mapping @handles to user ids
+	BlocksTable     string        // DynamoDB table of blocked users, no block checks when empty
+	MaxMentions     int           // Most users notified per post or comment, 0 for no limit
+	HandleCacheTTL  time.Duration // How long resolved handles are cached
+	HandleCacheSize int           // Most handles cached
+
+	// Fan-out Configuration
+	FanOutEnabled  bool   // Notify followers of new posts (notifications.fanout.post)
+	FollowersTable string // DynamoDB table of followers per user
+	MaxFanOut      int    // Most followers notified per post, 0 for no limit
+
+	// Broadcast Configuration
+	BroadcastEnabled bool   // Send announcements (notifications.system.broadcast)
+	SegmentsTable    string // DynamoDB table of segment members
+	BroadcastRate    int    // Most broadcast notifications written per second, 0 for no limit
+
+	// Profile Snapshot Configuration
+	SnapshotWindow   time.Duration // How far back profile changes rewrite notifications, 0 for no limit
+	ProfileCacheSize int           // Users whose recent profile changes are overlaid on reads, 0 disables
+
+	// Tracing Configuration (OpenTelemetry)
+	OTLPEndpoint     string  // OTLP/HTTP traces endpoint, export disabled when empty
+	TraceSampleRatio float64 // Fraction of new traces sampled (parent decision wins)
 
 	// Application Configuration
-	Environment     string
-	LogLevel        string
+	Environment string
+	LogLevel    string
 }
 
 // LoadConfig loads configuration from environment variables
@@ -36,19 +128,166 @@ func LoadConfig() (*Config, error) {
 	// Try to load .env file (optional in production)
 	_ = godotenv.Load()
 
+	smtpPort, err := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+	if err != nil {
+		return nil, fmt.Errorf("SMTP_PORT must be a number: %v", err)
+	}
+
+	digestInterval, err := time.ParseDuration(getEnv("DIGEST_INTERVAL", "1h"))
+	if err != nil {
+		return nil, fmt.Errorf("DIGEST_INTERVAL must be a duration: %v", err)
+	}
+	if digestInterval <= 0 {
+		return nil, fmt.Errorf("DIGEST_INTERVAL must be positive")
+	}
+
+	webhookAttempts, err := strconv.Atoi(getEnv("WEBHOOK_MAX_ATTEMPTS", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be a number: %v", err)
+	}
+	if webhookAttempts < 1 {
+		return nil, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be at least 1")
+	}
+
+	webhookBackoff, err := time.ParseDuration(getEnv("WEBHOOK_BACKOFF", "1s"))
+	if err != nil {
+		return nil, fmt.Errorf("WEBHOOK_BACKOFF must be a duration: %v", err)
+	}
+
+	webhookDisableAfter, err := strconv.Atoi(getEnv("WEBHOOK_DISABLE_AFTER", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("WEBHOOK_DISABLE_AFTER must be a number: %v", err)
+	}
+	if webhookDisableAfter < 1 {
+		return nil, fmt.Errorf("WEBHOOK_DISABLE_AFTER must be at least 1")
+	}
+
+	drainDelay, err := time.ParseDuration(getEnv("DRAIN_DELAY", "5s"))
+	if err != nil {
+		return nil, fmt.Errorf("DRAIN_DELAY must be a duration: %v", err)
+	}
+
+	idempotencyTTL, err := time.ParseDuration(getEnv("IDEMPOTENCY_TTL", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("IDEMPOTENCY_TTL must be a duration: %v", err)
+	}
+
+	maxMentions, err := strconv.Atoi(getEnv("MENTIONS_MAX_PER_CONTENT", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("MENTIONS_MAX_PER_CONTENT must be a number: %v", err)
+	}
+	if maxMentions < 0 {
+		return nil, fmt.Errorf("MENTIONS_MAX_PER_CONTENT must be 0 (no limit) or more")
+	}
+
+	handleCacheTTL, err := time.ParseDuration(getEnv("HANDLE_CACHE_TTL", "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("HANDLE_CACHE_TTL must be a duration: %v", err)
+	}
+
+	handleCacheSize, err := strconv.Atoi(getEnv("HANDLE_CACHE_SIZE", "10000"))
+	if err != nil {
+		return nil, fmt.Errorf("HANDLE_CACHE_SIZE must be a number: %v", err)
+	}
+	if handleCacheSize < 1 {
+		return nil, fmt.Errorf("HANDLE_CACHE_SIZE must be at least 1")
+	}
+
+	maxFanOut, err := strconv.Atoi(getEnv("FANOUT_MAX_FOLLOWERS", "10000"))
+	if err != nil {
+		return nil, fmt.Errorf("FANOUT_MAX_FOLLOWERS must be a number: %v", err)
+	}
+
+	broadcastRate, err := strconv.Atoi(getEnv("BROADCAST_RATE", "500"))
+	if err != nil {
+		return nil, fmt.Errorf("BROADCAST_RATE must be a number: %v", err)
+	}
+
+	snapshotWindow, err := time.ParseDuration(getEnv("PROFILE_REFRESH_WINDOW", "2160h"))
+	if err != nil {
+		return nil, fmt.Errorf("PROFILE_REFRESH_WINDOW must be a duration: %v", err)
+	}
+
+	profileCacheSize, err := strconv.Atoi(getEnv("PROFILE_CACHE_SIZE", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("PROFILE_CACHE_SIZE must be a number: %v", err)
+	}
+
+	traceSampleRatio, err := strconv.ParseFloat(getEnv("OTEL_TRACES_SAMPLE_RATIO", "1"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("OTEL_TRACES_SAMPLE_RATIO must be a number: %v", err)
+	}
+
 	config := &Config{
-		NatsURL:        getEnv("NATS_URL", "nats://connect.ngs.global"),
-		NatsCredsFile:  getEnv("NATS_CREDS_FILE", "NGS-Default-exobook.creds"),
-		AWSRegion:      getEnv("AWS_REGION", "ca-central-1"),
-		NotifTableName: getEnv("NOTIF_TABLE_NAME", "exobook-notifications"),
-		AWSAccessKeyID: os.Getenv("AWS_ACCESS_KEY_ID"),
-		AWSSecretKey:   os.Getenv("AWS_SECRET_ACCESS_KEY"),
-		PusherAppID:    os.Getenv("PUSHER_APP_ID"),
-		PusherKey:      getEnv("PUSHER_KEY", "a77d99a67f8892897039"),
-		PusherSecret:   os.Getenv("PUSHER_SECRET"),
-		PusherCluster:  getEnv("PUSHER_CLUSTER", "mt1"),
-		Environment:    getEnv("ENVIRONMENT", "development"),
-		LogLevel:       getEnv("LOG_LEVEL", "info"),
+		NatsURL:             getEnv("NATS_URL", "nats://connect.ngs.global"),
+		NatsCredsFile:       getEnvAllowEmpty("NATS_CREDS_FILE", "NGS-Default-exobook.creds"),
+		AWSRegion:           getEnv("AWS_REGION", "ca-central-1"),
+		NotifTableName:      getEnv("NOTIF_TABLE_NAME", "exobook-notifications"),
+		PrefsTableName:      getEnv("PREFS_TABLE_NAME", "exobook-notification-prefs"),
+		NotificationsFile:   os.Getenv("NOTIFICATIONS_FILE"),
+		TableBootstrap:      getEnvBool("TABLE_BOOTSTRAP", false),
+		TableCheck:          getEnvBool("TABLE_CHECK", false),
+		AWSAccessKeyID:      os.Getenv("AWS_ACCESS_KEY_ID"),
+		AWSSecretKey:        os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		PusherAppID:         os.Getenv("PUSHER_APP_ID"),
+		PusherKey:           getEnv("PUSHER_KEY", "a77d99a67f8892897039"),
+		PusherSecret:        os.Getenv("PUSHER_SECRET"),
+		PusherCluster:       getEnv("PUSHER_CLUSTER", "mt1"),
+		SMTPHost:            os.Getenv("SMTP_HOST"),
+		SMTPPort:            smtpPort,
+		SMTPUsername:        os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:        os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:            getEnv("SMTP_FROM", "Exobook <notifications@exobook.app>"),
+		SMTPTLSMode:         getEnv("SMTP_TLS", "starttls"),
+		DigestInterval:      digestInterval,
+		VAPIDPublicKey:      os.Getenv("VAPID_PUBLIC_KEY"),
+		VAPIDPrivateKey:     os.Getenv("VAPID_PRIVATE_KEY"),
+		VAPIDSubject:        getEnv("VAPID_SUBJECT", "mailto:notifications@exobook.app"),
+		PushSubsTable:       getEnv("PUSH_SUBSCRIPTIONS_TABLE", "exobook-push-subscriptions"),
+		WebPushOffline:      getEnvBool("WEBPUSH_ONLY_OFFLINE", true),
+		DevicesTable:        getEnv("DEVICES_TABLE", "exobook-devices"),
+		FCMCredentials:      os.Getenv("FCM_CREDENTIALS_FILE"),
+		FCMBaseURL:          getEnv("FCM_BASE_URL", "https://fcm.googleapis.com"),
+		APNsKeyFile:         os.Getenv("APNS_KEY_FILE"),
+		APNsKeyID:           os.Getenv("APNS_KEY_ID"),
+		APNsTeamID:          os.Getenv("APNS_TEAM_ID"),
+		APNsTopic:           os.Getenv("APNS_TOPIC"),
+		APNsBaseURL:         getEnv("APNS_BASE_URL", "https://api.push.apple.com"),
+		WebhooksEnabled:     getEnvBool("WEBHOOKS_ENABLED", false),
+		WebhookEndpoints:    os.Getenv("WEBHOOK_ENDPOINTS"),
+		WebhooksTable:       getEnv("WEBHOOKS_TABLE", "exobook-webhooks"),
+		WebhookLogTable:     getEnv("WEBHOOK_DELIVERIES_TABLE", "exobook-webhook-deliveries"),
+		WebhookAttempts:     webhookAttempts,
+		WebhookBackoff:      webhookBackoff,
+		WebhookDisableAfter: webhookDisableAfter,
+		DefaultLocale:       getEnv("DEFAULT_LOCALE", "en"),
+		HTTPAddr:            getEnv("HTTP_ADDR", ":8080"),
+		AdminAddr:           getEnv("ADMIN_ADDR", "127.0.0.1:8081"),
+		DrainDelay:          drainDelay,
+		RequirePusher:       getEnvBool("READY_REQUIRE_PUSHER", false),
+		DLQEnabled:          getEnvBool("DLQ_ENABLED", false),
+		DLQTable:            getEnv("DLQ_TABLE", "exobook-notifications-dlq"),
+		IdempotencyTable:    os.Getenv("IDEMPOTENCY_TABLE"),
+		CheckpointsTable:    os.Getenv("CHECKPOINTS_TABLE"),
+		MentionsEnabled:     getEnvBool("MENTIONS_ENABLED", false),
+		HandlesTable:        getEnv("HANDLES_TABLE", "exobook-handles"),
+		BlocksTable:         os.Getenv("BLOCKS_TABLE"),
+		MaxMentions:         maxMentions,
+		HandleCacheTTL:      handleCacheTTL,
+		HandleCacheSize:     handleCacheSize,
+		FanOutEnabled:       getEnvBool("FANOUT_ENABLED", false),
+		FollowersTable:      getEnv("FOLLOWERS_TABLE", "exobook-followers"),
+		MaxFanOut:           maxFanOut,
+		BroadcastEnabled:    getEnvBool("BROADCAST_ENABLED", false),
+		SegmentsTable:       getEnv("SEGMENTS_TABLE", "exobook-segments"),
+		BroadcastRate:       broadcastRate,
+		SnapshotWindow:      snapshotWindow,
+		ProfileCacheSize:    profileCacheSize,
+		IdempotencyTTL:      idempotencyTTL,
+		OTLPEndpoint:        os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+		TraceSampleRatio:    traceSampleRatio,
+		Environment:         getEnv("ENVIRONMENT", "development"),
+		LogLevel:            getEnv("LOG_LEVEL", "info"),
 	}
 
 	// Validate required fields
@@ -64,6 +303,12 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("NOTIF_TABLE_NAME is required")
 	}
 
+	switch config.SMTPTLSMode {
+	case "none", "starttls", "tls":
+	default:
+		return nil, fmt.Errorf("SMTP_TLS must be one of none, starttls, tls")
+	}
+
 	return config, nil
 }
 
@@ -76,6 +321,24 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// getEnvAllowEmpty is like getEnv, but a variable set to an empty value
+// stays empty, e.g. NATS_CREDS_FILE= to connect without credentials
+func getEnvAllowEmpty(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvBool gets a boolean environment variable with a fallback default
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 // IsDevelopment returns true if running in development mode
 func (c *Config) IsDevelopment() bool {
 	return c.Environment == "development"
@@ -85,3 +348,23 @@ func (c *Config) IsDevelopment() bool {
 func (c *Config) IsProduction() bool {
 	return c.Environment == "production"
 }
+
+// DigestEnabled returns true if SMTP is configured for email digests
+func (c *Config) DigestEnabled() bool {
+	return c.SMTPHost != ""
+}
+
+// WebPushEnabled returns true if VAPID keys are configured for web push
+func (c *Config) WebPushEnabled() bool {
+	return c.VAPIDPrivateKey != ""
+}
+
+// MobilePushEnabled returns true if FCM or APNs credentials are configured
+func (c *Config) MobilePushEnabled() bool {
+	return c.FCMCredentials != "" || c.APNsKeyFile != ""
+}
+
+// WebhooksActive returns true if webhooks are enabled or endpoints are configured
+func (c *Config) WebhooksActive() bool {
+	return c.WebhooksEnabled || c.WebhookEndpoints != ""
+}
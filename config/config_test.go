@@ -0,0 +1,59 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigRejectsOutOfRangeValues(t *testing.T) {
+	tests := []struct {
+		env   string
+		value string
+	}{
+		{"WEBHOOK_MAX_ATTEMPTS", "0"},
+		{"WEBHOOK_MAX_ATTEMPTS", "-1"},
+		{"WEBHOOK_DISABLE_AFTER", "0"},
+		{"WEBHOOK_DISABLE_AFTER", "-3"},
+		{"MENTIONS_MAX_PER_CONTENT", "-1"},
+		{"HANDLE_CACHE_SIZE", "0"},
+		{"HANDLE_CACHE_SIZE", "-5"},
+		{"DIGEST_INTERVAL", "0s"},
+		{"DIGEST_INTERVAL", "-1h"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+
+			_, err := LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.env) {
+				t.Fatalf("LoadConfig() error = %v, want one naming %s", err, tt.env)
+			}
+		})
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.WebhookAttempts != 5 || cfg.WebhookDisableAfter != 10 {
+		t.Errorf("webhook attempts = %d, disable after = %d, want 5 and 10", cfg.WebhookAttempts, cfg.WebhookDisableAfter)
+	}
+	if cfg.MaxMentions != 10 || cfg.HandleCacheSize != 10000 {
+		t.Errorf("max mentions = %d, handle cache size = %d, want 10 and 10000", cfg.MaxMentions, cfg.HandleCacheSize)
+	}
+}
+
+func TestLoadConfigAllowsUnlimitedMentions(t *testing.T) {
+	t.Setenv("MENTIONS_MAX_PER_CONTENT", "0")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.MaxMentions != 0 {
+		t.Errorf("max mentions = %d, want 0", cfg.MaxMentions)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aslotsu/notification-worker/config"
+	"github.com/aslotsu/notification-worker/handlers"
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// devOwner and devTriggerUser are the users in the sample publish command
+const (
+	devOwner       = "dev-owner"
+	devTriggerUser = "dev-friend"
+)
+
+// startDevNATS runs an in-process NATS server for dev mode.
+// A port of -1 picks a free port.
+func startDevNATS(port int) (*server.Server, error) {
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "notification-worker-dev",
+		Host:       "127.0.0.1",
+		Port:       port,
+		NoLog:      true,
+		NoSigs:     true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedded NATS server: %v", err)
+	}
+
+	go ns.Start()
+
+	if !ns.ReadyForConnections(5 * time.Second) {
+		ns.Shutdown()
+		return nil, fmt.Errorf("embedded NATS server did not start on port %d", port)
+	}
+
+	slog.Info("embedded NATS server started", "url", ns.ClientURL())
+	return ns, nil
+}
+
+// newNotificationStore keeps notifications in NOTIFICATIONS_FILE when set,
+// in memory in dev mode, and in DynamoDB otherwise
+func newNotificationStore(cfg *config.Config, dev bool) (handlers.NotificationStore, error) {
+	if cfg.NotificationsFile != "" {
+		slog.Info("using file notification store", "file", cfg.NotificationsFile)
+		return handlers.NewMemoryNotificationStore(cfg.NotificationsFile)
+	}
+
+	if dev {
+		slog.Info("using in-memory notification store")
+		return handlers.NewMemoryNotificationStore("")
+	}
+
+	slog.Info("using DynamoDB notification store", "table", cfg.NotifTableName)
+	return handlers.NewDynamoDBNotificationStore(cfg.AWSRegion, cfg.NotifTableName)
+}
+
+// printDevUsage prints commands that drive the dev pipeline end to end
+func printDevUsage(cfg *config.Config) {
+	env := fmt.Sprintf("NATS_URL=%s NATS_CREDS_FILE=", cfg.NatsURL)
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Dev mode is running. Publish an event with:")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintf(os.Stderr, "  %s go run . publish -action like_post -owner %s -trigger-user %s -username \"Dev Friend\" -resource-id post-1\n",
+		env, devOwner, devTriggerUser)
+	if cfg.NotificationsFile != "" {
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "List the owner's notifications with:")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, "  NOTIFICATIONS_FILE=%s go run . query -owner %s\n", cfg.NotificationsFile, devOwner)
+	}
+	fmt.Fprintln(os.Stderr)
+}
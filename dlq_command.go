@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aslotsu/notification-worker/config"
+	"github.com/aslotsu/notification-worker/handlers"
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// runDLQCommand implements "dlq list", "dlq show" and "dlq delete"
+func runDLQCommand(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	deadLetters, err := handlers.NewDeadLetterService(cfg.AWSRegion, cfg.DLQTable)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("dlq list", flag.ContinueOnError)
+		limit := fs.Int("limit", 50, "maximum number of dead letters")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return listDeadLetters(deadLetters, int32(*limit))
+	case "show":
+		if len(args) != 2 {
+			return errors.New("usage: dlq show ID")
+		}
+		letter, err := deadLetters.GetDeadLetter(args[1])
+		if err != nil {
+			return err
+		}
+		return printDeadLetter(*letter)
+	case "delete":
+		if len(args) != 2 {
+			return errors.New("usage: dlq delete ID")
+		}
+		if err := deadLetters.DeleteDeadLetter(args[1]); err != nil {
+			return err
+		}
+		fmt.Println("deleted", args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown dlq command %q (list, show or delete)", args[0])
+	}
+}
+
+// listDeadLetters prints the latest dead letters as a table
+func listDeadLetters(deadLetters handlers.DeadLetterStore, limit int32) error {
+	letters, err := deadLetters.ListDeadLetters(limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FAILED\tID\tSUBJECT\tREASON\tREPLAYS\tERROR")
+	for _, letter := range letters {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			letter.FailedAt.Local().Format(time.DateTime),
+			letter.Id,
+			letter.Subject,
+			letter.Reason,
+			letter.Replays,
+			letter.Error,
+		)
+	}
+	return w.Flush()
+}
+
+// printDeadLetter prints a dead letter with its payload
+func printDeadLetter(letter models.DeadLetter) error {
+	// Show the payload as JSON when it is, rather than base64
+	out := struct {
+		models.DeadLetter
+		Data    any    `json:"data"`
+		RawData []byte `json:"raw_data,omitempty"`
+	}{DeadLetter: letter}
+
+	var payload any
+	if json.Unmarshal(letter.Data, &payload) == nil {
+		out.Data = payload
+	} else {
+		out.RawData = letter.Data
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// runReplayCommand republishes dead letters to their original subjects
+// and removes them from the queue. Events that fail again are recorded
+// as new dead letters by the worker.
+func runReplayCommand(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	id := fs.String("id", "", "dead letter to replay")
+	all := fs.Bool("all", false, "replay every dead letter")
+	reason := fs.String("reason", "", "with -all, only replay this reason (invalid or failed)")
+	keep := fs.Bool("keep", false, "keep dead letters after replaying them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*id == "") == !*all {
+		return errors.New("exactly one of -id or -all is required")
+	}
+
+	deadLetters, err := handlers.NewDeadLetterService(cfg.AWSRegion, cfg.DLQTable)
+	if err != nil {
+		return err
+	}
+
+	var letters []models.DeadLetter
+	if *all {
+		letters, err = deadLetters.ListDeadLetters(0)
+		if err != nil {
+			return err
+		}
+	} else {
+		letter, err := deadLetters.GetDeadLetter(*id)
+		if err != nil {
+			return err
+		}
+		letters = append(letters, *letter)
+	}
+
+	nc, err := connectNATS(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	replayed := 0
+	for _, letter := range letters {
+		if *reason != "" && letter.Reason != *reason {
+			continue
+		}
+
+		if err := handlers.ReplayDeadLetter(nc, letter); err != nil {
+			return err
+		}
+		replayed++
+
+		if !*keep {
+			if err := deadLetters.DeleteDeadLetter(letter.Id); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("replayed %s to %s\n", letter.Id, letter.Subject)
+	}
+
+	fmt.Printf("replayed %d dead letters\n", replayed)
+	return nil
+}
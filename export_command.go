@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aslotsu/notification-worker/config"
+	"github.com/aslotsu/notification-worker/handlers"
+)
+
+// runExportCommand writes everything held about a user as JSON, for
+// privacy (data access) requests
+func runExportCommand(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	user := fs.String("user", "", "user to export (required)")
+	out := fs.String("out", "", "file to write, stdout when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *user == "" {
+		return errors.New("-user is required")
+	}
+
+	notifService, err := newNotificationService(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Exports don't purge, so nothing is checkpointed
+	privacy, err := newPrivacyService(cfg, notifService, handlers.NewMemoryCheckpointStore(), false)
+	if err != nil {
+		return err
+	}
+
+	archive, err := privacy.Export(context.Background(), *user)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode export: %v", err)
+	}
+	data = append(data, '\n')
+
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write export: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d owned and %d triggered notifications for %s to %s\n",
+		len(archive.Notifications), len(archive.Triggered), *user, *out)
+	return nil
+}
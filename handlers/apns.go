@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+const (
+	// DefaultAPNsBaseURL is the production APNs API
+	DefaultAPNsBaseURL = "https://api.push.apple.com"
+
+	// apnsTokenLifetime is how long a provider token is reused.
+	// Apple rejects tokens older than an hour and throttles frequent refreshes.
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsSender sends iOS pushes through APNs with token-based (.p8) auth
+type APNsSender struct {
+	baseURL    string
+	keyID      string
+	teamID     string
+	topic      string
+	key        *ecdsa.PrivateKey
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+// NewAPNsSender creates an APNs sender from a .p8 signing key.
+// baseURL overrides the APNs host (sandbox or a local stand-in).
+func NewAPNsSender(keyFile, keyID, teamID, topic, baseURL string) (*APNsSender, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, fmt.Errorf("apns key id, team id and topic are required")
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apns key: %v", err)
+	}
+
+	parsed, err := parsePKCS8PrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apns key: %v", err)
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("apns key must be an ECDSA key")
+	}
+
+	if baseURL == "" {
+		baseURL = DefaultAPNsBaseURL
+	}
+
+	return &APNsSender{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		keyID:      keyID,
+		teamID:     teamID,
+		topic:      topic,
+		key:        key,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Platform returns the platform handled by this sender
+func (s *APNsSender) Platform() string {
+	return models.PlatformIOS
+}
+
+// Send delivers a message to a single device token
+func (s *APNsSender) Send(device models.Device, msg MobileMessage) error {
+	token, err := s.providerToken()
+	if err != nil {
+		return err
+	}
+
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": msg.Title,
+				"body":  msg.Body,
+			},
+			"sound": "default",
+		},
+	}
+	for k, v := range msg.Data {
+		payload[k] = v
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal apns payload: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/3/device/"+device.Token, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build apns request: %v", err)
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", s.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("apns request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = json.Unmarshal(respBody, &reason)
+
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		reason.Reason == "BadDeviceToken",
+		reason.Reason == "DeviceTokenNotForTopic",
+		reason.Reason == "Unregistered":
+		return ErrInvalidToken
+	}
+
+	return fmt.Errorf("apns returned %d: %s", resp.StatusCode, reason.Reason)
+}
+
+// providerToken returns a cached APNs provider JWT, re-signing it when stale
+func (s *APNsSender) providerToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.jwt != "" && now.Sub(s.issuedAt) < apnsTokenLifetime {
+		return s.jwt, nil
+	}
+
+	token, err := signES256JWT(s.key, map[string]interface{}{"kid": s.keyID}, map[string]interface{}{
+		"iss": s.teamID,
+		"iat": now.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.jwt = token
+	s.issuedAt = now
+
+	return s.jwt, nil
+}
@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/time/rate"
+)
+
+// SubjectBroadcastRevoked withdraws a broadcast. Broadcasts themselves are
+// published on the broadcast action's subject.
+const SubjectBroadcastRevoked = "notifications.system.broadcast.revoked"
+
+// broadcastChannel is the Pusher channel every client subscribes to for
+// announcements to all users and revocations
+const broadcastChannel = "notifications-broadcast"
+
+// memberPageSize is how many audience members a broadcast reads at once
+const memberPageSize = 250
+
+// Broadcast checkpoint counts
+const (
+	broadcastMembers  = "members"
+	broadcastNotified = "notified"
+	broadcastRevoked  = "revoked"
+)
+
+// AudienceProvider resolves named segments to their members. The "all"
+// segment (models.SegmentAll) holds every user.
+type AudienceProvider interface {
+	// ListMembers returns up to limit user ids in a segment. An empty
+	// cursor starts at the beginning; the returned cursor is empty after
+	// the last page.
+	ListMembers(ctx context.Context, segment, cursor string, limit int32) ([]string, string, error)
+}
+
+// BroadcastService sends announcements to every user, a list of users or
+// a segment, and revokes them
+type BroadcastService struct {
+	notifications *NotificationService
+	audiences     AudienceProvider
+	checkpoints   CheckpointStore
+	limiter       *rate.Limiter
+	pageSize      int32 // Members read and written at once
+}
+
+// NewBroadcastService creates a broadcast service writing at most
+// perSecond notifications a second, 0 for no limit. Progress is saved in
+// checkpoints so a redelivered event resumes an interrupted broadcast.
+func NewBroadcastService(notifications *NotificationService, audiences AudienceProvider, checkpoints CheckpointStore, perSecond int) *BroadcastService {
+	s := &BroadcastService{
+		notifications: notifications,
+		audiences:     audiences,
+		checkpoints:   checkpoints,
+		pageSize:      memberPageSize,
+	}
+	if perSecond > 0 {
+		// A whole page is written at once, so pages are sized to fit the
+		// burst: no more than perSecond notifications go out in any second
+		s.pageSize = int32(min(perSecond, memberPageSize))
+		s.limiter = rate.NewLimiter(rate.Limit(perSecond), perSecond)
+	}
+	return s
+}
+
+// HandleBroadcast sends an announcement to its audience. Register it with
+// NotificationWorker.HandleAction.
+func (s *BroadcastService) HandleBroadcast(ctx context.Context, msg *nats.Msg) error {
+	var event models.BroadcastEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return &ValidationError{Field: "payload", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if strings.TrimSpace(event.BroadcastID) == "" {
+		return &ValidationError{Field: "broadcast_id", Message: "broadcast_id is required"}
+	}
+	if strings.TrimSpace(event.Message) == "" {
+		return &ValidationError{Field: "message", Message: "message is required"}
+	}
+	if err := event.Audience.Validate(); err != nil {
+		return &ValidationError{Field: "audience", Message: err.Error()}
+	}
+
+	checkpoint, err := s.Broadcast(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("sent broadcast",
+		"broadcast_id", event.BroadcastID,
+		"audience", event.Audience.Kind(),
+		"event_id", event.EventID,
+		"members", checkpoint.Counts[broadcastMembers],
+		"notified", checkpoint.Counts[broadcastNotified],
+		"revoked", checkpoint.Counts[broadcastRevoked] > 0,
+	)
+	return nil
+}
+
+// Broadcast writes a broadcast notification for each audience member, a
+// page at a time within the rate limit. Announcements to all users go
+// out over Pusher once on the broadcast channel; targeted ones go to each
+// member's channel. Progress is checkpointed after every page: a
+// broadcast that failed part way resumes, a finished one is not redone,
+// and a revoked one stops and deletes the page it was writing.
+func (s *BroadcastService) Broadcast(ctx context.Context, event models.BroadcastEvent) (*models.Checkpoint, error) {
+	id := event.CheckpointID()
+
+	checkpoint, err := s.checkpoints.GetCheckpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil && checkpoint.Done {
+		slog.Info("broadcast already sent, skipping", "broadcast_id", event.BroadcastID)
+		return checkpoint, nil
+	}
+
+	revoked, err := s.isRevoked(ctx, event.BroadcastID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		slog.Info("broadcast revoked, skipping", "broadcast_id", event.BroadcastID)
+		return &models.Checkpoint{Id: id, Phase: "done", Counts: map[string]int{broadcastRevoked: 1}, Done: true}, nil
+	}
+
+	createdAt := time.Now()
+	if event.CreatedAt > 0 {
+		createdAt = time.Unix(event.CreatedAt, 0)
+	}
+	template := s.broadcastNotification(event, createdAt)
+
+	if checkpoint == nil {
+		checkpoint = &models.Checkpoint{Id: id, Phase: broadcastMembers}
+		if event.Audience.All {
+			s.triggerPusherBroadcast(ctx, template)
+		}
+	} else {
+		slog.Info("resuming broadcast", "broadcast_id", event.BroadcastID, "members", checkpoint.Counts[broadcastMembers])
+	}
+
+	userIDs := uniqueSorted(event.Audience.UserIDs)
+
+	for !checkpoint.Done {
+		var members []string
+		var cursor string
+		switch {
+		case len(userIDs) > 0:
+			members, cursor = pageSortedIDs(userIDs, checkpoint.Cursor, s.pageSize)
+		case event.Audience.All:
+			members, cursor, err = s.audiences.ListMembers(ctx, models.SegmentAll, checkpoint.Cursor, s.pageSize)
+		default:
+			members, cursor, err = s.audiences.ListMembers(ctx, event.Audience.Segment, checkpoint.Cursor, s.pageSize)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		notifications := make([]models.Notification, len(members))
+		for i, member := range members {
+			notif := template
+			notif.Owner = member
+			notif.Id = derivedNotificationID(member, notif.ActionKey)
+			notifications[i] = notif
+		}
+
+		if len(notifications) > 0 {
+			if s.limiter != nil {
+				if err := s.limiter.WaitN(ctx, len(notifications)); err != nil {
+					return nil, err
+				}
+			}
+			if err := s.notifications.store.PutNotifications(ctx, notifications); err != nil {
+				return nil, err
+			}
+
+			// A revocation may have landed while the page was written
+			if revoked, err = s.isRevoked(ctx, event.BroadcastID); err != nil {
+				return nil, err
+			}
+			if revoked {
+				if err := s.deleteNotifications(ctx, notifications); err != nil {
+					return nil, err
+				}
+				checkpoint.Add(broadcastRevoked, 1)
+				checkpoint.Phase = "done"
+				checkpoint.Done = true
+				slog.Warn("broadcast revoked while sending, stopped", "broadcast_id", event.BroadcastID)
+				return checkpoint, nil
+			}
+
+			broadcastNotificationsCreated.Add(float64(len(notifications)))
+			if !event.Audience.All {
+				s.notifications.triggerPusherCreated(ctx, notifications)
+			}
+		}
+
+		checkpoint.Add(broadcastMembers, len(members))
+		checkpoint.Add(broadcastNotified, len(notifications))
+		checkpoint.Cursor = cursor
+		if cursor == "" {
+			checkpoint.Phase = "done"
+			checkpoint.Done = true
+		}
+
+		checkpoint.UpdatedAt = time.Now()
+		if err := s.checkpoints.SaveCheckpoint(ctx, checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	return checkpoint, nil
+}
+
+// broadcastNotification builds the notification every member gets a copy of
+func (s *BroadcastService) broadcastNotification(event models.BroadcastEvent, createdAt time.Time) models.Notification {
+	notif := models.Notification{
+		UserId:       models.BroadcastSender,
+		UserName:     "Exobook",
+		Action:       models.ActionBroadcast,
+		ResourceType: models.ResourceTypeBroadcast,
+		ResourceId:   event.BroadcastID,
+		Excerpt:      event.Message,
+		EventId:      event.EventID,
+		CreatedAt:    createdAt,
+	}
+	s.notifications.prepare(&notif)
+	if event.Title != "" {
+		notif.Title = event.Title
+	}
+	return notif
+}
+
+// HandleBroadcastRevoked withdraws a broadcast. Register it with
+// NotificationWorker.HandleLifecycle: its subscription is separate from the
+// broadcasts', so a revocation doesn't wait for the broadcast it stops.
+func (s *BroadcastService) HandleBroadcastRevoked(ctx context.Context, msg *nats.Msg) error {
+	var event models.BroadcastRevokedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return &ValidationError{Field: "payload", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if strings.TrimSpace(event.BroadcastID) == "" {
+		return &ValidationError{Field: "broadcast_id", Message: "broadcast_id is required"}
+	}
+
+	removed, err := s.Revoke(ctx, event.BroadcastID)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("revoked broadcast",
+		"broadcast_id", event.BroadcastID,
+		"event_id", event.EventID,
+		"removed", removed,
+	)
+	return nil
+}
+
+// Revoke marks a broadcast revoked, so a broadcast still sending stops,
+// then deletes the notifications already written and sends
+// broadcast-revoked on the broadcast channel. Returns how many
+// notifications were deleted.
+func (s *BroadcastService) Revoke(ctx context.Context, broadcastID string) (int, error) {
+	// Mark first: pages written after the lookup below are deleted by the
+	// broadcast itself
+	now := time.Now()
+	err := s.checkpoints.SaveCheckpoint(ctx, &models.Checkpoint{
+		Id:        models.BroadcastRevocationID(broadcastID),
+		Phase:     "done",
+		Done:      true,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Delete a page at a time; a broadcast can reach every user
+	deleted, cursor := 0, ""
+	for {
+		page, next, err := s.notifications.store.ListByResource(ctx, RelationResource, broadcastID, cursor, userPageSize)
+		if err != nil {
+			return deleted, err
+		}
+
+		var matched []models.Notification
+		for _, notif := range page {
+			if notif.Action == models.ActionBroadcast {
+				matched = append(matched, notif)
+			}
+		}
+
+		if err := s.deleteNotifications(ctx, matched); err != nil {
+			return deleted, err
+		}
+		deleted += len(matched)
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	s.triggerPusherRevoked(ctx, broadcastID)
+	return deleted, nil
+}
+
+// isRevoked checks for a broadcast's revocation marker
+func (s *BroadcastService) isRevoked(ctx context.Context, broadcastID string) (bool, error) {
+	marker, err := s.checkpoints.GetCheckpoint(ctx, models.BroadcastRevocationID(broadcastID))
+	if err != nil {
+		return false, err
+	}
+	return marker != nil, nil
+}
+
+// deleteNotifications deletes broadcast notifications. Owners aren't told
+// one by one; clients drop them on broadcast-revoked.
+func (s *BroadcastService) deleteNotifications(ctx context.Context, notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(notifications))
+	for i, notif := range notifications {
+		ids[i] = notif.Id
+	}
+	if err := s.notifications.store.DeleteNotifications(ctx, ids); err != nil {
+		return err
+	}
+	notificationsRemoved.WithLabelValues(models.RemovedRevoked).Add(float64(len(ids)))
+	return nil
+}
+
+// triggerPusherBroadcast sends an announcement to all users on the
+// broadcast channel
+func (s *BroadcastService) triggerPusherBroadcast(ctx context.Context, notif models.Notification) {
+	if s.notifications.pusherClient == nil {
+		return
+	}
+
+	// Each member's copy has its own id; clients fetch it with their list
+	data := notificationPayload(notif)
+	delete(data, "id")
+	data["broadcast_id"] = notif.ResourceId
+
+	start := time.Now()
+	err := s.notifications.pusherClient.Trigger(broadcastChannel, "broadcast", data)
+	pusherDuration.WithLabelValues(statusLabel(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		slog.Error("failed to trigger pusher broadcast", "broadcast_id", notif.ResourceId, "error", err)
+	}
+}
+
+// triggerPusherRevoked tells every client to drop a broadcast
+func (s *BroadcastService) triggerPusherRevoked(ctx context.Context, broadcastID string) {
+	if s.notifications.pusherClient == nil {
+		return
+	}
+
+	data := map[string]interface{}{"broadcast_id": broadcastID}
+	start := time.Now()
+	err := s.notifications.pusherClient.Trigger(broadcastChannel, "broadcast-revoked", data)
+	pusherDuration.WithLabelValues(statusLabel(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		slog.Error("failed to trigger pusher broadcast revoked", "broadcast_id", broadcastID, "error", err)
+	}
+}
+
+// uniqueSorted returns the distinct non-empty ids, sorted
+func uniqueSorted(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// MemorySegmentProvider keeps segments in memory, for dev mode
+type MemorySegmentProvider struct {
+	segments map[string][]string // segment -> user ids, sorted
+}
+
+// NewMemorySegmentProvider creates a segment provider from segment -> user ids
+func NewMemorySegmentProvider(segments map[string][]string) *MemorySegmentProvider {
+	sorted := make(map[string][]string, len(segments))
+	for segment, ids := range segments {
+		sorted[segment] = uniqueSorted(ids)
+	}
+	return &MemorySegmentProvider{segments: sorted}
+}
+
+// ListMembers pages through a segment in id order. The cursor is the last
+// id returned.
+func (p *MemorySegmentProvider) ListMembers(ctx context.Context, segment, cursor string, limit int32) ([]string, string, error) {
+	members, next := pageSortedIDs(p.segments[segment], cursor, limit)
+	return members, next, nil
+}
+
+// DynamoDBSegmentProvider reads segment members from a DynamoDB table
+// maintained by the analytics pipeline.
+// Table key: segment (hash) + user_id (range).
+type DynamoDBSegmentProvider struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBSegmentProvider creates a DynamoDB-backed segment provider
+func NewDynamoDBSegmentProvider(region, tableName string) (*DynamoDBSegmentProvider, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DynamoDBSegmentProvider{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// ListMembers queries one page of a segment. The cursor is the page's
+// LastEvaluatedKey, encoded.
+func (p *DynamoDBSegmentProvider) ListMembers(ctx context.Context, segment, cursor string, limit int32) ([]string, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := time.Now()
+	resp, err := p.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(p.tableName),
+		KeyConditionExpression: aws.String("#segment = :segment"),
+		ExpressionAttributeNames: map[string]string{
+			"#segment": "segment",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":segment": &types.AttributeValueMemberS{Value: segment},
+		},
+		ProjectionExpression: aws.String("#segment, user_id"),
+		ExclusiveStartKey:    startKey,
+		Limit:                aws.Int32(limit),
+	})
+	observeDynamoDB("Query", start, err)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query segment members: %v", err)
+	}
+
+	members := make([]string, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		if member, ok := item["user_id"].(*types.AttributeValueMemberS); ok {
+			members = append(members, member.Value)
+		}
+	}
+
+	next, err := encodeCursor(resp.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return members, next, nil
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// recordingAudience records the limit of each page read and can run a
+// hook before returning a page
+type recordingAudience struct {
+	*MemorySegmentProvider
+	limits []int32
+	before func(cursor string) // Optional, called before each page
+}
+
+func (a *recordingAudience) ListMembers(ctx context.Context, segment, cursor string, limit int32) ([]string, string, error) {
+	a.limits = append(a.limits, limit)
+	if a.before != nil {
+		a.before(cursor)
+	}
+	return a.MemorySegmentProvider.ListMembers(ctx, segment, cursor, limit)
+}
+
+// newTestBroadcasts returns a broadcast service over segments with a
+// memory store and checkpoint store
+func newTestBroadcasts(t *testing.T, segments map[string][]string, perSecond int) (*BroadcastService, *MemoryNotificationStore, *recordingAudience, <-chan pusherRequest) {
+	t.Helper()
+	service, store, triggered := newTestMemoryService(t)
+	audience := &recordingAudience{MemorySegmentProvider: NewMemorySegmentProvider(segments)}
+	return NewBroadcastService(service, audience, NewMemoryCheckpointStore(), perSecond), store, audience, triggered
+}
+
+// broadcastOwners returns which users have a copy of a broadcast
+func broadcastOwners(t *testing.T, store *MemoryNotificationStore, broadcastID string, users []string) []string {
+	t.Helper()
+	var owners []string
+	for _, user := range users {
+		notifications, err := store.ListNotifications(context.Background(), user, 0)
+		if err != nil {
+			t.Fatalf("ListNotifications: %v", err)
+		}
+		for _, notif := range notifications {
+			if notif.Action == models.ActionBroadcast && notif.ResourceId == broadcastID {
+				owners = append(owners, notif.Owner)
+			}
+		}
+	}
+	return owners
+}
+
+func TestBroadcastAudiences(t *testing.T) {
+	users := []string{"user-1", "user-2", "user-3", "user-4"}
+	segments := map[string][]string{
+		models.SegmentAll: users,
+		"beta":            {"user-2", "user-4"},
+	}
+
+	tests := []struct {
+		name     string
+		audience models.Audience
+		want     []string
+		pusher   string // Pusher path suffix: one broadcast or per-member batch
+	}{
+		{"all", models.Audience{All: true}, users, "/events"},
+		{"user ids", models.Audience{UserIDs: []string{"user-3", " user-1", "user-3", ""}}, []string{"user-1", "user-3"}, "/batch_events"},
+		{"segment", models.Audience{Segment: "beta"}, []string{"user-2", "user-4"}, "/batch_events"},
+		{"empty segment", models.Audience{Segment: "nobody"}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broadcasts, store, _, triggered := newTestBroadcasts(t, segments, 0)
+			event := models.BroadcastEvent{BroadcastID: "bc-1", Title: "Maintenance", Message: "Down at 02:00", Audience: tt.audience}
+
+			checkpoint, err := broadcasts.Broadcast(context.Background(), event)
+			if err != nil {
+				t.Fatalf("Broadcast: %v", err)
+			}
+
+			if owners := broadcastOwners(t, store, "bc-1", users); !slices.Equal(owners, tt.want) {
+				t.Errorf("owners = %v, want %v", owners, tt.want)
+			}
+			if !checkpoint.Done || checkpoint.Counts[broadcastNotified] != len(tt.want) {
+				t.Errorf("checkpoint = %+v, want done with %d notified", checkpoint, len(tt.want))
+			}
+
+			if tt.pusher == "" {
+				select {
+				case req := <-triggered:
+					t.Errorf("Pusher triggered %s, want nothing sent", req.Path)
+				default:
+				}
+				return
+			}
+			var req pusherRequest
+			select {
+			case req = <-triggered:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the Pusher trigger")
+			}
+			if !strings.HasSuffix(req.Path, tt.pusher) {
+				t.Errorf("Pusher path = %s, want %s", req.Path, tt.pusher)
+			}
+			if tt.audience.All && !strings.Contains(string(req.Body), broadcastChannel) {
+				t.Errorf("Pusher body %s, want the %s channel", req.Body, broadcastChannel)
+			}
+		})
+	}
+}
+
+func TestBroadcastPagesFitTheRate(t *testing.T) {
+	members := testFollowers(30)
+	broadcasts, store, audience, _ := newTestBroadcasts(t, map[string][]string{"beta": members}, 20)
+	broadcasts.notifications.pusherClient = nil
+
+	start := time.Now()
+	event := models.BroadcastEvent{BroadcastID: "bc-1", Message: "Hi", Audience: models.Audience{Segment: "beta"}}
+	if _, err := broadcasts.Broadcast(context.Background(), event); err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	// The first 20 fill the burst; the last 10 wait half a second
+	if !slices.Equal(audience.limits, []int32{20, 20}) {
+		t.Errorf("page limits = %v, want pages of 20", audience.limits)
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("30 writes at 20/s took %v, want about 500ms", elapsed)
+	}
+	if owners := broadcastOwners(t, store, "bc-1", members); len(owners) != len(members) {
+		t.Errorf("%d members notified, want %d", len(owners), len(members))
+	}
+}
+
+func TestBroadcastRevokedWhileSendingDeletesInFlightPage(t *testing.T) {
+	members := testFollowers(2*memberPageSize + 10)
+	broadcasts, store, audience, _ := newTestBroadcasts(t, map[string][]string{"beta": members}, 0)
+	broadcasts.notifications.pusherClient = nil
+	ctx := context.Background()
+
+	// The revocation marker lands while the second page is read
+	audience.before = func(cursor string) {
+		if cursor != "" {
+			marker := &models.Checkpoint{Id: models.BroadcastRevocationID("bc-1"), Phase: "done", Done: true}
+			if err := broadcasts.checkpoints.SaveCheckpoint(ctx, marker); err != nil {
+				t.Errorf("SaveCheckpoint: %v", err)
+			}
+		}
+	}
+
+	event := models.BroadcastEvent{BroadcastID: "bc-1", Message: "Hi", Audience: models.Audience{Segment: "beta"}}
+	checkpoint, err := broadcasts.Broadcast(ctx, event)
+	if err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+
+	if len(audience.limits) != 2 {
+		t.Errorf("read %d pages, want the broadcast to stop after the second", len(audience.limits))
+	}
+	if !checkpoint.Done || checkpoint.Counts[broadcastRevoked] != 1 || checkpoint.Counts[broadcastNotified] != memberPageSize {
+		t.Errorf("checkpoint = %+v, want done and revoked after one page", checkpoint)
+	}
+
+	// The first page is left to Revoke; the one in flight is deleted
+	if owners := broadcastOwners(t, store, "bc-1", members); !slices.Equal(owners, members[:memberPageSize]) {
+		t.Errorf("%d members kept the broadcast, want the first page only", len(owners))
+	}
+}
+
+func TestRevokeDeletesBroadcastAndSkipsRedelivery(t *testing.T) {
+	users := []string{"user-1", "user-2", "user-3"}
+	broadcasts, store, audience, _ := newTestBroadcasts(t, map[string][]string{"beta": users}, 0)
+	broadcasts.notifications.pusherClient = nil
+	ctx := context.Background()
+
+	// Another broadcast is left alone
+	event := models.BroadcastEvent{BroadcastID: "bc-1", Message: "Hi", Audience: models.Audience{Segment: "beta"}}
+	other := models.BroadcastEvent{BroadcastID: "bc-2", Message: "Hello", Audience: models.Audience{UserIDs: []string{"user-1"}}}
+	for _, e := range []models.BroadcastEvent{event, other} {
+		if _, err := broadcasts.Broadcast(ctx, e); err != nil {
+			t.Fatalf("Broadcast: %v", err)
+		}
+	}
+
+	removed, err := broadcasts.Revoke(ctx, "bc-1")
+	if err != nil || removed != len(users) {
+		t.Errorf("Revoke = %d, %v, want %d", removed, err, len(users))
+	}
+	if owners := broadcastOwners(t, store, "bc-1", users); len(owners) != 0 {
+		t.Errorf("%v still have the revoked broadcast", owners)
+	}
+	if owners := broadcastOwners(t, store, "bc-2", users); len(owners) != 1 {
+		t.Errorf("bc-2 owners = %v, want user-1", owners)
+	}
+
+	// A replay after the checkpoint was lost is not sent again
+	broadcasts.checkpoints.SaveCheckpoint(ctx, &models.Checkpoint{Id: event.CheckpointID(), Phase: broadcastMembers})
+	audience.limits = nil
+	checkpoint, err := broadcasts.Broadcast(ctx, event)
+	if err != nil {
+		t.Fatalf("Broadcast: %v", err)
+	}
+	if len(audience.limits) != 0 || checkpoint.Counts[broadcastRevoked] != 1 {
+		t.Errorf("revoked broadcast read %d pages, checkpoint %+v, want it skipped", len(audience.limits), checkpoint)
+	}
+	if owners := broadcastOwners(t, store, "bc-1", users); len(owners) != 0 {
+		t.Errorf("%v got the revoked broadcast again", owners)
+	}
+}
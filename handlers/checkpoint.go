@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// checkpointTTL is how long a checkpoint is kept after its last save
+const checkpointTTL = 30 * 24 * time.Hour
+
+// CheckpointStore saves the progress of long-running jobs so a job
+// interrupted by a restart or a redelivery resumes where it stopped
+type CheckpointStore interface {
+	// GetCheckpoint returns a job's checkpoint, or nil if it has none
+	GetCheckpoint(ctx context.Context, id string) (*models.Checkpoint, error)
+	// SaveCheckpoint creates or replaces a job's checkpoint
+	SaveCheckpoint(ctx context.Context, checkpoint *models.Checkpoint) error
+}
+
+// MemoryCheckpointStore keeps checkpoints in memory. They are lost on
+// restart, so an interrupted job starts over; every job is safe to redo.
+type MemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]models.Checkpoint // job id -> checkpoint
+}
+
+// NewMemoryCheckpointStore creates an in-memory checkpoint store
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]models.Checkpoint)}
+}
+
+// GetCheckpoint returns a copy of a job's checkpoint
+func (s *MemoryCheckpointStore) GetCheckpoint(ctx context.Context, id string) (*models.Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoint, ok := s.checkpoints[id]
+	if !ok {
+		return nil, nil
+	}
+	checkpoint.Counts = copyCounts(checkpoint.Counts)
+	return &checkpoint, nil
+}
+
+// SaveCheckpoint stores a copy of a job's checkpoint
+func (s *MemoryCheckpointStore) SaveCheckpoint(ctx context.Context, checkpoint *models.Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := *checkpoint
+	saved.Counts = copyCounts(checkpoint.Counts)
+	s.checkpoints[checkpoint.Id] = saved
+	return nil
+}
+
+// copyCounts copies a checkpoint's counts so callers can't change a stored one
+func copyCounts(counts map[string]int) map[string]int {
+	if counts == nil {
+		return nil
+	}
+	copied := make(map[string]int, len(counts))
+	for kind, n := range counts {
+		copied[kind] = n
+	}
+	return copied
+}
+
+// DynamoDBCheckpointStore keeps checkpoints in a DynamoDB table shared by
+// every worker instance. Table key: id. DynamoDB TTL should be enabled on
+// expires_at so finished jobs are cleaned up.
+type DynamoDBCheckpointStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBCheckpointStore creates a DynamoDB-backed checkpoint store
+func NewDynamoDBCheckpointStore(region, tableName string) (*DynamoDBCheckpointStore, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DynamoDBCheckpointStore{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// GetCheckpoint reads a job's checkpoint with a consistent read
+func (s *DynamoDBCheckpointStore) GetCheckpoint(ctx context.Context, id string) (*models.Checkpoint, error) {
+	start := time.Now()
+	resp, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	observeDynamoDB("GetCheckpoint", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checkpoint %s: %v", id, err)
+	}
+	if resp.Item == nil {
+		return nil, nil
+	}
+
+	var checkpoint models.Checkpoint
+	if err := attributevalue.UnmarshalMap(resp.Item, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint %s: %v", id, err)
+	}
+	return &checkpoint, nil
+}
+
+// SaveCheckpoint writes a job's checkpoint, refreshing its expiry
+func (s *DynamoDBCheckpointStore) SaveCheckpoint(ctx context.Context, checkpoint *models.Checkpoint) error {
+	saved := *checkpoint
+	saved.ExpiresAt = saved.UpdatedAt.Add(checkpointTTL).Unix()
+
+	item, err := attributevalue.MarshalMap(saved)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %v", err)
+	}
+
+	start := time.Now()
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	observeDynamoDB("SaveCheckpoint", start, err)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint %s: %v", checkpoint.Id, err)
+	}
+	return nil
+}
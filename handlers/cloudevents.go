@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aslotsu/notification-worker/schema"
+	"github.com/nats-io/nats.go"
+)
+
+// ContentTypeCloudEventsJSON marks a structured-mode CloudEvent
+const ContentTypeCloudEventsJSON = "application/cloudevents+json"
+
+// CloudEventTypePrefix prefixes action names in the CloudEvents type,
+// e.g. "app.exobook.notification.like_post"
+const CloudEventTypePrefix = "app.exobook.notification."
+
+// cloudEventHeaderPrefix prefixes context attributes in binary mode
+const cloudEventHeaderPrefix = "ce-"
+
+// cloudEvent holds the CloudEvents 1.0 attributes the worker maps
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+	DataBase64      string          `json:"data_base64"`
+}
+
+// isBinaryCloudEvent returns true if the message carries ce-* headers
+func isBinaryCloudEvent(msg *nats.Msg) bool {
+	return headerValue(msg.Header, cloudEventHeaderPrefix+"specversion") != ""
+}
+
+// decodeStructuredCloudEvent decodes an application/cloudevents+json message
+func decodeStructuredCloudEvent(data []byte, receivedAt time.Time) (models.NotificationEvent, error) {
+	var ce cloudEvent
+	if err := json.Unmarshal(data, &ce); err != nil {
+		return models.NotificationEvent{}, fmt.Errorf("invalid CloudEvent: %v", err)
+	}
+
+	payload := []byte(ce.Data)
+	if ce.DataBase64 != "" {
+		decoded, err := base64.StdEncoding.DecodeString(ce.DataBase64)
+		if err != nil {
+			return models.NotificationEvent{}, fieldError("data_base64", "is not valid base64")
+		}
+		payload = decoded
+	}
+
+	return ce.toEvent(payload, receivedAt)
+}
+
+// decodeBinaryCloudEvent decodes a message whose attributes are ce-* headers
+// and whose body is the event data, typed by Content-Type
+func decodeBinaryCloudEvent(msg *nats.Msg, receivedAt time.Time) (models.NotificationEvent, error) {
+	attr := func(name string) string {
+		return headerValue(msg.Header, cloudEventHeaderPrefix+name)
+	}
+
+	ce := cloudEvent{
+		SpecVersion:     attr("specversion"),
+		ID:              attr("id"),
+		Source:          attr("source"),
+		Type:            attr("type"),
+		Subject:         attr("subject"),
+		Time:            attr("time"),
+		DataContentType: headerValue(msg.Header, "Content-Type"),
+	}
+
+	return ce.toEvent(msg.Data, receivedAt)
+}
+
+// toEvent maps the envelope onto the event data: type to action, subject
+// to resource, source and id to the event id, and time to created_at.
+// The result is validated against the current schema version.
+func (ce cloudEvent) toEvent(data []byte, receivedAt time.Time) (models.NotificationEvent, error) {
+	var fields []schema.FieldError
+	if ce.SpecVersion != "1.0" {
+		fields = append(fields, schema.FieldError{Field: "specversion", Message: fmt.Sprintf("unsupported version %q (supported: 1.0)", ce.SpecVersion)})
+	}
+	for _, attr := range []struct{ name, value string }{{"id", ce.ID}, {"source", ce.Source}, {"type", ce.Type}} {
+		if attr.value == "" {
+			fields = append(fields, schema.FieldError{Field: attr.name, Message: "is required"})
+		}
+	}
+	if len(fields) > 0 {
+		return models.NotificationEvent{}, &schema.Error{Fields: fields}
+	}
+
+	action, ok := models.LookupActionByName(strings.TrimPrefix(ce.Type, CloudEventTypePrefix))
+	if !ok || !strings.HasPrefix(ce.Type, CloudEventTypePrefix) {
+		return models.NotificationEvent{}, fieldError("type", fmt.Sprintf("unknown type %q (expected %s<action name>)", ce.Type, CloudEventTypePrefix))
+	}
+
+	doc, err := ce.dataDocument(data)
+	if err != nil {
+		return models.NotificationEvent{}, err
+	}
+
+	doc["schema_version"] = json.Number(strconv.Itoa(schema.CurrentVersion))
+	doc["action"] = json.Number(strconv.Itoa(action.ID))
+	doc["event_id"] = ce.Source + "#" + ce.ID
+
+	// Subject is "TYPE/id" or just the resource id
+	if ce.Subject != "" {
+		if resourceType, resourceID, ok := strings.Cut(ce.Subject, "/"); ok {
+			doc["resource_type"] = strings.ToUpper(resourceType)
+			doc["resource_id"] = resourceID
+		} else {
+			doc["resource_id"] = ce.Subject
+		}
+	}
+	if isEmpty(doc, "resource_type") && len(action.ResourceTypes) == 1 {
+		doc["resource_type"] = action.ResourceTypes[0]
+	}
+
+	if ce.Time != "" {
+		t, err := time.Parse(time.RFC3339, ce.Time)
+		if err != nil {
+			return models.NotificationEvent{}, fieldError("time", "must be an RFC 3339 timestamp")
+		}
+		doc["created_at"] = json.Number(strconv.FormatInt(t.Unix(), 10))
+	} else if isEmpty(doc, "created_at") {
+		doc["created_at"] = json.Number(strconv.FormatInt(receivedAt.Unix(), 10))
+	}
+
+	return schema.DecodeDocument(doc, receivedAt)
+}
+
+// dataDocument parses the event data according to datacontenttype
+func (ce cloudEvent) dataDocument(data []byte) (map[string]any, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return map[string]any{}, nil
+	}
+
+	mediaType := ContentTypeJSON
+	if ce.DataContentType != "" {
+		parsed, _, err := mime.ParseMediaType(ce.DataContentType)
+		if err != nil {
+			return nil, fieldError("datacontenttype", "is not a valid media type")
+		}
+		mediaType = parsed
+	}
+
+	switch mediaType {
+	case ContentTypeJSON:
+		return schema.ParseDocument(data)
+	case ContentTypeProtobuf:
+		// Validated by toEvent once the envelope has been mapped on
+		event, err := unmarshalProtobufEvent(data)
+		if err != nil {
+			return nil, err
+		}
+		return protobufDocument(event)
+	default:
+		return nil, fieldError("datacontenttype", fmt.Sprintf("unsupported media type %q", mediaType))
+	}
+}
+
+// isEmpty returns true if a document field is missing, empty or zero
+func isEmpty(doc map[string]any, key string) bool {
+	switch value := doc[key].(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case json.Number:
+		return value.String() == "0"
+	default:
+		return false
+	}
+}
+
+// fieldError returns a schema error for a single field
+func fieldError(field, message string) error {
+	return &schema.Error{Fields: []schema.FieldError{{Field: field, Message: message}}}
+}
+
+// headerValue looks up a NATS header, ignoring case since producers
+// differ on "ce-id" vs "Ce-Id"
+func headerValue(header nats.Header, key string) string {
+	if value := header.Get(key); value != "" {
+		return value
+	}
+	for k, values := range header {
+		if strings.EqualFold(k, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
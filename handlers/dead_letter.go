@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// DeadLetterStore keeps events the worker could not process
+type DeadLetterStore interface {
+	RecordDeadLetter(letter models.DeadLetter) error
+	ListDeadLetters(limit int32) ([]models.DeadLetter, error)
+	GetDeadLetter(id string) (*models.DeadLetter, error)
+	DeleteDeadLetter(id string) error
+}
+
+// deadLetterReplaysHeader counts how many times an event was replayed
+const deadLetterReplaysHeader = "Dlq-Replays"
+
+// NewDeadLetter captures a message that failed processing
+func NewDeadLetter(msg *nats.Msg, reason string, err error) models.DeadLetter {
+	replays, _ := strconv.Atoi(msg.Header.Get(deadLetterReplaysHeader))
+
+	return models.DeadLetter{
+		Id:       uuid.New().String(),
+		Subject:  msg.Subject,
+		Headers:  msg.Header,
+		Data:     msg.Data,
+		Reason:   reason,
+		Error:    err.Error(),
+		Replays:  replays,
+		FailedAt: time.Now(),
+	}
+}
+
+// ReplayDeadLetter republishes a dead letter to its original subject with
+// its original headers, so it goes through the worker again
+func ReplayDeadLetter(nc *nats.Conn, letter models.DeadLetter) error {
+	msg := nats.NewMsg(letter.Subject)
+	msg.Data = letter.Data
+	for key, values := range letter.Headers {
+		for _, value := range values {
+			msg.Header.Add(key, value)
+		}
+	}
+	msg.Header.Set(deadLetterReplaysHeader, strconv.Itoa(letter.Replays+1))
+
+	if err := nc.PublishMsg(msg); err != nil {
+		return fmt.Errorf("failed to replay dead letter %s: %v", letter.Id, err)
+	}
+	return nc.Flush()
+}
+
+// deadLetterMentionsUser reports whether a dead letter's payload holds
+// the user id as a value anywhere, e.g. owner, trigger_user or user_id.
+// Payloads that aren't JSON (protobuf) are searched for the id's bytes.
+func deadLetterMentionsUser(letter models.DeadLetter, userID string) bool {
+	var doc any
+	if err := json.Unmarshal(letter.Data, &doc); err != nil {
+		return bytes.Contains(letter.Data, []byte(userID))
+	}
+	return jsonContainsString(doc, userID)
+}
+
+// jsonContainsString walks a decoded JSON value for a string value
+func jsonContainsString(value any, s string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == s
+	case map[string]any:
+		for _, item := range v {
+			if jsonContainsString(item, s) {
+				return true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if jsonContainsString(item, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DeadLetterService stores dead letters in DynamoDB
+// Table key: id
+type DeadLetterService struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDeadLetterService creates a new dead letter service
+func NewDeadLetterService(region, tableName string) (*DeadLetterService, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DeadLetterService{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// RecordDeadLetter creates or replaces a dead letter
+func (s *DeadLetterService) RecordDeadLetter(letter models.DeadLetter) error {
+	item, err := attributevalue.MarshalMap(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %v", err)
+	}
+
+	start := time.Now()
+	_, err = s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	observeDynamoDB("PutDeadLetter", start, err)
+
+	if err != nil {
+		return fmt.Errorf("failed to record dead letter: %v", err)
+	}
+	return nil
+}
+
+// ListDeadLetters returns up to limit dead letters, most recent first.
+// The table is scanned, which is fine for a queue that should stay small.
+func (s *DeadLetterService) ListDeadLetters(limit int32) ([]models.DeadLetter, error) {
+	var letters []models.DeadLetter
+
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName: aws.String(s.tableName),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dead letters: %v", err)
+		}
+
+		var batch []models.DeadLetter
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal dead letters: %v", err)
+		}
+		letters = append(letters, batch...)
+	}
+
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.After(letters[j].FailedAt) })
+	if limit > 0 && int32(len(letters)) > limit {
+		letters = letters[:limit]
+	}
+
+	return letters, nil
+}
+
+// GetDeadLetter returns a dead letter by id
+func (s *DeadLetterService) GetDeadLetter(id string) (*models.DeadLetter, error) {
+	resp, err := s.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter: %v", err)
+	}
+	if resp.Item == nil {
+		return nil, fmt.Errorf("dead letter %s not found", id)
+	}
+
+	var letter models.DeadLetter
+	if err := attributevalue.UnmarshalMap(resp.Item, &letter); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dead letter: %v", err)
+	}
+	return &letter, nil
+}
+
+// DeleteDeadLetter removes a dead letter
+func (s *DeadLetterService) DeleteDeadLetter(id string) error {
+	_, err := s.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aslotsu/notification-worker/proto/eventpb"
+	"github.com/aslotsu/notification-worker/schema"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types accepted in the Content-Type NATS header
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// contentType returns the media type of a message, JSON when unset
+func contentType(msg *nats.Msg) (string, error) {
+	header := headerValue(msg.Header, "Content-Type")
+	if header == "" {
+		return ContentTypeJSON, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", fmt.Errorf("invalid Content-Type %q: %v", header, err)
+	}
+	return mediaType, nil
+}
+
+// decodeEvent decodes a message with the decoder matching its content
+// type, or as a CloudEvent when it carries ce-* headers
+func decodeEvent(msg *nats.Msg, receivedAt time.Time) (models.NotificationEvent, error) {
+	// Binary-mode CloudEvents keep their data's type in Content-Type
+	if isBinaryCloudEvent(msg) {
+		return decodeBinaryCloudEvent(msg, receivedAt)
+	}
+
+	mediaType, err := contentType(msg)
+	if err != nil {
+		return models.NotificationEvent{}, err
+	}
+
+	switch mediaType {
+	case ContentTypeCloudEventsJSON:
+		return decodeStructuredCloudEvent(msg.Data, receivedAt)
+	case ContentTypeJSON:
+		return schema.Decode(msg.Data, receivedAt)
+	case ContentTypeProtobuf:
+		return decodeProtobufEvent(msg.Data, receivedAt)
+	default:
+		return models.NotificationEvent{}, fmt.Errorf("unsupported Content-Type %q", mediaType)
+	}
+}
+
+// decodeProtobufEvent decodes an eventpb.NotificationEvent. Protobuf
+// payloads mirror the current schema version, so nothing is upcast, but
+// they're validated against the same JSON Schema document as JSON events.
+func decodeProtobufEvent(data []byte, receivedAt time.Time) (models.NotificationEvent, error) {
+	event, err := unmarshalProtobufEvent(data)
+	if err != nil {
+		return event, err
+	}
+
+	if event.SchemaVersion == 0 {
+		event.SchemaVersion = schema.CurrentVersion
+	}
+	if event.SchemaVersion != schema.CurrentVersion {
+		return event, &schema.Error{Fields: []schema.FieldError{{
+			Field:   "schema_version",
+			Message: fmt.Sprintf("protobuf events must use version %d", schema.CurrentVersion),
+		}}}
+	}
+	if event.CreatedAt == 0 {
+		event.CreatedAt = receivedAt.Unix()
+	}
+
+	doc, err := protobufDocument(event)
+	if err != nil {
+		return event, err
+	}
+	if err := schema.Validate(schema.CurrentVersion, doc); err != nil {
+		return event, err
+	}
+
+	return event, nil
+}
+
+// unmarshalProtobufEvent converts an eventpb.NotificationEvent to the
+// model without validating it
+func unmarshalProtobufEvent(data []byte) (models.NotificationEvent, error) {
+	var msg eventpb.NotificationEvent
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return models.NotificationEvent{}, fmt.Errorf("invalid protobuf: %v", err)
+	}
+	return msg.ToModel(), nil
+}
+
+// protobufDocument converts a decoded protobuf event to the JSON document
+// schema.Validate expects. Proto3 can't tell an empty string from an unset
+// one, so empty strings are left out as if the producer never sent them.
+func protobufDocument(event models.NotificationEvent) (map[string]any, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode protobuf event: %v", err)
+	}
+	doc, err := schema.ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	for field, value := range doc {
+		if value == "" {
+			delete(doc, field)
+		}
+	}
+	return doc, nil
+}
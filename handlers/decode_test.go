@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aslotsu/notification-worker/proto/eventpb"
+	"github.com/aslotsu/notification-worker/schema"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+)
+
+// setEventField sets an event field by its JSON name
+func setEventField(event *models.NotificationEvent, name, value string) {
+	switch name {
+	case "event_id":
+		event.EventID = value
+	case "owner":
+		event.Owner = value
+	case "trigger_user":
+		event.TriggerUser = value
+	case "username":
+		event.Username = value
+	case "user_picture":
+		event.UserPicture = value
+	case "user_bio":
+		event.UserBio = value
+	case "resource_type":
+		event.ResourceType = value
+	case "resource_id":
+		event.ResourceID = value
+	case "source_id":
+		event.SourceID = value
+	case "excerpt":
+		event.Excerpt = value
+	case "locale":
+		event.Locale = value
+	}
+}
+
+// requiredOnlyEvent sets just the fields the v2 schema requires of an action
+func requiredOnlyEvent(action models.ActionType) models.NotificationEvent {
+	event := models.NotificationEvent{
+		SchemaVersion: schema.CurrentVersion,
+		Owner:         "owner-1",
+		Action:        action.ID,
+		ResourceType:  action.ResourceTypes[0],
+		ResourceID:    "resource-1",
+		CreatedAt:     1765318000,
+	}
+	for _, field := range action.RequiredFields {
+		if event.Field(field) == "" {
+			setEventField(&event, field, field+"-value")
+		}
+	}
+	return event
+}
+
+// fullEvent sets every field
+func fullEvent(action models.ActionType) models.NotificationEvent {
+	return models.NotificationEvent{
+		SchemaVersion: schema.CurrentVersion,
+		EventID:       "evt-1",
+		Owner:         "owner-1",
+		TriggerUser:   "user-2",
+		Username:      "Jane Doe",
+		UserPicture:   "https://cdn.exobook.test/jane.png",
+		UserBio:       "Astronomer",
+		Action:        action.ID,
+		ResourceType:  action.ResourceTypes[len(action.ResourceTypes)-1],
+		ResourceID:    "resource-1",
+		SourceID:      "comment-1",
+		Excerpt:       "Great post, café ☕",
+		Locale:        "fr",
+		CreatedAt:     1765318000,
+	}
+}
+
+// decodeBoth decodes an event sent as JSON and as protobuf
+func decodeBoth(t *testing.T, event models.NotificationEvent, jsonDoc map[string]any) (fromJSON, fromProto models.NotificationEvent, jsonErr, protoErr error) {
+	t.Helper()
+
+	jsonData, err := json.Marshal(jsonDoc)
+	if err != nil {
+		t.Fatalf("marshal JSON: %v", err)
+	}
+	protoData, err := proto.Marshal(eventpb.FromModel(event))
+	if err != nil {
+		t.Fatalf("marshal protobuf: %v", err)
+	}
+
+	receivedAt := time.Unix(1765318999, 0)
+	fromJSON, jsonErr = schema.Decode(jsonData, receivedAt)
+	fromProto, protoErr = decodeProtobufEvent(protoData, receivedAt)
+	return fromJSON, fromProto, jsonErr, protoErr
+}
+
+// jsonDocument encodes an event the way a JSON producer would, leaving out
+// empty fields
+func jsonDocument(t *testing.T, event models.NotificationEvent) map[string]any {
+	t.Helper()
+
+	doc, err := protobufDocument(event)
+	if err != nil {
+		t.Fatalf("encode event: %v", err)
+	}
+	return doc
+}
+
+func TestJSONAndProtobufDecodeToSameNotification(t *testing.T) {
+	for _, action := range models.ActionTypes() {
+		variants := map[string]models.NotificationEvent{
+			"required only": requiredOnlyEvent(action),
+			"all fields":    fullEvent(action),
+		}
+		for variant, event := range variants {
+			t.Run(action.Name+"/"+variant, func(t *testing.T) {
+				fromJSON, fromProto, jsonErr, protoErr := decodeBoth(t, event, jsonDocument(t, event))
+				if jsonErr != nil {
+					t.Fatalf("JSON decode: %v", jsonErr)
+				}
+				if protoErr != nil {
+					t.Fatalf("protobuf decode: %v", protoErr)
+				}
+
+				if !reflect.DeepEqual(fromJSON, fromProto) {
+					t.Errorf("events differ:\njson:     %+v\nprotobuf: %+v", fromJSON, fromProto)
+				}
+
+				jsonNotif := notificationFromEvent(fromJSON, fromJSON.EventID)
+				protoNotif := notificationFromEvent(fromProto, fromProto.EventID)
+				jsonNotif.Id, protoNotif.Id = "", ""
+				if !reflect.DeepEqual(jsonNotif, protoNotif) {
+					t.Errorf("notifications differ:\njson:     %+v\nprotobuf: %+v", jsonNotif, protoNotif)
+				}
+				if jsonNotif.Excerpt != event.Excerpt || !jsonNotif.CreatedAt.Equal(time.Unix(event.CreatedAt, 0)) {
+					t.Errorf("notification = %+v, want the event's excerpt and created_at", jsonNotif)
+				}
+			})
+		}
+	}
+}
+
+func TestProtobufEventsFailTheSameSchemaChecks(t *testing.T) {
+	for _, action := range models.ActionTypes() {
+		for _, field := range action.RequiredFields {
+			t.Run(action.Name+"/without "+field, func(t *testing.T) {
+				event := requiredOnlyEvent(action)
+				setEventField(&event, field, "")
+
+				doc := jsonDocument(t, event)
+				delete(doc, field)
+
+				_, _, jsonErr, protoErr := decodeBoth(t, event, doc)
+				assertSchemaFieldError(t, "JSON", jsonErr, field)
+				assertSchemaFieldError(t, "protobuf", protoErr, field)
+			})
+		}
+	}
+
+	t.Run("resource type not allowed for the action", func(t *testing.T) {
+		action, _ := models.LookupAction(models.ActionFollow)
+		event := requiredOnlyEvent(action)
+		event.ResourceType = models.ResourceTypeComment
+
+		_, _, jsonErr, protoErr := decodeBoth(t, event, jsonDocument(t, event))
+		assertSchemaFieldError(t, "JSON", jsonErr, "resource_type")
+		assertSchemaFieldError(t, "protobuf", protoErr, "resource_type")
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		event := requiredOnlyEvent(models.ActionTypes()[0])
+		event.Action = 999
+
+		_, _, jsonErr, protoErr := decodeBoth(t, event, jsonDocument(t, event))
+		assertSchemaFieldError(t, "JSON", jsonErr, "action")
+		assertSchemaFieldError(t, "protobuf", protoErr, "action")
+	})
+}
+
+// assertSchemaFieldError checks err is a schema error naming field
+func assertSchemaFieldError(t *testing.T, encoding string, err error, field string) {
+	t.Helper()
+
+	var schemaErr *schema.Error
+	if !errors.As(err, &schemaErr) {
+		t.Errorf("%s decode error = %v, want a schema error", encoding, err)
+		return
+	}
+	var fields []string
+	for _, f := range schemaErr.Fields {
+		if f.Field == field {
+			return
+		}
+		fields = append(fields, f.Field)
+	}
+	t.Errorf("%s schema error fields = %s, want %s", encoding, strings.Join(fields, ","), field)
+}
+
+func TestProtobufCloudEventTakesActionAndResourceFromEnvelope(t *testing.T) {
+	// The data leaves action and resource to the type and subject attributes
+	data, err := proto.Marshal(eventpb.FromModel(models.NotificationEvent{
+		Owner:       "owner-1",
+		TriggerUser: "user-2",
+		Username:    "Jane",
+	}))
+	if err != nil {
+		t.Fatalf("marshal protobuf: %v", err)
+	}
+
+	msg := nats.NewMsg("notifications.post.like")
+	msg.Data = data
+	msg.Header.Set("Content-Type", ContentTypeProtobuf)
+	msg.Header.Set("ce-specversion", "1.0")
+	msg.Header.Set("ce-id", "1")
+	msg.Header.Set("ce-source", "/api/likes")
+	msg.Header.Set("ce-type", CloudEventTypePrefix+"like_post")
+	msg.Header.Set("ce-subject", "POST/post-1")
+
+	event, err := decodeEvent(msg, time.Unix(1765318999, 0))
+	if err != nil {
+		t.Fatalf("decodeEvent: %v", err)
+	}
+	if event.Action != models.ActionLikePost || event.ResourceType != models.ResourceTypePost || event.ResourceID != "post-1" || event.Owner != "owner-1" {
+		t.Errorf("event = %+v, want a like_post of POST/post-1 for owner-1", event)
+	}
+}
+
+// cloudEventMsg builds a binary-mode CloudEvent with the given ce-* attributes
+func cloudEventMsg(contentType string, data []byte, attrs map[string]string) *nats.Msg {
+	msg := nats.NewMsg("notifications.post.like")
+	msg.Data = data
+	if contentType != "" {
+		msg.Header.Set("Content-Type", contentType)
+	}
+	for name, value := range attrs {
+		msg.Header.Set("ce-"+name, value)
+	}
+	return msg
+}
+
+func TestDecodeCloudEvents(t *testing.T) {
+	protoData, err := proto.Marshal(eventpb.FromModel(models.NotificationEvent{Owner: "owner-1", TriggerUser: "user-2"}))
+	if err != nil {
+		t.Fatalf("marshal protobuf: %v", err)
+	}
+	jsonData := []byte(`{"owner":"owner-1","trigger_user":"user-2"}`)
+	attrs := func(overrides map[string]string) map[string]string {
+		a := map[string]string{
+			"specversion": "1.0",
+			"id":          "1",
+			"source":      "/api/likes",
+			"type":        CloudEventTypePrefix + "like_post",
+			"subject":     "POST/post-1",
+			"time":        "2026-10-18T12:00:00Z",
+		}
+		for name, value := range overrides {
+			if value == "" {
+				delete(a, name)
+			} else {
+				a[name] = value
+			}
+		}
+		return a
+	}
+	structured := func(extra string) *nats.Msg {
+		msg := nats.NewMsg("notifications.post.like")
+		msg.Header.Set("Content-Type", ContentTypeCloudEventsJSON)
+		msg.Data = []byte(`{"specversion":"1.0","id":"1","source":"/api/likes","type":"` + CloudEventTypePrefix + `like_post","subject":"POST/post-1","time":"2026-10-18T12:00:00Z",` + extra + `}`)
+		return msg
+	}
+
+	tests := []struct {
+		name      string
+		msg       *nats.Msg
+		wantField string // Schema error field, empty for success
+	}{
+		{"structured JSON", structured(`"data":{"owner":"owner-1","trigger_user":"user-2"}`), ""},
+		{"structured protobuf", structured(`"datacontenttype":"application/x-protobuf","data_base64":"` + base64.StdEncoding.EncodeToString(protoData) + `"`), ""},
+		{"binary JSON", cloudEventMsg(ContentTypeJSON, jsonData, attrs(nil)), ""},
+		{"binary JSON without Content-Type", cloudEventMsg("", jsonData, attrs(nil)), ""},
+		{"binary protobuf", cloudEventMsg(ContentTypeProtobuf, protoData, attrs(nil)), ""},
+		{"unknown type", cloudEventMsg(ContentTypeJSON, jsonData, attrs(map[string]string{"type": CloudEventTypePrefix + "wave"})), "type"},
+		{"type without prefix", cloudEventMsg(ContentTypeJSON, jsonData, attrs(map[string]string{"type": "like_post"})), "type"},
+		{"missing subject", cloudEventMsg(ContentTypeJSON, jsonData, attrs(map[string]string{"subject": ""})), "resource_id"},
+		{"missing id", cloudEventMsg(ContentTypeJSON, jsonData, attrs(map[string]string{"id": ""})), "id"},
+		{"unsupported specversion", cloudEventMsg(ContentTypeJSON, jsonData, attrs(map[string]string{"specversion": "0.3"})), "specversion"},
+		{"unsupported media type", cloudEventMsg("text/plain", jsonData, attrs(nil)), "datacontenttype"},
+		{"structured unsupported media type", structured(`"datacontenttype":"application/xml","data":"<like/>"`), "datacontenttype"},
+		{"invalid time", cloudEventMsg(ContentTypeJSON, jsonData, attrs(map[string]string{"time": "yesterday"})), "time"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := decodeEvent(tt.msg, time.Unix(1765318999, 0))
+			if tt.wantField != "" {
+				assertSchemaFieldError(t, "CloudEvent", err, tt.wantField)
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeEvent: %v", err)
+			}
+
+			want := models.NotificationEvent{
+				SchemaVersion: schema.CurrentVersion,
+				EventID:       "/api/likes#1",
+				Owner:         "owner-1",
+				TriggerUser:   "user-2",
+				Action:        models.ActionLikePost,
+				ResourceType:  models.ResourceTypePost,
+				ResourceID:    "post-1",
+				CreatedAt:     time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC).Unix(),
+			}
+			if !reflect.DeepEqual(event, want) {
+				t.Errorf("event = %+v\nwant    %+v", event, want)
+			}
+		})
+	}
+}
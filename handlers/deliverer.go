@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"log/slog"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/pusher/pusher-http-go/v5"
+)
+
+// Deliverer sends a newly created notification over an extra channel
+// (web push, mobile push, webhooks, ...). Pusher is handled separately.
+type Deliverer interface {
+	Name() string
+	Deliver(notif models.Notification) error
+}
+
+// OnlineChecker reports whether a user is currently connected to Pusher
+type OnlineChecker interface {
+	IsOwnerOnline(owner string) bool
+}
+
+// AddDeliverer registers a deliverer that runs after every created notification
+func (s *NotificationService) AddDeliverer(d Deliverer) {
+	s.deliverers = append(s.deliverers, d)
+	slog.Info("registered deliverer", "deliverer", d.Name())
+}
+
+// runDeliverers sends the notification through every registered deliverer
+func (s *NotificationService) runDeliverers(notif models.Notification) {
+	for _, d := range s.deliverers {
+		go func(d Deliverer) {
+			if err := d.Deliver(notif); err != nil {
+				slog.Error("delivery failed", "deliverer", d.Name(), "owner", notif.Owner, "action_key", notif.ActionKey, "error", err)
+			}
+		}(d)
+	}
+}
+
+// IsOwnerOnline returns true if the owner's Pusher channel has subscribers.
+// Without Pusher configured every user is treated as offline.
+func (s *NotificationService) IsOwnerOnline(owner string) bool {
+	if s.pusherClient == nil {
+		return false
+	}
+
+	channel, err := s.pusherClient.Channel(pusherChannelName(owner), pusher.ChannelParams{})
+	if err != nil {
+		slog.Warn("failed to check pusher presence", "owner", owner, "error", err)
+		return false
+	}
+
+	return channel.Occupied
+}
+
+// pusherChannelName returns the Pusher channel a user listens on
+func pusherChannelName(owner string) string {
+	return "user-" + owner + "-notifications"
+}
+
+// notificationPayload builds the JSON payload shared by real-time channels
+// Matches frontend expectations for the Pusher "new-notification" event
+func notificationPayload(notif models.Notification) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            notif.Id,
+		"action":        notif.Action,
+		"username":      notif.UserName,
+		"user_id":       notif.UserId,
+		"user_pic":      notif.UserPic,
+		"resource_id":   notif.ResourceId,
+		"resource_type": notif.ResourceType,
+		"excerpt":       notif.Excerpt,
+		"title":         notif.Title,
+		"body":          notif.Body,
+		"read_status":   notif.ReadStatus,
+		"created_at":    notif.CreatedAt,
+		"action_key":    notif.ActionKey,
+	}
+}
+
+// LogDeliverer logs every notification instead of sending it anywhere.
+// Dev mode uses it to show what would be delivered.
+type LogDeliverer struct{}
+
+// Name returns the deliverer name
+func (LogDeliverer) Name() string {
+	return "log"
+}
+
+// Deliver logs the notification
+func (LogDeliverer) Deliver(notif models.Notification) error {
+	slog.Info("notification delivered",
+		"deliverer", "log",
+		"owner", notif.Owner,
+		"action", notif.Action,
+		"resource", notif.ResourceType+"/"+notif.ResourceId,
+		"title", notif.Title,
+		"body", notif.Body,
+	)
+	return nil
+}
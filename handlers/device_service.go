@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/nats-io/nats.go"
+)
+
+// NATS subjects used by the API to manage mobile devices
+const (
+	SubjectDeviceRegister   = "devices.register"
+	SubjectDeviceUnregister = "devices.unregister"
+)
+
+// DeviceStore is the registry of mobile devices per user
+type DeviceStore interface {
+	RegisterDevice(device models.Device) error
+	UnregisterDevice(owner, token string) error
+	ListDevices(owner string) ([]models.Device, error)
+}
+
+// DeviceService stores mobile devices in DynamoDB
+// Table key: owner (hash) + token (range)
+type DeviceService struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDeviceService creates a new device service
+func NewDeviceService(region, tableName string) (*DeviceService, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DeviceService{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// RegisterDevice creates or replaces a device
+func (s *DeviceService) RegisterDevice(device models.Device) error {
+	if device.CreatedAt.IsZero() {
+		device.CreatedAt = time.Now()
+	}
+
+	item, err := attributevalue.MarshalMap(device)
+	if err != nil {
+		return fmt.Errorf("failed to marshal device: %v", err)
+	}
+
+	_, err = s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register device: %v", err)
+	}
+
+	return nil
+}
+
+// UnregisterDevice removes a device
+func (s *DeviceService) UnregisterDevice(owner, token string) error {
+	_, err := s.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"owner": &types.AttributeValueMemberS{Value: owner},
+			"token": &types.AttributeValueMemberS{Value: token},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unregister device: %v", err)
+	}
+
+	return nil
+}
+
+// ListDevices returns every device registered by a user
+func (s *DeviceService) ListDevices(owner string) ([]models.Device, error) {
+	resp, err := s.client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner", // 'owner' is a reserved keyword
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %v", err)
+	}
+
+	var devices []models.Device
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, &devices); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal devices: %v", err)
+	}
+
+	return devices, nil
+}
+
+// SubscribeDeviceRegistrations listens for device register/unregister
+// messages from the API and applies them to the registry
+func SubscribeDeviceRegistrations(nc *nats.Conn, store DeviceStore) ([]*nats.Subscription, error) {
+	register, err := nc.Subscribe(SubjectDeviceRegister, func(msg *nats.Msg) {
+		var device models.Device
+		if err := json.Unmarshal(msg.Data, &device); err != nil {
+			slog.Error("failed to unmarshal device", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		if device.Owner == "" || device.Token == "" {
+			slog.Warn("invalid device: owner and token are required", "subject", msg.Subject)
+			return
+		}
+
+		if device.Platform != models.PlatformIOS && device.Platform != models.PlatformAndroid {
+			slog.Warn("invalid device: unknown platform", "subject", msg.Subject, "platform", device.Platform)
+			return
+		}
+
+		if err := store.RegisterDevice(device); err != nil {
+			slog.Error("device registry update failed", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		slog.Info("registered device", "owner", device.Owner, "platform", device.Platform)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unregister, err := nc.Subscribe(SubjectDeviceUnregister, func(msg *nats.Msg) {
+		var device models.Device
+		if err := json.Unmarshal(msg.Data, &device); err != nil {
+			slog.Error("failed to unmarshal device", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		if err := store.UnregisterDevice(device.Owner, device.Token); err != nil {
+			slog.Error("device registry update failed", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		slog.Info("unregistered device", "owner", device.Owner)
+	})
+	if err != nil {
+		register.Unsubscribe()
+		return nil, err
+	}
+
+	return []*nats.Subscription{register, unregister}, nil
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+//go:embed templates/digest.txt.tmpl templates/digest.html.tmpl
+var digestTemplates embed.FS
+
+// maxDigestNotifications caps how many notifications are read per owner
+const maxDigestNotifications = 50
+
+// NotificationReader lists stored notifications for an owner
+type NotificationReader interface {
+	GetNotificationsByOwner(owner string, limit int32) ([]models.Notification, error)
+}
+
+// digestData is the data passed to the digest templates
+type digestData struct {
+	Frequency     models.DigestFrequency
+	Since         time.Time
+	Notifications []models.Notification
+	Items         []digestItem
+}
+
+// digestItem is one line of a digest: notifications on the same resource
+// with the same action are grouped ("Jo and 3 others liked your post")
+type digestItem struct {
+	Text      string
+	Excerpt   string
+	UserPic   string
+	CreatedAt time.Time
+}
+
+// DigestRenderer renders digest emails from the embedded templates
+type DigestRenderer struct {
+	text     *texttemplate.Template
+	html     *htmltemplate.Template
+	messages *MessageRenderer
+}
+
+// NewDigestRenderer parses the digest templates
+func NewDigestRenderer(messages *MessageRenderer) (*DigestRenderer, error) {
+	text, err := texttemplate.ParseFS(digestTemplates, "templates/digest.txt.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse text digest template: %v", err)
+	}
+
+	html, err := htmltemplate.ParseFS(digestTemplates, "templates/digest.html.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse html digest template: %v", err)
+	}
+
+	return &DigestRenderer{text: text, html: html, messages: messages}, nil
+}
+
+// groupDigestItems aggregates notifications by action and resource, unless
+// the action's aggregation policy says otherwise, keeping the order of the
+// most recent notification in each group
+func (r *DigestRenderer) groupDigestItems(notifications []models.Notification, locale string) []digestItem {
+	var order []string
+	groups := make(map[string][]models.Notification)
+
+	for _, notif := range notifications {
+		key := fmt.Sprintf("%d#%s", notif.Action, notif.ResourceId)
+		if a, ok := models.LookupAction(notif.Action); ok && a.Aggregation == models.AggregateNone {
+			key = notif.Id
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], notif)
+	}
+
+	items := make([]digestItem, 0, len(order))
+	for _, key := range order {
+		group := groups[key]
+		latest := group[0]
+
+		item := digestItem{
+			Text:      r.messages.RenderAggregate(group, locale).Body,
+			UserPic:   latest.UserPic,
+			CreatedAt: latest.CreatedAt,
+		}
+		if !strings.Contains(item.Text, latest.Excerpt) {
+			// Some messages (replies, mentions) already quote the excerpt
+			item.Excerpt = latest.Excerpt
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// Render builds the email for a user's digest
+func (r *DigestRenderer) Render(pref models.NotificationPreference, since time.Time, notifications []models.Notification) (EmailMessage, error) {
+	data := digestData{
+		Frequency:     pref.DigestFrequency,
+		Since:         since,
+		Notifications: notifications,
+		Items:         r.groupDigestItems(notifications, pref.Locale),
+	}
+
+	var text, html bytes.Buffer
+	if err := r.text.Execute(&text, data); err != nil {
+		return EmailMessage{}, fmt.Errorf("failed to render text digest: %v", err)
+	}
+	if err := r.html.Execute(&html, data); err != nil {
+		return EmailMessage{}, fmt.Errorf("failed to render html digest: %v", err)
+	}
+
+	subject := fmt.Sprintf("You have %d new notifications on Exobook", len(notifications))
+	if len(notifications) == 1 {
+		subject = "You have 1 new notification on Exobook"
+	}
+
+	return EmailMessage{
+		To:       pref.Email,
+		Subject:  subject,
+		TextBody: text.String(),
+		HTMLBody: html.String(),
+	}, nil
+}
+
+// DigestJob periodically emails users a summary of their unread notifications
+type DigestJob struct {
+	notifications NotificationReader
+	preferences   PreferenceStore
+	mailer        Mailer
+	renderer      *DigestRenderer
+	interval      time.Duration
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewDigestJob creates a new digest job
+func NewDigestJob(notifications NotificationReader, preferences PreferenceStore, mailer Mailer, renderer *DigestRenderer, interval time.Duration) *DigestJob {
+	return &DigestJob{
+		notifications: notifications,
+		preferences:   preferences,
+		mailer:        mailer,
+		renderer:      renderer,
+		interval:      interval,
+	}
+}
+
+// Start runs the digest job in the background every interval
+func (j *DigestJob) Start() {
+	slog.Info("starting email digest job", "interval", j.interval)
+
+	j.stopCh = make(chan struct{})
+	j.wg.Add(1)
+
+	go func() {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := j.RunOnce(now); err != nil {
+					slog.Error("email digest run failed", "error", err)
+				}
+			case <-j.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the current run to finish and stops the job
+func (j *DigestJob) Stop() {
+	if j.stopCh == nil {
+		return
+	}
+
+	slog.Info("stopping email digest job")
+	close(j.stopCh)
+	j.wg.Wait()
+}
+
+// RunOnce sends every digest that is due at the given time
+func (j *DigestJob) RunOnce(now time.Time) error {
+	sent := 0
+
+	for _, frequency := range []models.DigestFrequency{models.DigestDaily, models.DigestWeekly} {
+		prefs, err := j.preferences.ListDigestPreferences(frequency)
+		if err != nil {
+			return err
+		}
+
+		for _, pref := range prefs {
+			if !pref.DigestDue(now) {
+				continue
+			}
+
+			ok, err := j.sendDigest(pref, now)
+			if err != nil {
+				// Keep going - one bad address shouldn't block everyone else
+				slog.Error("failed to send digest", "owner", pref.Owner, "error", err)
+				continue
+			}
+			if ok {
+				sent++
+			}
+		}
+	}
+
+	if sent > 0 {
+		slog.Info("sent email digests", "count", sent)
+	}
+
+	return nil
+}
+
+// sendDigest emails a single user and reports whether anything was sent
+func (j *DigestJob) sendDigest(pref models.NotificationPreference, now time.Time) (bool, error) {
+	since := pref.LastDigestSentAt
+	if since.IsZero() {
+		since = now.Add(-pref.DigestFrequency.Period())
+	}
+
+	notifications, err := j.notifications.GetNotificationsByOwner(pref.Owner, maxDigestNotifications)
+	if err != nil {
+		return false, err
+	}
+
+	unread := make([]models.Notification, 0, len(notifications))
+	for _, notif := range notifications {
+		if !notif.ReadStatus && notif.CreatedAt.After(since) && models.DefaultPreferenceFor(notif.Action).Email {
+			unread = append(unread, notif)
+		}
+	}
+
+	if len(unread) > 0 {
+		msg, err := j.renderer.Render(pref, since, unread)
+		if err != nil {
+			return false, err
+		}
+
+		if err := j.mailer.Send(msg); err != nil {
+			return false, err
+		}
+	}
+
+	// Record the run even when nothing was sent so the window moves forward
+	if err := j.preferences.MarkDigestSent(pref.Owner, now); err != nil {
+		return len(unread) > 0, err
+	}
+
+	return len(unread) > 0, nil
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// stubNotificationReader returns fixed notifications per owner
+type stubNotificationReader map[string][]models.Notification
+
+func (r stubNotificationReader) GetNotificationsByOwner(owner string, limit int32) ([]models.Notification, error) {
+	return r[owner], nil
+}
+
+// recordingPreferenceStore serves fixed preferences and records digests sent
+type recordingPreferenceStore struct {
+	prefs []models.NotificationPreference
+
+	mu   sync.Mutex
+	sent map[string]time.Time // owner -> sent at
+}
+
+func (s *recordingPreferenceStore) ListDigestPreferences(frequency models.DigestFrequency) ([]models.NotificationPreference, error) {
+	var matched []models.NotificationPreference
+	for _, pref := range s.prefs {
+		if pref.DigestFrequency == frequency {
+			matched = append(matched, pref)
+		}
+	}
+	return matched, nil
+}
+
+func (s *recordingPreferenceStore) MarkDigestSent(owner string, sentAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sent == nil {
+		s.sent = make(map[string]time.Time)
+	}
+	s.sent[owner] = sentAt
+	return nil
+}
+
+// newTestDigestJob creates a digest job mailing through a plain SMTP stand-in
+func newTestDigestJob(t *testing.T, reader NotificationReader, prefs *recordingPreferenceStore) (*DigestJob, *smtpStandIn) {
+	t.Helper()
+
+	server, _ := newSMTPStandIn(t, SMTPTLSNone)
+	mailer, err := NewSMTPMailer("127.0.0.1", server.port(), "", "", "Exobook <noreply@exobook.test>", SMTPTLSNone)
+	if err != nil {
+		t.Fatalf("NewSMTPMailer: %v", err)
+	}
+
+	messages, err := NewMessageRenderer("en")
+	if err != nil {
+		t.Fatalf("NewMessageRenderer: %v", err)
+	}
+	renderer, err := NewDigestRenderer(messages)
+	if err != nil {
+		t.Fatalf("NewDigestRenderer: %v", err)
+	}
+
+	return NewDigestJob(reader, prefs, mailer, renderer, time.Hour), server
+}
+
+func TestDigestJobSendDigest(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	lastSent := now.Add(-24 * time.Hour)
+
+	reader := stubNotificationReader{
+		"owner-1": {
+			{Id: "n1", Owner: "owner-1", UserId: "u1", UserName: "Jane", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-1", CreatedAt: now.Add(-time.Hour)},
+			{Id: "n2", Owner: "owner-1", UserId: "u2", UserName: "Omar", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-1", CreatedAt: now.Add(-2 * time.Hour)},
+			{Id: "n3", Owner: "owner-1", UserId: "u3", UserName: "Read", Action: models.ActionFollow, ResourceType: models.ResourceTypeUser, ResourceId: "owner-1", ReadStatus: true, CreatedAt: now.Add(-time.Hour)},
+			{Id: "n4", Owner: "owner-1", UserId: "u4", UserName: "Old", Action: models.ActionFollow, ResourceType: models.ResourceTypeUser, ResourceId: "owner-1", CreatedAt: lastSent.Add(-time.Hour)},
+			{Id: "n5", Owner: "owner-1", UserName: "Exobook", Action: models.ActionSystem, ResourceType: models.ResourceTypeSystem, ResourceId: "sys-1", Excerpt: "Push only", CreatedAt: now.Add(-time.Hour)},
+		},
+	}
+	prefs := &recordingPreferenceStore{}
+	job, server := newTestDigestJob(t, reader, prefs)
+
+	pref := models.NotificationPreference{
+		Owner:            "owner-1",
+		Email:            "jane@example.test",
+		Locale:           "en",
+		DigestFrequency:  models.DigestDaily,
+		LastDigestSentAt: lastSent,
+	}
+	sent, err := job.sendDigest(pref, now)
+	if err != nil {
+		t.Fatalf("sendDigest: %v", err)
+	}
+	if !sent {
+		t.Fatal("sendDigest reported nothing sent")
+	}
+
+	received := server.received()
+	if len(received) != 1 {
+		t.Fatalf("received %d messages, want 1", len(received))
+	}
+	if got := received[0].To; len(got) != 1 || got[0] != "jane@example.test" {
+		t.Errorf("RCPT TO = %v", got)
+	}
+
+	mail := parseReceivedMail(t, received[0].Data)
+	if subject := mail.Header.Get("Subject"); subject != "You have 2 new notifications on Exobook" {
+		t.Errorf("subject = %q", subject)
+	}
+
+	// Read, older than the last digest and push-only notifications are left out
+	text := mail.Parts["text/plain"]
+	for _, want := range []string{"You have 2 unread notifications on Exobook since Mar 9", "Jane and 1 other liked your post", "daily digest"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text part missing %q:\n%s", want, text)
+		}
+	}
+	for _, unwanted := range []string{"Read", "Old", "Push only"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("text part contains %q:\n%s", unwanted, text)
+		}
+	}
+	if html := mail.Parts["text/html"]; !strings.Contains(html, "Jane and 1 other liked your post") {
+		t.Errorf("html part missing the grouped like:\n%s", html)
+	}
+
+	if got, ok := prefs.sent["owner-1"]; !ok || !got.Equal(now) {
+		t.Errorf("MarkDigestSent = %v (called %v), want %v", got, ok, now)
+	}
+}
+
+func TestDigestJobSendDigestNothingUnread(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	reader := stubNotificationReader{
+		"owner-1": {
+			{Id: "n1", Owner: "owner-1", UserId: "u1", UserName: "Jane", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-1", ReadStatus: true, CreatedAt: now.Add(-time.Hour)},
+		},
+	}
+	prefs := &recordingPreferenceStore{}
+	job, server := newTestDigestJob(t, reader, prefs)
+
+	pref := models.NotificationPreference{Owner: "owner-1", Email: "jane@example.test", DigestFrequency: models.DigestDaily}
+	sent, err := job.sendDigest(pref, now)
+	if err != nil {
+		t.Fatalf("sendDigest: %v", err)
+	}
+	if sent {
+		t.Error("sendDigest reported a digest with nothing unread")
+	}
+	if received := server.received(); len(received) != 0 {
+		t.Errorf("received %d messages, want 0", len(received))
+	}
+
+	// The window still moves forward
+	if got, ok := prefs.sent["owner-1"]; !ok || !got.Equal(now) {
+		t.Errorf("MarkDigestSent = %v (called %v), want %v", got, ok, now)
+	}
+}
+
+func TestDigestJobRunOnceSkipsNotDue(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	reader := stubNotificationReader{
+		"due": {
+			{Id: "n1", Owner: "due", UserId: "u1", UserName: "Jane", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-1", CreatedAt: now.Add(-time.Hour)},
+		},
+		"recent": {
+			{Id: "n2", Owner: "recent", UserId: "u1", UserName: "Jane", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-2", CreatedAt: now.Add(-time.Hour)},
+		},
+	}
+	prefs := &recordingPreferenceStore{prefs: []models.NotificationPreference{
+		{Owner: "due", Email: "due@example.test", DigestFrequency: models.DigestWeekly, LastDigestSentAt: now.Add(-8 * 24 * time.Hour)},
+		{Owner: "recent", Email: "recent@example.test", DigestFrequency: models.DigestWeekly, LastDigestSentAt: now.Add(-24 * time.Hour)},
+	}}
+	job, server := newTestDigestJob(t, reader, prefs)
+
+	if err := job.RunOnce(now); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	received := server.received()
+	if len(received) != 1 || received[0].To[0] != "due@example.test" {
+		t.Fatalf("received %+v, want one digest to due@example.test", received)
+	}
+	if _, ok := prefs.sent["recent"]; ok {
+		t.Error("MarkDigestSent called for a digest that wasn't due")
+	}
+}
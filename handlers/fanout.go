@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/nats-io/nats.go"
+	"github.com/pusher/pusher-http-go/v5"
+)
+
+// followerPageSize is how many followers a fan-out reads at once
+const followerPageSize = 250
+
+// Fan-out checkpoint counts
+const (
+	fanOutFollowers = "followers"
+	fanOutNotified  = "notified"
+	fanOutCapped    = "capped"
+)
+
+// FollowerSource pages through the users following someone
+type FollowerSource interface {
+	// ListFollowers returns up to limit follower ids. An empty cursor
+	// starts at the beginning; the returned cursor is empty after the
+	// last page.
+	ListFollowers(ctx context.Context, userID, cursor string, limit int32) ([]string, string, error)
+}
+
+// FanOutService notifies every follower of a user who posted
+type FanOutService struct {
+	notifications *NotificationService
+	followers     FollowerSource
+	checkpoints   CheckpointStore
+	maxFollowers  int
+}
+
+// NewFanOutService creates a fan-out service notifying at most
+// maxFollowers followers per post, 0 for no limit. Progress is saved in
+// checkpoints so a redelivered event resumes an interrupted fan-out.
+func NewFanOutService(notifications *NotificationService, followers FollowerSource, checkpoints CheckpointStore, maxFollowers int) *FanOutService {
+	return &FanOutService{
+		notifications: notifications,
+		followers:     followers,
+		checkpoints:   checkpoints,
+		maxFollowers:  maxFollowers,
+	}
+}
+
+// HandleFanOut notifies the followers of a user who posted. Register it
+// with NotificationWorker.HandleAction.
+func (s *FanOutService) HandleFanOut(ctx context.Context, msg *nats.Msg) error {
+	var event models.FanOutEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return &ValidationError{Field: "payload", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+
+	action, _ := models.LookupAction(models.ActionNewPost)
+	if event.ResourceType == "" && len(action.ResourceTypes) == 1 {
+		event.ResourceType = action.ResourceTypes[0]
+	}
+	if strings.TrimSpace(event.TriggerUser) == "" {
+		return &ValidationError{Field: "trigger_user", Message: "trigger_user is required"}
+	}
+	if strings.TrimSpace(event.ResourceID) == "" {
+		return &ValidationError{Field: "resource_id", Message: "resource_id is required"}
+	}
+	if !action.AllowsResourceType(event.ResourceType) {
+		return &ValidationError{
+			Field:   "resource_type",
+			Message: fmt.Sprintf("%s does not apply to %s (allowed: %s)", action.Name, event.ResourceType, strings.Join(action.ResourceTypes, ", ")),
+		}
+	}
+
+	checkpoint, err := s.FanOut(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("fanned out post to followers",
+		"trigger_user", event.TriggerUser,
+		"resource_id", event.ResourceID,
+		"event_id", event.EventID,
+		"followers", checkpoint.Counts[fanOutFollowers],
+		"notified", checkpoint.Counts[fanOutNotified],
+		"capped", checkpoint.Counts[fanOutCapped] > 0,
+	)
+	return nil
+}
+
+// FanOut writes a new_post notification for each follower of the trigger
+// user, a page of followers at a time, and sends them over Pusher.
+// Progress is checkpointed after every page: a fan-out that failed part
+// way resumes from its checkpoint, and a finished one is not redone.
+// Notification ids are derived from the owner and post, so a page written
+// twice replaces rather than duplicates.
+func (s *FanOutService) FanOut(ctx context.Context, event models.FanOutEvent) (*models.Checkpoint, error) {
+	id := event.CheckpointID()
+
+	checkpoint, err := s.checkpoints.GetCheckpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil && checkpoint.Done {
+		slog.Info("post already fanned out, skipping", "trigger_user", event.TriggerUser, "resource_id", event.ResourceID)
+		return checkpoint, nil
+	}
+	if checkpoint == nil {
+		checkpoint = &models.Checkpoint{Id: id, Phase: fanOutFollowers}
+	} else {
+		slog.Info("resuming fan-out", "trigger_user", event.TriggerUser, "resource_id", event.ResourceID, "followers", checkpoint.Counts[fanOutFollowers])
+	}
+
+	createdAt := time.Now()
+	if event.CreatedAt > 0 {
+		createdAt = time.Unix(event.CreatedAt, 0)
+	}
+
+	for !checkpoint.Done {
+		limit := followerPageSize
+		if s.maxFollowers > 0 {
+			limit = min(limit, s.maxFollowers-checkpoint.Counts[fanOutFollowers])
+		}
+
+		// A checkpoint saved under a higher FANOUT_MAX_FOLLOWERS may already
+		// be past the cap; it is capped below without reading more
+		var followers []string
+		cursor := checkpoint.Cursor
+		if limit > 0 {
+			followers, cursor, err = s.followers.ListFollowers(ctx, event.TriggerUser, checkpoint.Cursor, int32(limit))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		notifications := make([]models.Notification, 0, len(followers))
+		for _, follower := range followers {
+			if follower == event.TriggerUser {
+				continue
+			}
+			notifications = append(notifications, s.newPostNotification(event, follower, createdAt))
+		}
+
+		if len(notifications) > 0 {
+			if err := s.notifications.store.PutNotifications(ctx, notifications); err != nil {
+				return nil, err
+			}
+			fanOutNotificationsCreated.Add(float64(len(notifications)))
+			s.notifications.triggerPusherCreated(ctx, notifications)
+		}
+
+		checkpoint.Add(fanOutFollowers, len(followers))
+		checkpoint.Add(fanOutNotified, len(notifications))
+		checkpoint.Cursor = cursor
+
+		switch {
+		case cursor == "":
+			checkpoint.Done = true
+		case s.maxFollowers > 0 && checkpoint.Counts[fanOutFollowers] >= s.maxFollowers:
+			// Huge accounts: later followers see the post in their feed instead
+			checkpoint.Done = true
+			checkpoint.Add(fanOutCapped, 1)
+			fanOutsCapped.Inc()
+			slog.Warn("fan-out capped", "trigger_user", event.TriggerUser, "resource_id", event.ResourceID, "max_followers", s.maxFollowers)
+		}
+		if checkpoint.Done {
+			checkpoint.Phase = "done"
+		}
+
+		checkpoint.UpdatedAt = time.Now()
+		if err := s.checkpoints.SaveCheckpoint(ctx, checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	return checkpoint, nil
+}
+
+// newPostNotification builds a follower's notification with an id
+// derived from the follower and post
+func (s *FanOutService) newPostNotification(event models.FanOutEvent, follower string, createdAt time.Time) models.Notification {
+	notif := models.Notification{
+		Owner:        follower,
+		UserId:       event.TriggerUser,
+		UserName:     event.Username,
+		UserPic:      event.UserPicture,
+		UserBio:      event.UserBio,
+		Action:       models.ActionNewPost,
+		ResourceType: event.ResourceType,
+		ResourceId:   event.ResourceID,
+		Excerpt:      event.Excerpt,
+		EventId:      event.EventID,
+		CreatedAt:    createdAt,
+	}
+	s.notifications.prepare(&notif)
+	notif.Id = derivedNotificationID(notif.Owner, notif.ActionKey)
+	return notif
+}
+
+// triggerPusherCreated sends new-notification to each owner's channel
+func (s *NotificationService) triggerPusherCreated(ctx context.Context, notifications []models.Notification) {
+	if s.pusherClient == nil {
+		return
+	}
+
+	events := make([]pusher.Event, len(notifications))
+	for i, notif := range notifications {
+		events[i] = pusher.Event{
+			Channel: pusherChannelName(notif.Owner),
+			Name:    "new-notification",
+			Data:    notificationPayload(notif),
+		}
+	}
+	s.triggerPusherBatch(ctx, events)
+}
+
+// MemoryFollowerSource keeps followers in memory, for dev mode
+type MemoryFollowerSource struct {
+	followers map[string][]string // user id -> follower ids, sorted
+}
+
+// NewMemoryFollowerSource creates a follower source from user id -> followers
+func NewMemoryFollowerSource(followers map[string][]string) *MemoryFollowerSource {
+	sorted := make(map[string][]string, len(followers))
+	for user, ids := range followers {
+		ids = append([]string(nil), ids...)
+		sort.Strings(ids)
+		sorted[user] = ids
+	}
+	return &MemoryFollowerSource{followers: sorted}
+}
+
+// ListFollowers pages through followers in id order. The cursor is the
+// last id returned.
+func (s *MemoryFollowerSource) ListFollowers(ctx context.Context, userID, cursor string, limit int32) ([]string, string, error) {
+	followers, next := pageSortedIDs(s.followers[userID], cursor, limit)
+	return followers, next, nil
+}
+
+// pageSortedIDs returns the page of sorted ids after cursor, the last id
+// of the previous page, and the cursor for the next page
+func pageSortedIDs(ids []string, cursor string, limit int32) ([]string, string) {
+	start := sort.SearchStrings(ids, cursor)
+	if start < len(ids) && ids[start] == cursor {
+		start++
+	}
+	ids = ids[start:]
+
+	if limit <= 0 || len(ids) <= int(limit) {
+		return ids, ""
+	}
+	ids = ids[:limit]
+	return ids, ids[limit-1]
+}
+
+// DynamoDBFollowerSource reads followers from a DynamoDB table.
+// Table key: user_id (hash) + follower_id (range).
+type DynamoDBFollowerSource struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBFollowerSource creates a DynamoDB-backed follower source
+func NewDynamoDBFollowerSource(region, tableName string) (*DynamoDBFollowerSource, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DynamoDBFollowerSource{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// ListFollowers queries one page of followers. The cursor is the page's
+// LastEvaluatedKey, encoded.
+func (s *DynamoDBFollowerSource) ListFollowers(ctx context.Context, userID, cursor string, limit int32) ([]string, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := time.Now()
+	resp, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("user_id = :user"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user": &types.AttributeValueMemberS{Value: userID},
+		},
+		ProjectionExpression: aws.String("user_id, follower_id"),
+		ExclusiveStartKey:    startKey,
+		Limit:                aws.Int32(limit),
+	})
+	observeDynamoDB("Query", start, err)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query followers: %v", err)
+	}
+
+	followers := make([]string, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		if follower, ok := item["follower_id"].(*types.AttributeValueMemberS); ok {
+			followers = append(followers, follower.Value)
+		}
+	}
+
+	next, err := encodeCursor(resp.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return followers, next, nil
+}
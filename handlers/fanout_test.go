@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// pageRecordingFollowers records the cursor and limit of each page read
+type pageRecordingFollowers struct {
+	*MemoryFollowerSource
+	cursors []string
+	limits  []int32
+}
+
+func (f *pageRecordingFollowers) ListFollowers(ctx context.Context, userID, cursor string, limit int32) ([]string, string, error) {
+	f.cursors = append(f.cursors, cursor)
+	f.limits = append(f.limits, limit)
+	return f.MemoryFollowerSource.ListFollowers(ctx, userID, cursor, limit)
+}
+
+// testFollowers returns n follower ids of author-1, in sorted order
+func testFollowers(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("follower-%04d", i)
+	}
+	return ids
+}
+
+// newTestFanOut returns a fan-out over followers with a memory store and
+// checkpoint store
+func newTestFanOut(t *testing.T, followers []string, maxFollowers int) (*FanOutService, *MemoryNotificationStore, *pageRecordingFollowers, *MemoryCheckpointStore) {
+	t.Helper()
+	store, err := NewMemoryNotificationStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryNotificationStore: %v", err)
+	}
+	source := &pageRecordingFollowers{MemoryFollowerSource: NewMemoryFollowerSource(map[string][]string{"author-1": followers})}
+	checkpoints := NewMemoryCheckpointStore()
+	service := NewNotificationServiceWithStore(store, "", "", "", "")
+	return NewFanOutService(service, source, checkpoints, maxFollowers), store, source, checkpoints
+}
+
+var testPost = models.FanOutEvent{TriggerUser: "author-1", Username: "Jane", ResourceType: models.ResourceTypePost, ResourceID: "post-1"}
+
+// notifiedOwners returns the owners of the stored new_post notifications
+func notifiedOwners(t *testing.T, store *MemoryNotificationStore, followers []string) []string {
+	t.Helper()
+	var owners []string
+	for _, follower := range followers {
+		notifications, err := store.ListNotifications(context.Background(), follower, 0)
+		if err != nil {
+			t.Fatalf("ListNotifications: %v", err)
+		}
+		for _, notif := range notifications {
+			if notif.Action == models.ActionNewPost && notif.ResourceId == testPost.ResourceID {
+				owners = append(owners, notif.Owner)
+			}
+		}
+	}
+	return owners
+}
+
+func TestFanOutPagesThroughFollowers(t *testing.T) {
+	followers := testFollowers(2*followerPageSize + 10)
+	fanOut, store, source, _ := newTestFanOut(t, followers, 0)
+
+	checkpoint, err := fanOut.FanOut(context.Background(), testPost)
+	if err != nil {
+		t.Fatalf("FanOut: %v", err)
+	}
+
+	if !checkpoint.Done || checkpoint.Counts[fanOutNotified] != len(followers) || checkpoint.Counts[fanOutCapped] != 0 {
+		t.Errorf("checkpoint = %+v, want done with %d notified", checkpoint, len(followers))
+	}
+	wantCursors := []string{"", followers[followerPageSize-1], followers[2*followerPageSize-1]}
+	if !slices.Equal(source.cursors, wantCursors) {
+		t.Errorf("cursors = %q, want %q", source.cursors, wantCursors)
+	}
+	if owners := notifiedOwners(t, store, followers); !slices.Equal(owners, followers) {
+		t.Errorf("notified %d followers, want %d", len(owners), len(followers))
+	}
+}
+
+func TestFanOutStopsAtMaxFollowers(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    int
+		limits []int32
+		capped int
+	}{
+		{"cap within the first page", 100, []int32{100}, 1},
+		{"cap within a later page", followerPageSize + 50, []int32{followerPageSize, 50}, 1},
+		{"cap on a page boundary", 2 * followerPageSize, []int32{followerPageSize, followerPageSize}, 1},
+		{"cap above the follower count", 1000, []int32{followerPageSize, followerPageSize, followerPageSize}, 0},
+	}
+
+	followers := testFollowers(600)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fanOut, store, source, _ := newTestFanOut(t, followers, tt.max)
+
+			checkpoint, err := fanOut.FanOut(context.Background(), testPost)
+			if err != nil {
+				t.Fatalf("FanOut: %v", err)
+			}
+
+			if !slices.Equal(source.limits, tt.limits) {
+				t.Errorf("limits = %v, want %v", source.limits, tt.limits)
+			}
+			want := min(tt.max, len(followers))
+			if checkpoint.Counts[fanOutFollowers] != want || checkpoint.Counts[fanOutCapped] != tt.capped || !checkpoint.Done {
+				t.Errorf("counts = %v, done = %v, want %d followers, capped %d, done", checkpoint.Counts, checkpoint.Done, want, tt.capped)
+			}
+			if owners := notifiedOwners(t, store, followers); !slices.Equal(owners, followers[:want]) {
+				t.Errorf("notified %d followers, want the first %d", len(owners), want)
+			}
+		})
+	}
+}
+
+func TestFanOutResumesFromCheckpoint(t *testing.T) {
+	followers := testFollowers(400)
+	fanOut, store, source, checkpoints := newTestFanOut(t, followers, 350)
+	ctx := context.Background()
+
+	// An earlier delivery wrote the first page, then failed
+	saved := &models.Checkpoint{Id: testPost.CheckpointID(), Phase: fanOutFollowers, Cursor: followers[followerPageSize-1]}
+	saved.Add(fanOutFollowers, followerPageSize)
+	saved.Add(fanOutNotified, followerPageSize)
+	if err := checkpoints.SaveCheckpoint(ctx, saved); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+
+	checkpoint, err := fanOut.FanOut(ctx, testPost)
+	if err != nil {
+		t.Fatalf("FanOut: %v", err)
+	}
+
+	if !slices.Equal(source.cursors, []string{followers[followerPageSize-1]}) || !slices.Equal(source.limits, []int32{100}) {
+		t.Errorf("read pages at %q with limits %v, want one page of 100 after the cursor", source.cursors, source.limits)
+	}
+	if owners := notifiedOwners(t, store, followers); !slices.Equal(owners, followers[followerPageSize:350]) {
+		t.Errorf("notified %d followers, want only those after the cursor", len(owners))
+	}
+	if checkpoint.Counts[fanOutNotified] != 350 || checkpoint.Counts[fanOutCapped] != 1 {
+		t.Errorf("counts = %v, want 350 notified and capped", checkpoint.Counts)
+	}
+
+	// A finished fan-out is skipped
+	source.cursors = nil
+	if _, err := fanOut.FanOut(ctx, testPost); err != nil {
+		t.Fatalf("FanOut: %v", err)
+	}
+	if len(source.cursors) != 0 {
+		t.Errorf("done fan-out read followers at %q", source.cursors)
+	}
+}
+
+func TestFanOutResumesPastLoweredCap(t *testing.T) {
+	followers := testFollowers(400)
+	fanOut, _, source, checkpoints := newTestFanOut(t, followers, 100)
+	ctx := context.Background()
+
+	// Saved while FANOUT_MAX_FOLLOWERS was higher
+	saved := &models.Checkpoint{Id: testPost.CheckpointID(), Phase: fanOutFollowers, Cursor: followers[followerPageSize-1]}
+	saved.Add(fanOutFollowers, followerPageSize)
+	if err := checkpoints.SaveCheckpoint(ctx, saved); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+
+	checkpoint, err := fanOut.FanOut(ctx, testPost)
+	if err != nil {
+		t.Fatalf("FanOut: %v", err)
+	}
+	if len(source.limits) != 0 {
+		t.Errorf("read followers with limits %v, want none", source.limits)
+	}
+	if !checkpoint.Done || checkpoint.Counts[fanOutCapped] != 1 {
+		t.Errorf("checkpoint = %+v, want done and capped", checkpoint)
+	}
+}
+
+func TestFanOutSkipsSelfFollow(t *testing.T) {
+	followers := append(testFollowers(3), testPost.TriggerUser)
+	fanOut, store, _, _ := newTestFanOut(t, followers, 0)
+
+	checkpoint, err := fanOut.FanOut(context.Background(), testPost)
+	if err != nil {
+		t.Fatalf("FanOut: %v", err)
+	}
+
+	if checkpoint.Counts[fanOutFollowers] != 4 || checkpoint.Counts[fanOutNotified] != 3 {
+		t.Errorf("counts = %v, want 4 followers and 3 notified", checkpoint.Counts)
+	}
+	if owners := notifiedOwners(t, store, []string{testPost.TriggerUser}); len(owners) != 0 {
+		t.Error("the author was notified of their own post")
+	}
+}
+
+func TestFanOutRedoneWritesSameNotifications(t *testing.T) {
+	followers := testFollowers(10)
+	fanOut, store, _, _ := newTestFanOut(t, followers, 0)
+	ctx := context.Background()
+
+	if _, err := fanOut.FanOut(ctx, testPost); err != nil {
+		t.Fatalf("FanOut: %v", err)
+	}
+	// The checkpoint was lost, so every page is written again
+	fanOut.checkpoints = NewMemoryCheckpointStore()
+	if _, err := fanOut.FanOut(ctx, testPost); err != nil {
+		t.Fatalf("FanOut: %v", err)
+	}
+
+	if owners := notifiedOwners(t, store, followers); !slices.Equal(owners, followers) {
+		t.Errorf("notified %v, want each follower once", owners)
+	}
+}
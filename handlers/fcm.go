@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+const (
+	// DefaultFCMBaseURL is the production FCM HTTP v1 API
+	DefaultFCMBaseURL = "https://fcm.googleapis.com"
+
+	// fcmScope is the OAuth scope needed to send FCM messages
+	fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+)
+
+// fcmServiceAccount is the subset of a Google service account key file we use
+type fcmServiceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMSender sends Android pushes through the FCM HTTP v1 API
+type FCMSender struct {
+	baseURL    string
+	projectID  string
+	email      string
+	tokenURI   string
+	key        *rsa.PrivateKey
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMSender creates an FCM sender from a service account key file.
+// baseURL overrides the FCM API host (e.g. for a local stand-in).
+func NewFCMSender(credentialsFile, baseURL string) (*FCMSender, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fcm credentials: %v", err)
+	}
+
+	var account fcmServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("failed to parse fcm credentials: %v", err)
+	}
+
+	if account.ProjectID == "" || account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, fmt.Errorf("fcm credentials must contain project_id, client_email and token_uri")
+	}
+
+	parsed, err := parsePKCS8PrivateKey([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid fcm private key: %v", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("fcm private key must be an RSA key")
+	}
+
+	if baseURL == "" {
+		baseURL = DefaultFCMBaseURL
+	}
+
+	return &FCMSender{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		projectID:  account.ProjectID,
+		email:      account.ClientEmail,
+		tokenURI:   account.TokenURI,
+		key:        key,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Platform returns the platform handled by this sender
+func (s *FCMSender) Platform() string {
+	return models.PlatformAndroid
+}
+
+// Send delivers a message to a single registration token
+func (s *FCMSender) Send(device models.Device, msg MobileMessage) error {
+	token, err := s.token()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": device.Token,
+			"notification": map[string]string{
+				"title": msg.Title,
+				"body":  msg.Body,
+			},
+			"data": msg.Data,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal fcm message: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/projects/%s/messages:send", s.baseURL, s.projectID)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build fcm request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fcm request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if fcmTokenInvalid(resp.StatusCode, respBody) {
+		return ErrInvalidToken
+	}
+
+	return fmt.Errorf("fcm returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+}
+
+// fcmTokenInvalid reports whether an FCM error means the token is unusable
+func fcmTokenInvalid(status int, body []byte) bool {
+	if status == http.StatusNotFound {
+		return true
+	}
+
+	var resp struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return false
+	}
+
+	for _, detail := range resp.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return true
+		}
+	}
+
+	return resp.Error.Status == "INVALID_ARGUMENT" && strings.Contains(resp.Error.Message, "registration token")
+}
+
+// token returns a cached OAuth access token, refreshing it when needed
+func (s *FCMSender) token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && time.Now().Before(s.expiresAt) {
+		return s.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := signRS256JWT(s.key, map[string]interface{}{}, map[string]interface{}{
+		"iss":   s.email,
+		"scope": fcmScope,
+		"aud":   s.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.httpClient.PostForm(s.tokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", fmt.Errorf("fcm token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("fcm token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode fcm token: %v", err)
+	}
+
+	// Refresh a minute early to avoid racing the expiry
+	s.accessToken = token.AccessToken
+	s.expiresAt = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+
+	return s.accessToken, nil
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// readinessTimeout bounds how long dependency checks may take
+const readinessTimeout = 3 * time.Second
+
+// CheckResult is the outcome of one readiness check
+type CheckResult struct {
+	Status string `json:"status"` // ok or failing
+	Detail string `json:"detail,omitempty"`
+}
+
+// HealthStatus is the JSON body returned by /readyz
+type HealthStatus struct {
+	Status   string                 `json:"status"` // ready, not_ready or draining
+	Draining bool                   `json:"draining"`
+	Checks   map[string]CheckResult `json:"checks"`
+}
+
+// HealthChecker serves liveness and readiness endpoints
+type HealthChecker struct {
+	nats          *nats.Conn
+	worker        *NotificationWorker
+	notifications *NotificationService
+	requirePusher bool
+	draining      atomic.Bool
+}
+
+// NewHealthChecker creates a new health checker.
+// When requirePusher is set, readiness fails without Pusher credentials.
+func NewHealthChecker(nc *nats.Conn, worker *NotificationWorker, notifService *NotificationService, requirePusher bool) *HealthChecker {
+	return &HealthChecker{
+		nats:          nc,
+		worker:        worker,
+		notifications: notifService,
+		requirePusher: requirePusher,
+	}
+}
+
+// SetDraining makes readiness fail so traffic and deploys move on
+func (h *HealthChecker) SetDraining() {
+	h.draining.Store(true)
+}
+
+// HandleHealthz reports that the process is alive
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleReadyz reports whether the worker can process events
+func (h *HealthChecker) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	status := h.Check(ctx)
+
+	code := http.StatusOK
+	if status.Status != "ready" {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeHealthJSON(w, code, status)
+}
+
+// Check runs every readiness check
+func (h *HealthChecker) Check(ctx context.Context) HealthStatus {
+	checks := map[string]CheckResult{
+		"nats":         h.checkNATS(),
+		"subscription": h.checkSubscription(),
+		"dynamodb":     h.checkDynamoDB(ctx),
+	}
+
+	if h.requirePusher {
+		checks["pusher"] = h.checkPusher()
+	}
+
+	status := HealthStatus{
+		Status:   "ready",
+		Draining: h.draining.Load(),
+		Checks:   checks,
+	}
+
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status.Status = "not_ready"
+		}
+	}
+
+	if status.Draining {
+		status.Status = "draining"
+	}
+
+	return status
+}
+
+// checkNATS verifies the NATS connection is established
+func (h *HealthChecker) checkNATS() CheckResult {
+	if h.nats.IsConnected() {
+		return CheckResult{Status: "ok", Detail: h.nats.ConnectedUrlRedacted()}
+	}
+	return CheckResult{Status: "failing", Detail: h.nats.Status().String()}
+}
+
+// checkSubscription verifies the worker is subscribed to events
+func (h *HealthChecker) checkSubscription() CheckResult {
+	if h.worker.SubscriptionValid() {
+		return CheckResult{Status: "ok"}
+	}
+	return CheckResult{Status: "failing", Detail: "not subscribed to every notification subject"}
+}
+
+// checkDynamoDB verifies the notifications table is reachable
+func (h *HealthChecker) checkDynamoDB(ctx context.Context) CheckResult {
+	tableStatus, err := h.notifications.DescribeTable(ctx)
+	if err != nil {
+		return CheckResult{Status: "failing", Detail: err.Error()}
+	}
+	return CheckResult{Status: "ok", Detail: tableStatus}
+}
+
+// checkPusher verifies Pusher credentials are configured
+func (h *HealthChecker) checkPusher() CheckResult {
+	if h.notifications.pusherClient != nil {
+		return CheckResult{Status: "ok"}
+	}
+	return CheckResult{Status: "failing", Detail: "pusher credentials not configured"}
+}
+
+// writeHealthJSON writes a JSON response
+func writeHealthJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// IdempotencyStore remembers which event ids have been processed, so a
+// redelivered or re-published event is handled once. This is separate
+// from action key dedup, which collapses distinct events about the same
+// action (e.g. liking a post twice).
+type IdempotencyStore interface {
+	// Claim marks an event id as processed. It returns false when the id
+	// was already claimed and has not expired.
+	Claim(ctx context.Context, eventID string) (bool, error)
+	// Release forgets a claim so a failed event can be retried
+	Release(ctx context.Context, eventID string) error
+}
+
+// MemoryIdempotencyStore keeps claimed ids in memory. Ids are only
+// remembered by this process, so it suits a single worker instance.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	claims    map[string]time.Time // event id -> expiry
+	lastSweep time.Time
+}
+
+// NewMemoryIdempotencyStore creates an in-memory store remembering ids for ttl
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		ttl:       ttl,
+		claims:    make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Claim marks an event id as processed
+func (s *MemoryIdempotencyStore) Claim(ctx context.Context, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// Drop expired ids now and then so the map doesn't grow forever
+	if now.Sub(s.lastSweep) > s.ttl {
+		for id, expiry := range s.claims {
+			if now.After(expiry) {
+				delete(s.claims, id)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if expiry, ok := s.claims[eventID]; ok && now.Before(expiry) {
+		return false, nil
+	}
+
+	s.claims[eventID] = now.Add(s.ttl)
+	return true, nil
+}
+
+// Release forgets a claim
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.claims, eventID)
+	return nil
+}
+
+// DynamoDBIdempotencyStore keeps claimed ids in a DynamoDB table shared by
+// every worker instance. Table key: id. DynamoDB TTL should be enabled on
+// expires_at; claims past expiry are reusable even before TTL deletes them.
+type DynamoDBIdempotencyStore struct {
+	client    *dynamodb.Client
+	tableName string
+	ttl       time.Duration
+}
+
+// NewDynamoDBIdempotencyStore creates a DynamoDB-backed idempotency store
+func NewDynamoDBIdempotencyStore(region, tableName string, ttl time.Duration) (*DynamoDBIdempotencyStore, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DynamoDBIdempotencyStore{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+		ttl:       ttl,
+	}, nil
+}
+
+// Claim conditionally writes the id, failing if an unexpired claim exists
+func (s *DynamoDBIdempotencyStore) Claim(ctx context.Context, eventID string) (bool, error) {
+	now := time.Now()
+
+	start := time.Now()
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]types.AttributeValue{
+			"id":         &types.AttributeValueMemberS{Value: eventID},
+			"claimed_at": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+			"expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(s.ttl).Unix(), 10)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		observeDynamoDB("ClaimEvent", start, nil)
+		return false, nil
+	}
+	observeDynamoDB("ClaimEvent", start, err)
+
+	if err != nil {
+		return false, fmt.Errorf("failed to claim event %s: %v", eventID, err)
+	}
+	return true, nil
+}
+
+// Release deletes a claim
+func (s *DynamoDBIdempotencyStore) Release(ctx context.Context, eventID string) error {
+	start := time.Now()
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: eventID},
+		},
+	})
+	observeDynamoDB("ReleaseEvent", start, err)
+
+	if err != nil {
+		return fmt.Errorf("failed to release event %s: %v", eventID, err)
+	}
+	return nil
+}
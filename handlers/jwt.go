@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+)
+
+// signES256JWT signs a compact JWT with an ECDSA P-256 key (VAPID, APNs)
+func signES256JWT(key *ecdsa.PrivateKey, header, claims map[string]interface{}) (string, error) {
+	header["alg"] = "ES256"
+
+	signingInput, err := jwtSigningInput(header, claims)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign jwt: %v", err)
+	}
+
+	// JWS ES256 signatures are the fixed-size r || s concatenation
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// signRS256JWT signs a compact JWT with an RSA key (Google service accounts)
+func signRS256JWT(key *rsa.PrivateKey, header, claims map[string]interface{}) (string, error) {
+	header["alg"] = "RS256"
+
+	signingInput, err := jwtSigningInput(header, claims)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign jwt: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// jwtSigningInput encodes the header and claims segments of a JWT
+func jwtSigningInput(header, claims map[string]interface{}) (string, error) {
+	header["typ"] = "JWT"
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal jwt header: %v", err)
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal jwt claims: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c), nil
+}
+
+// parsePKCS8PrivateKey decodes a PEM encoded PKCS#8 private key
+func parsePKCS8PrivateKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+
+	return key, nil
+}
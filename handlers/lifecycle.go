@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/nats-io/nats.go"
+	"github.com/pusher/pusher-http-go/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Lifecycle subjects
+const (
+	SubjectResourceDeleted       = "notifications.resource.deleted"
+	SubjectNotificationDismissed = "notifications.dismissed"
+)
+
+// pusherBatchSize is the most events one Pusher batch trigger accepts
+const pusherBatchSize = 10
+
+// HandleResourceDeleted removes notifications about a deleted post or
+// comment. Register it with NotificationWorker.HandleLifecycle.
+func (s *NotificationService) HandleResourceDeleted(ctx context.Context, msg *nats.Msg) error {
+	var event models.ResourceDeletedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return &ValidationError{Field: "payload", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if strings.TrimSpace(event.ResourceID) == "" {
+		return &ValidationError{Field: "resource_id", Message: "resource_id is required"}
+	}
+
+	removed, err := s.RemoveResourceNotifications(ctx, event.ResourceType, event.ResourceID)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("removed notifications for deleted resource",
+		"resource_type", event.ResourceType,
+		"resource_id", event.ResourceID,
+		"event_id", event.EventID,
+		"removed", removed,
+	)
+	return nil
+}
+
+// RemoveResourceNotifications deletes every notification about a resource,
+// or caused by it (a deleted reply), across owners. resourceType narrows
+// resource matches when set. Affected owners get notification-removed
+// over Pusher. Returns how many notifications were deleted.
+//
+// Matches are read, deleted and announced one page at a time, so a post
+// with millions of likes isn't held in memory. No checkpoint is needed:
+// deleted notifications aren't found again, so a redelivery carries on
+// where a failed run stopped.
+func (s *NotificationService) RemoveResourceNotifications(ctx context.Context, resourceType, resourceID string) (int, error) {
+	removed := 0
+	for _, relation := range []ResourceRelation{RelationResource, RelationSource} {
+		cursor := ""
+		for {
+			page, next, err := s.store.ListByResource(ctx, relation, resourceID, cursor, userPageSize)
+			if err != nil {
+				return removed, err
+			}
+
+			var matched []models.Notification
+			for _, notif := range page {
+				if relation == RelationSource || resourceType == "" || notif.ResourceType == resourceType {
+					matched = append(matched, notif)
+				}
+			}
+
+			if err := s.removeNotifications(ctx, matched, models.RemovedResourceDeleted); err != nil {
+				return removed, err
+			}
+			removed += len(matched)
+
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+	return removed, nil
+}
+
+// HandleNotificationDismissed deletes a notification the owner dismissed.
+// Register it with NotificationWorker.HandleLifecycle.
+func (s *NotificationService) HandleNotificationDismissed(ctx context.Context, msg *nats.Msg) error {
+	var event models.NotificationDismissedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return &ValidationError{Field: "payload", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if strings.TrimSpace(event.Owner) == "" {
+		return &ValidationError{Field: "owner", Message: "owner is required"}
+	}
+	if strings.TrimSpace(event.ID) == "" {
+		return &ValidationError{Field: "id", Message: "id is required"}
+	}
+
+	err := s.Dismiss(ctx, event.Owner, event.ID)
+	if errors.Is(err, ErrNotificationNotFound) {
+		// Already dismissed (a redelivery) or never the owner's
+		slog.Info("dismissed notification not found", "owner", event.Owner, "id", event.ID, "event_id", event.EventID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("dismissed notification", "owner", event.Owner, "id", event.ID, "event_id", event.EventID)
+	return nil
+}
+
+// Dismiss deletes one of an owner's notifications and sends the owner
+// notification-removed over Pusher
+func (s *NotificationService) Dismiss(ctx context.Context, owner, id string) error {
+	notif, err := s.store.Dismiss(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+	notificationsRemoved.WithLabelValues(models.RemovedDismissed).Inc()
+
+	if s.pusherClient != nil {
+		s.triggerPusherRemoved(ctx, []models.Notification{notif}, models.RemovedDismissed)
+	}
+	return nil
+}
+
+// removeNotifications deletes notifications and tells their owners
+func (s *NotificationService) removeNotifications(ctx context.Context, notifications []models.Notification, reason string) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(notifications))
+	for i, notif := range notifications {
+		ids[i] = notif.Id
+	}
+
+	if err := s.store.DeleteNotifications(ctx, ids); err != nil {
+		return err
+	}
+	notificationsRemoved.WithLabelValues(reason).Add(float64(len(ids)))
+
+	if s.pusherClient != nil {
+		s.triggerPusherRemoved(ctx, notifications, reason)
+	}
+	return nil
+}
+
+// triggerPusherRemoved sends notification-removed to each owner's channel
+func (s *NotificationService) triggerPusherRemoved(ctx context.Context, notifications []models.Notification, reason string) {
+	events := make([]pusher.Event, len(notifications))
+	for i, notif := range notifications {
+		events[i] = pusher.Event{
+			Channel: pusherChannelName(notif.Owner),
+			Name:    "notification-removed",
+			Data: map[string]interface{}{
+				"id":            notif.Id,
+				"resource_id":   notif.ResourceId,
+				"resource_type": notif.ResourceType,
+				"reason":        reason,
+			},
+		}
+	}
+	s.triggerPusherBatch(ctx, events)
+}
+
+// triggerPusherBatch sends events in Pusher batch triggers of up to 10.
+// Failures are logged; the store changes already happened.
+func (s *NotificationService) triggerPusherBatch(ctx context.Context, events []pusher.Event) {
+	_, span := tracer.Start(ctx, "pusher trigger batch",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("pusher.events", len(events))),
+	)
+	defer span.End()
+
+	for len(events) > 0 {
+		n := min(len(events), pusherBatchSize)
+		batch := events[:n]
+		events = events[n:]
+
+		start := time.Now()
+		_, err := s.pusherClient.TriggerBatch(batch)
+		pusherDuration.WithLabelValues(statusLabel(err)).Observe(time.Since(start).Seconds())
+		if err != nil {
+			span.RecordError(err)
+			slog.Error("failed to trigger pusher batch", "event", batch[0].Name, "events", n, "error", err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/nats-io/nats.go"
+)
+
+// newTestMemoryService creates a service over an in-memory store holding
+// notifications, with Pusher pointed at a stand-in
+func newTestMemoryService(t *testing.T, notifications ...models.Notification) (*NotificationService, *MemoryNotificationStore, <-chan pusherRequest) {
+	t.Helper()
+
+	store, err := NewMemoryNotificationStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryNotificationStore: %v", err)
+	}
+	if err := store.PutNotifications(context.Background(), notifications); err != nil {
+		t.Fatalf("PutNotifications: %v", err)
+	}
+
+	service := NewNotificationServiceWithStore(store, "app-1", "key", "secret", "")
+	return service, store, usePusherStandIn(t, service)
+}
+
+// lifecycleMsg builds a lifecycle event message
+func lifecycleMsg(t *testing.T, subject string, payload any) *nats.Msg {
+	t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+	return &nats.Msg{Subject: subject, Data: data}
+}
+
+// storedIDs lists the ids an owner still has
+func storedIDs(t *testing.T, store *MemoryNotificationStore, owner string) []string {
+	t.Helper()
+
+	notifications, err := store.ListNotifications(context.Background(), owner, 0)
+	if err != nil {
+		t.Fatalf("ListNotifications: %v", err)
+	}
+	var ids []string
+	for _, notif := range notifications {
+		ids = append(ids, notif.Id)
+	}
+	return ids
+}
+
+func TestHandleNotificationDismissed(t *testing.T) {
+	now := time.Now()
+	service, store, triggered := newTestMemoryService(t,
+		models.Notification{Id: "notice-1", Owner: "owner-1", Action: models.ActionSystem, ResourceType: models.ResourceTypeSystem, ResourceId: "maintenance", CreatedAt: now},
+		models.Notification{Id: "notice-2", Owner: "owner-1", Action: models.ActionSystem, ResourceType: models.ResourceTypeSystem, ResourceId: "terms", CreatedAt: now.Add(-time.Minute)},
+		models.Notification{Id: "notice-3", Owner: "owner-2", Action: models.ActionSystem, ResourceType: models.ResourceTypeSystem, ResourceId: "maintenance", CreatedAt: now},
+	)
+	ctx := context.Background()
+
+	msg := lifecycleMsg(t, SubjectNotificationDismissed, models.NotificationDismissedEvent{Owner: "owner-1", ID: "notice-1"})
+	if err := service.HandleNotificationDismissed(ctx, msg); err != nil {
+		t.Fatalf("HandleNotificationDismissed: %v", err)
+	}
+
+	if ids := storedIDs(t, store, "owner-1"); len(ids) != 1 || ids[0] != "notice-2" {
+		t.Errorf("owner-1 notifications = %v, want [notice-2]", ids)
+	}
+
+	select {
+	case req := <-triggered:
+		body := string(req.Body)
+		if !strings.HasSuffix(req.Path, "/batch_events") || !strings.Contains(body, "notification-removed") ||
+			!strings.Contains(body, "notice-1") || !strings.Contains(body, models.RemovedDismissed) {
+			t.Errorf("pusher %s %s, want notification-removed for notice-1 with reason dismissed", req.Path, body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification-removed was never sent")
+	}
+
+	// Someone else's notification, or one already dismissed, is left alone
+	for _, event := range []models.NotificationDismissedEvent{
+		{Owner: "owner-1", ID: "notice-3"},
+		{Owner: "owner-1", ID: "notice-1"},
+	} {
+		if err := service.HandleNotificationDismissed(ctx, lifecycleMsg(t, SubjectNotificationDismissed, event)); err != nil {
+			t.Errorf("dismiss %s for %s: %v", event.ID, event.Owner, err)
+		}
+	}
+	if ids := storedIDs(t, store, "owner-2"); len(ids) != 1 {
+		t.Errorf("owner-2 notifications = %v, want notice-3 kept", ids)
+	}
+	select {
+	case req := <-triggered:
+		t.Errorf("unexpected pusher call %s %s", req.Path, req.Body)
+	default:
+	}
+}
+
+func TestHandleNotificationDismissedRejectsInvalidEvents(t *testing.T) {
+	service, _, _ := newTestMemoryService(t)
+
+	tests := []struct {
+		payload any
+		field   string
+	}{
+		{models.NotificationDismissedEvent{ID: "notice-1"}, "owner"},
+		{models.NotificationDismissedEvent{Owner: "owner-1"}, "id"},
+		{"not an object", "payload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			err := service.HandleNotificationDismissed(context.Background(), lifecycleMsg(t, SubjectNotificationDismissed, tt.payload))
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Field != tt.field {
+				t.Errorf("error = %v, want a validation error on %s", err, tt.field)
+			}
+		})
+	}
+}
+
+// pageRecordingStore records the size of every ListByResource page
+type pageRecordingStore struct {
+	*MemoryNotificationStore
+	pages []int
+}
+
+func (s *pageRecordingStore) ListByResource(ctx context.Context, relation ResourceRelation, resourceID, cursor string, limit int32) ([]models.Notification, string, error) {
+	page, next, err := s.MemoryNotificationStore.ListByResource(ctx, relation, resourceID, cursor, limit)
+	s.pages = append(s.pages, len(page))
+	return page, next, err
+}
+
+func TestRemoveResourceNotificationsPages(t *testing.T) {
+	memory, err := NewMemoryNotificationStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryNotificationStore: %v", err)
+	}
+
+	now := time.Now()
+	var notifications []models.Notification
+	for i := 0; i < 250; i++ {
+		notifications = append(notifications, models.Notification{
+			Id: fmt.Sprintf("like-%03d", i), Owner: fmt.Sprintf("owner-%d", i%7), Action: models.ActionLikePost,
+			ResourceType: models.ResourceTypePost, ResourceId: "post-1", CreatedAt: now,
+		})
+	}
+	for i := 0; i < 120; i++ {
+		notifications = append(notifications, models.Notification{
+			Id: fmt.Sprintf("mention-%03d", i), Owner: fmt.Sprintf("owner-%d", i), Action: models.ActionMention,
+			ResourceType: models.ResourceTypeComment, ResourceId: "comment-9", SourceId: "post-1", CreatedAt: now,
+		})
+	}
+	notifications = append(notifications,
+		models.Notification{Id: "other-type", Owner: "owner-1", Action: models.ActionLikeComment, ResourceType: models.ResourceTypeComment, ResourceId: "post-1", CreatedAt: now},
+		models.Notification{Id: "other-post", Owner: "owner-1", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-2", CreatedAt: now},
+	)
+	if err := memory.PutNotifications(context.Background(), notifications); err != nil {
+		t.Fatalf("PutNotifications: %v", err)
+	}
+
+	store := &pageRecordingStore{MemoryNotificationStore: memory}
+	service := NewNotificationServiceWithStore(store, "", "", "", "")
+
+	removed, err := service.RemoveResourceNotifications(context.Background(), models.ResourceTypePost, "post-1")
+	if err != nil {
+		t.Fatalf("RemoveResourceNotifications: %v", err)
+	}
+	if removed != 370 {
+		t.Errorf("removed %d notifications, want 370", removed)
+	}
+
+	for _, size := range store.pages {
+		if size > userPageSize {
+			t.Errorf("read a page of %d notifications, want at most %d", size, userPageSize)
+		}
+	}
+	if len(store.pages) < 5 {
+		t.Errorf("read %d pages, want the 370 matches split into pages of %d", len(store.pages), userPageSize)
+	}
+
+	var left []string
+	for owner := 0; owner < 120; owner++ {
+		left = append(left, storedIDs(t, memory, fmt.Sprintf("owner-%d", owner))...)
+	}
+	if strings.Join(left, ",") != "other-post,other-type" && strings.Join(left, ",") != "other-type,other-post" {
+		t.Errorf("notifications left = %v, want other-type and other-post", left)
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// EmailMessage is a multipart email with a text and an HTML body
+type EmailMessage struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(msg EmailMessage) error
+}
+
+// SMTP TLS modes
+const (
+	SMTPTLSNone     = "none"     // Plain connection (local relays only)
+	SMTPTLSStartTLS = "starttls" // Upgrade with STARTTLS after connecting
+	SMTPTLSImplicit = "tls"      // TLS from the first byte (usually port 465)
+)
+
+// SMTPMailer delivers email through an SMTP server
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	tlsMode  string
+	timeout  time.Duration
+
+	tlsConfig *tls.Config // Overrides the client TLS config, e.g. to trust a local test server
+}
+
+// NewSMTPMailer creates a new SMTP mailer
+func NewSMTPMailer(host string, port int, username, password, from, tlsMode string) (*SMTPMailer, error) {
+	if host == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %v", from, err)
+	}
+
+	switch tlsMode {
+	case SMTPTLSNone, SMTPTLSStartTLS, SMTPTLSImplicit:
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode: %s", tlsMode)
+	}
+
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+		tlsMode:  tlsMode,
+		timeout:  30 * time.Second,
+	}, nil
+}
+
+// Send delivers a message through the configured SMTP server
+func (m *SMTPMailer) Send(msg EmailMessage) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %v", err)
+	}
+
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address %q: %v", msg.To, err)
+	}
+
+	body, err := buildMIMEMessage(from, to, msg)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %v", err)
+	}
+
+	client, err := m.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.username != "" {
+		auth := smtp.PlainAuth("", m.username, m.password, m.host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("smtp MAIL FROM failed: %v", err)
+	}
+
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("smtp RCPT TO failed: %v", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA failed: %v", err)
+	}
+
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finish message: %v", err)
+	}
+
+	return client.Quit()
+}
+
+// dial connects to the SMTP server honoring the configured TLS mode
+func (m *SMTPMailer) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	tlsConfig := &tls.Config{ServerName: m.host}
+	if m.tlsConfig != nil {
+		tlsConfig = m.tlsConfig.Clone()
+	}
+	dialer := &net.Dialer{Timeout: m.timeout}
+
+	var conn net.Conn
+	var err error
+	if m.tlsMode == SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to smtp server %s: %v", addr, err)
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start smtp session: %v", err)
+	}
+
+	if m.tlsMode == SMTPTLSStartTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("smtp STARTTLS failed: %v", err)
+		}
+	}
+
+	return client, nil
+}
+
+// buildMIMEMessage renders a multipart/alternative message
+func buildMIMEMessage(from, to *mail.Address, msg EmailMessage) ([]byte, error) {
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n", boundary)
+	fmt.Fprintf(&buf, "\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.TextBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		if part.body == "" {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s\r\n", part.contentType)
+		fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n")
+		fmt.Fprintf(&buf, "\r\n")
+
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "\r\n")
+	}
+
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+// randomBoundary generates a MIME boundary string
+func randomBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate boundary: %v", err)
+	}
+	return "exobook-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receivedMail is a message accepted by the SMTP stand-in
+type receivedMail struct {
+	From string
+	To   []string
+	Data []byte
+	TLS  bool   // The message was sent over TLS
+	Auth string // Decoded AUTH PLAIN credentials, "" when not authenticated
+}
+
+// smtpStandIn is a minimal local SMTP server: enough of RFC 5321 for
+// net/smtp, with optional STARTTLS or implicit TLS
+type smtpStandIn struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	startTLS  bool
+
+	mu       sync.Mutex
+	messages []receivedMail
+}
+
+// newSMTPStandIn starts a stand-in for a TLS mode and returns it with a
+// client TLS config that trusts it
+func newSMTPStandIn(t *testing.T, tlsMode string) (*smtpStandIn, *tls.Config) {
+	t.Helper()
+
+	serverTLS, clientTLS := testTLSConfigs(t)
+
+	var listener net.Listener
+	var err error
+	if tlsMode == SMTPTLSImplicit {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	s := &smtpStandIn{
+		listener:  listener,
+		tlsConfig: serverTLS,
+		startTLS:  tlsMode == SMTPTLSStartTLS,
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return s, clientTLS
+}
+
+// port returns the port the stand-in listens on
+func (s *smtpStandIn) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// received returns the messages accepted so far
+func (s *smtpStandIn) received() []receivedMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMail(nil), s.messages...)
+}
+
+func (s *smtpStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(conn)
+	}
+}
+
+// session speaks SMTP on one connection
+func (s *smtpStandIn) session(conn net.Conn) {
+	defer conn.Close()
+
+	_, secure := conn.(*tls.Conn)
+	text := textproto.NewConn(conn)
+	reply := func(line string) { text.PrintfLine("%s", line) }
+
+	var msg receivedMail
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-localhost")
+			if s.startTLS && !secure {
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250-AUTH PLAIN")
+			reply("250 8BITMIME")
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+			text = textproto.NewConn(conn)
+			reply = func(line string) { text.PrintfLine("%s", line) }
+		case "AUTH":
+			_, encoded, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(encoded)
+			msg.Auth = string(decoded)
+			reply("235 authenticated")
+		case "MAIL":
+			msg.From = smtpPath(arg, "FROM:")
+			reply("250 ok")
+		case "RCPT":
+			msg.To = append(msg.To, smtpPath(arg, "TO:"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = data
+			msg.TLS = secure
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			msg = receivedMail{}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// smtpPath returns the address of a MAIL FROM or RCPT TO argument,
+// dropping parameters such as BODY=8BITMIME
+func smtpPath(arg, prefix string) string {
+	path, _, _ := strings.Cut(strings.TrimPrefix(arg, prefix), " ")
+	return strings.Trim(path, "<>")
+}
+
+// testTLSConfigs creates a self-signed certificate for 127.0.0.1 and
+// returns a server config using it and a client config trusting it
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "smtp stand-in"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+	return server, client
+}
+
+// parsedMail is a received message split into headers and decoded parts
+type parsedMail struct {
+	Header mail.Header
+	Parts  map[string]string // media type -> decoded body
+}
+
+// parseReceivedMail parses a multipart/alternative message
+func parseReceivedMail(t *testing.T, data []byte) parsedMail {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type = %q (%v), want multipart/alternative", msg.Header.Get("Content-Type"), err)
+	}
+
+	parsed := parsedMail{Header: msg.Header, Parts: make(map[string]string)}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		if enc := part.Header.Get("Content-Transfer-Encoding"); enc != "quoted-printable" {
+			t.Errorf("part encoding = %q, want quoted-printable", enc)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("decode part: %v", err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parsed.Parts[partType] = string(body)
+	}
+	return parsed
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	tests := []struct {
+		tlsMode string
+		wantTLS bool
+	}{
+		{SMTPTLSNone, false},
+		{SMTPTLSStartTLS, true},
+		{SMTPTLSImplicit, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tlsMode, func(t *testing.T) {
+			server, clientTLS := newSMTPStandIn(t, tt.tlsMode)
+
+			mailer, err := NewSMTPMailer("127.0.0.1", server.port(), "digest", "secret", "Exobook <noreply@exobook.test>", tt.tlsMode)
+			if err != nil {
+				t.Fatalf("NewSMTPMailer: %v", err)
+			}
+			mailer.tlsConfig = clientTLS
+
+			err = mailer.Send(EmailMessage{
+				To:       "Jane <jane@example.test>",
+				Subject:  "Café news",
+				TextBody: "Hello Jane",
+				HTMLBody: "<p>Hello Jane</p>",
+			})
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			received := server.received()
+			if len(received) != 1 {
+				t.Fatalf("received %d messages, want 1", len(received))
+			}
+			got := received[0]
+
+			if got.TLS != tt.wantTLS {
+				t.Errorf("sent over TLS = %v, want %v", got.TLS, tt.wantTLS)
+			}
+			if got.From != "noreply@exobook.test" {
+				t.Errorf("MAIL FROM = %q", got.From)
+			}
+			if len(got.To) != 1 || got.To[0] != "jane@example.test" {
+				t.Errorf("RCPT TO = %v", got.To)
+			}
+			if got.Auth != "\x00digest\x00secret" {
+				t.Errorf("AUTH PLAIN = %q", got.Auth)
+			}
+
+			parsed := parseReceivedMail(t, got.Data)
+			subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+			if err != nil || subject != "Café news" {
+				t.Errorf("subject = %q (%v)", subject, err)
+			}
+			if parsed.Parts["text/plain"] != "Hello Jane" {
+				t.Errorf("text part = %q", parsed.Parts["text/plain"])
+			}
+			if parsed.Parts["text/html"] != "<p>Hello Jane</p>" {
+				t.Errorf("html part = %q", parsed.Parts["text/html"])
+			}
+		})
+	}
+}
+
+func TestSMTPMailerStartTLSUntrusted(t *testing.T) {
+	server, _ := newSMTPStandIn(t, SMTPTLSStartTLS)
+
+	mailer, err := NewSMTPMailer("127.0.0.1", server.port(), "", "", "noreply@exobook.test", SMTPTLSStartTLS)
+	if err != nil {
+		t.Fatalf("NewSMTPMailer: %v", err)
+	}
+
+	// The default config doesn't trust the self-signed certificate
+	if err := mailer.Send(EmailMessage{To: "jane@example.test", Subject: "hi", TextBody: "hi"}); err == nil {
+		t.Fatal("Send succeeded with an untrusted certificate")
+	}
+	if received := server.received(); len(received) != 0 {
+		t.Errorf("received %d messages, want 0", len(received))
+	}
+}
+
+func TestNewSMTPMailerRejectsUnknownTLSMode(t *testing.T) {
+	if _, err := NewSMTPMailer("127.0.0.1", 25, "", "", "noreply@exobook.test", "ssl"); err == nil {
+		t.Fatal("NewSMTPMailer accepted an unknown tls mode")
+	}
+}
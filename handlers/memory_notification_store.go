@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// MemoryNotificationStore keeps notifications in memory for dev mode.
+// With a file path every change is also written to that JSON file, so
+// notifications survive restarts and CLI commands can read them.
+type MemoryNotificationStore struct {
+	mu            sync.Mutex
+	path          string
+	notifications map[string]models.Notification // id -> notification
+}
+
+// NewMemoryNotificationStore creates a store, loading path if it exists.
+// An empty path keeps notifications in memory only.
+func NewMemoryNotificationStore(path string) (*MemoryNotificationStore, error) {
+	store := &MemoryNotificationStore{
+		path:          path,
+		notifications: make(map[string]models.Notification),
+	}
+
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notifications file: %v", err)
+	}
+
+	var notifications []models.Notification
+	if err := json.Unmarshal(data, &notifications); err != nil {
+		return nil, fmt.Errorf("failed to parse notifications file %s: %v", path, err)
+	}
+	for _, notif := range notifications {
+		store.notifications[notif.Id] = notif
+	}
+
+	return store, nil
+}
+
+// PutNotification stores a notification
+func (s *MemoryNotificationStore) PutNotification(ctx context.Context, notif models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired notifications, as DynamoDB TTL would
+	now := time.Now()
+	for id, existing := range s.notifications {
+		if existing.Expired(now) {
+			delete(s.notifications, id)
+		}
+	}
+
+	s.notifications[notif.Id] = notif
+	return s.save()
+}
+
+// PutNotifications stores notifications
+func (s *MemoryNotificationStore) PutNotifications(ctx context.Context, notifications []models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, notif := range notifications {
+		s.notifications[notif.Id] = notif
+	}
+	return s.save()
+}
+
+// PutNewNotification stores a notification unless a live one has its id
+func (s *MemoryNotificationStore) PutNewNotification(ctx context.Context, notif models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.notifications[notif.Id]; ok && !existing.Expired(time.Now()) {
+		return fmt.Errorf("%w: %s", ErrDuplicateNotification, notif.Id)
+	}
+
+	s.notifications[notif.Id] = notif
+	return s.save()
+}
+
+// ListNotifications returns the owner's latest notifications
+func (s *MemoryNotificationStore) ListNotifications(ctx context.Context, owner string, limit int32) ([]models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notifications := s.ownedBy(owner)
+	if limit > 0 && len(notifications) > int(limit) {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+// MarkAsRead marks one of an owner's notifications as read
+func (s *MemoryNotificationStore) MarkAsRead(ctx context.Context, owner, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notif, ok := s.notifications[id]
+	if !ok || notif.Owner != owner {
+		return fmt.Errorf("notification %s not found for %s", id, owner)
+	}
+
+	notif.ReadStatus = true
+	s.notifications[id] = notif
+	return s.save()
+}
+
+// Dismiss deletes one of an owner's notifications and returns it
+func (s *MemoryNotificationStore) Dismiss(ctx context.Context, owner, id string) (models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notif, ok := s.notifications[id]
+	if !ok || notif.Owner != owner {
+		return models.Notification{}, fmt.Errorf("%w: %s for %s", ErrNotificationNotFound, id, owner)
+	}
+
+	delete(s.notifications, id)
+	return notif, s.save()
+}
+
+// MarkAllAsRead marks every unread notification of an owner as read
+func (s *MemoryNotificationStore) MarkAllAsRead(ctx context.Context, owner string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := 0
+	for id, notif := range s.notifications {
+		if notif.Owner == owner && !notif.ReadStatus {
+			notif.ReadStatus = true
+			s.notifications[id] = notif
+			updated++
+		}
+	}
+
+	if updated == 0 {
+		return 0, nil
+	}
+	return updated, s.save()
+}
+
+// ListByResource pages through the notifications about or caused by a
+// resource in id order. The cursor is the last id returned.
+func (s *MemoryNotificationStore) ListByResource(ctx context.Context, relation ResourceRelation, resourceID, cursor string, limit int32) ([]models.Notification, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var notifications []models.Notification
+	for _, notif := range s.notifications {
+		id := notif.ResourceId
+		if relation == RelationSource {
+			id = notif.SourceId
+		}
+		if id == resourceID && notif.Id > cursor {
+			notifications = append(notifications, notif)
+		}
+	}
+
+	return pageByID(notifications, limit)
+}
+
+// ListByUser pages through a user's notifications in id order. The
+// cursor is the last id returned.
+func (s *MemoryNotificationStore) ListByUser(ctx context.Context, relation UserRelation, userID, cursor string, limit int32) ([]models.Notification, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var notifications []models.Notification
+	for _, notif := range s.notifications {
+		user := notif.Owner
+		if relation == RelationTrigger {
+			user = notif.UserId
+		}
+		if user == userID && notif.Id > cursor {
+			notifications = append(notifications, notif)
+		}
+	}
+
+	return pageByID(notifications, limit)
+}
+
+// ListTriggeredSince pages through the notifications a user triggered
+// since a time
+func (s *MemoryNotificationStore) ListTriggeredSince(ctx context.Context, userID string, since time.Time, cursor string, limit int32) ([]models.Notification, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var notifications []models.Notification
+	for _, notif := range s.notifications {
+		if notif.UserId == userID && !notif.CreatedAt.Before(since) && notif.Id > cursor {
+			notifications = append(notifications, notif)
+		}
+	}
+
+	return pageByID(notifications, limit)
+}
+
+// pageByID sorts matches by id and returns the first page, with the last
+// id as the cursor when more remain
+func pageByID(notifications []models.Notification, limit int32) ([]models.Notification, string, error) {
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].Id < notifications[j].Id
+	})
+
+	if limit <= 0 || len(notifications) <= int(limit) {
+		return notifications, "", nil
+	}
+	notifications = notifications[:limit]
+	return notifications, notifications[limit-1].Id, nil
+}
+
+// UpdateSnapshots writes the trigger user fields and rendered text
+func (s *MemoryNotificationStore) UpdateSnapshots(ctx context.Context, notifications []models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, notif := range notifications {
+		existing, ok := s.notifications[notif.Id]
+		if !ok || existing.UserId != notif.UserId {
+			continue
+		}
+		existing.UserName = notif.UserName
+		existing.UserPic = notif.UserPic
+		existing.UserBio = notif.UserBio
+		existing.Title = notif.Title
+		existing.Body = notif.Body
+		s.notifications[notif.Id] = existing
+	}
+	return s.save()
+}
+
+// DeleteNotifications deletes notifications by id
+func (s *MemoryNotificationStore) DeleteNotifications(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.notifications, id)
+	}
+	return s.save()
+}
+
+// DescribeTable reports the store as always active
+func (s *MemoryNotificationStore) DescribeTable(ctx context.Context) (string, error) {
+	return "ACTIVE", nil
+}
+
+// ownedBy returns an owner's unexpired notifications, latest first.
+// Callers hold mu.
+func (s *MemoryNotificationStore) ownedBy(owner string) []models.Notification {
+	now := time.Now()
+	var notifications []models.Notification
+	for _, notif := range s.notifications {
+		if notif.Owner == owner && !notif.Expired(now) {
+			notifications = append(notifications, notif)
+		}
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		if !notifications[i].CreatedAt.Equal(notifications[j].CreatedAt) {
+			return notifications[i].CreatedAt.After(notifications[j].CreatedAt)
+		}
+		return notifications[i].Id > notifications[j].Id
+	})
+	return notifications
+}
+
+// save writes every notification to the file, if any. The file is
+// replaced atomically so readers never see a partial write. Callers hold mu.
+func (s *MemoryNotificationStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	notifications := make([]models.Notification, 0, len(s.notifications))
+	for _, notif := range s.notifications {
+		notifications = append(notifications, notif)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].Id < notifications[j].Id
+	})
+
+	data, err := json.MarshalIndent(notifications, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode notifications: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".notifications-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write notifications file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write notifications file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write notifications file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write notifications file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// SubjectContentCreated is published by the API when a post or comment is created
+const SubjectContentCreated = "content.created"
+
+// Mention outcomes, the label of the mentions metric
+const (
+	mentionCreated   = "created"
+	mentionDuplicate = "duplicate"
+	mentionSelf      = "self"
+	mentionUnknown   = "unknown"
+	mentionBlocked   = "blocked"
+	mentionCapped    = "capped"
+)
+
+// HandleResolver resolves @handles to user ids. Handles are lowercase;
+// unknown handles are left out of the result.
+type HandleResolver interface {
+	ResolveHandles(ctx context.Context, handles []string) (map[string]string, error)
+}
+
+// HandleResolverFunc adapts a function to HandleResolver
+type HandleResolverFunc func(ctx context.Context, handles []string) (map[string]string, error)
+
+// ResolveHandles calls f
+func (f HandleResolverFunc) ResolveHandles(ctx context.Context, handles []string) (map[string]string, error) {
+	return f(ctx, handles)
+}
+
+// BlockList reports whether a user blocked another
+type BlockList interface {
+	Blocked(ctx context.Context, owner, userID string) (bool, error)
+}
+
+// MentionService turns @mentions in new posts and comments into mention
+// notifications, one per mentioned user
+type MentionService struct {
+	notifications *NotificationService
+	resolver      HandleResolver
+	blocks        BlockList
+	maxMentions   int
+}
+
+// NewMentionService creates a mention service notifying at most
+// maxMentions users per post or comment, any number when 0
+func NewMentionService(notifications *NotificationService, resolver HandleResolver, maxMentions int) *MentionService {
+	return &MentionService{
+		notifications: notifications,
+		resolver:      resolver,
+		maxMentions:   maxMentions,
+	}
+}
+
+// SetBlockList skips mentions of users who blocked the author
+func (s *MentionService) SetBlockList(blocks BlockList) {
+	s.blocks = blocks
+}
+
+// HandleContentCreated notifies the users mentioned in a new post or
+// comment. Register it with NotificationWorker.HandleLifecycle.
+func (s *MentionService) HandleContentCreated(ctx context.Context, msg *nats.Msg) error {
+	var event models.ContentCreatedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return &ValidationError{Field: "payload", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if strings.TrimSpace(event.AuthorID) == "" {
+		return &ValidationError{Field: "author_id", Message: "author_id is required"}
+	}
+	if strings.TrimSpace(event.ResourceID) == "" {
+		return &ValidationError{Field: "resource_id", Message: "resource_id is required"}
+	}
+	if action, _ := models.LookupAction(models.ActionMention); !slices.Contains(action.ResourceTypes, event.ResourceType) {
+		return &ValidationError{Field: "resource_type", Message: fmt.Sprintf("resource_type must be one of %s", strings.Join(action.ResourceTypes, ", "))}
+	}
+
+	outcomes, err := s.NotifyMentions(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	if len(outcomes) > 0 {
+		slog.Info("processed mentions",
+			"author_id", event.AuthorID,
+			"resource_id", event.ResourceID,
+			"event_id", event.EventID,
+			"outcomes", outcomes,
+		)
+	}
+	return nil
+}
+
+// NotifyMentions creates a mention notification for each user mentioned
+// in the content, skipping the author, unknown handles, users who blocked
+// the author and handles past the per-content cap. Redelivered events
+// create no duplicates: mentions dedup by the content id. Returns how many
+// handles had each outcome.
+func (s *MentionService) NotifyMentions(ctx context.Context, event models.ContentCreatedEvent) (map[string]int, error) {
+	handles := event.Mentions()
+	if len(handles) == 0 {
+		return nil, nil
+	}
+
+	outcomes := make(map[string]int)
+	count := func(outcome string) {
+		outcomes[outcome]++
+		mentionsProcessed.WithLabelValues(outcome).Inc()
+	}
+
+	resolved, err := s.resolver.ResolveHandles(ctx, handles)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt := time.Now()
+	if event.CreatedAt > 0 {
+		createdAt = time.Unix(event.CreatedAt, 0)
+	}
+
+	notified := make(map[string]bool)
+	for _, handle := range handles {
+		userID, ok := resolved[handle]
+		switch {
+		case !ok || userID == "":
+			count(mentionUnknown)
+			continue
+		case userID == event.AuthorID:
+			count(mentionSelf)
+			continue
+		case notified[userID]:
+			continue // Two handles for the same user
+		case s.maxMentions > 0 && len(notified) >= s.maxMentions:
+			count(mentionCapped)
+			continue
+		}
+
+		if s.blocks != nil {
+			blocked, err := s.blocks.Blocked(ctx, userID, event.AuthorID)
+			if err != nil {
+				return outcomes, err
+			}
+			if blocked {
+				count(mentionBlocked)
+				continue
+			}
+		}
+		notified[userID] = true
+
+		err := s.notifications.CreateNotification(ctx, models.Notification{
+			Id:           uuid.New().String(),
+			Owner:        userID,
+			UserId:       event.AuthorID,
+			UserName:     event.AuthorName,
+			UserPic:      event.AuthorPicture,
+			UserBio:      event.AuthorBio,
+			Action:       models.ActionMention,
+			ResourceType: event.ResourceType,
+			ResourceId:   event.ResourceID,
+			SourceId:     event.ResourceID,
+			Excerpt:      event.Excerpt(),
+			EventId:      event.EventID,
+			CreatedAt:    createdAt,
+		})
+		if errors.Is(err, ErrDuplicateNotification) {
+			count(mentionDuplicate)
+			continue
+		}
+		if err != nil {
+			return outcomes, err
+		}
+		count(mentionCreated)
+	}
+
+	return outcomes, nil
+}
+
+// CachingHandleResolver remembers resolved handles, unknown ones
+// included, for ttl. It holds at most size handles, dropping expired
+// ones first and then everything when full.
+type CachingHandleResolver struct {
+	next    HandleResolver
+	ttl     time.Duration
+	size    int
+	mu      sync.Mutex
+	entries map[string]cachedHandle // handle -> user id
+}
+
+// cachedHandle is a resolved handle; an empty user id means unknown
+type cachedHandle struct {
+	userID  string
+	expires time.Time
+}
+
+// NewCachingHandleResolver wraps a resolver with a cache
+func NewCachingHandleResolver(next HandleResolver, ttl time.Duration, size int) *CachingHandleResolver {
+	return &CachingHandleResolver{
+		next:    next,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]cachedHandle),
+	}
+}
+
+// ResolveHandles answers from the cache and resolves the rest
+func (r *CachingHandleResolver) ResolveHandles(ctx context.Context, handles []string) (map[string]string, error) {
+	resolved := make(map[string]string, len(handles))
+	var missing []string
+
+	now := time.Now()
+	r.mu.Lock()
+	for _, handle := range handles {
+		entry, ok := r.entries[handle]
+		if !ok || now.After(entry.expires) {
+			missing = append(missing, handle)
+			continue
+		}
+		if entry.userID != "" {
+			resolved[handle] = entry.userID
+		}
+	}
+	r.mu.Unlock()
+
+	if len(missing) == 0 {
+		return resolved, nil
+	}
+
+	fetched, err := r.next.ResolveHandles(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.entries)+len(missing) > r.size {
+		for handle, entry := range r.entries {
+			if now.After(entry.expires) {
+				delete(r.entries, handle)
+			}
+		}
+		if len(r.entries)+len(missing) > r.size {
+			clear(r.entries)
+		}
+	}
+
+	for _, handle := range missing {
+		userID := fetched[handle]
+		r.entries[handle] = cachedHandle{userID: userID, expires: now.Add(r.ttl)}
+		if userID != "" {
+			resolved[handle] = userID
+		}
+	}
+	return resolved, nil
+}
+
+// batchGetSize is the most keys one BatchGetItem call accepts
+const batchGetSize = 100
+
+// DynamoDBHandleResolver looks handles up in a DynamoDB table.
+// Table key: handle (lowercase), with the user's id in user_id.
+type DynamoDBHandleResolver struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBHandleResolver creates a DynamoDB-backed handle resolver
+func NewDynamoDBHandleResolver(region, tableName string) (*DynamoDBHandleResolver, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DynamoDBHandleResolver{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// ResolveHandles reads handles in BatchGetItem batches, retrying
+// unprocessed keys
+func (r *DynamoDBHandleResolver) ResolveHandles(ctx context.Context, handles []string) (map[string]string, error) {
+	resolved := make(map[string]string, len(handles))
+
+	for len(handles) > 0 {
+		n := min(len(handles), batchGetSize)
+		keys := make([]map[string]types.AttributeValue, n)
+		for i, handle := range handles[:n] {
+			keys[i] = map[string]types.AttributeValue{
+				"handle": &types.AttributeValueMemberS{Value: handle},
+			}
+		}
+		handles = handles[n:]
+
+		pending := map[string]types.KeysAndAttributes{r.tableName: {
+			Keys:                 keys,
+			ProjectionExpression: aws.String("handle, user_id"),
+		}}
+		for attempt := 0; len(pending[r.tableName].Keys) > 0; attempt++ {
+			if attempt == batchWriteAttempts {
+				return nil, fmt.Errorf("%d handles still unprocessed after %d attempts", len(pending[r.tableName].Keys), attempt)
+			}
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(batchWriteBackoff << (attempt - 1)):
+				}
+			}
+
+			start := time.Now()
+			resp, err := r.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: pending})
+			observeDynamoDB("BatchGetItem", start, err)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve handles: %v", err)
+			}
+
+			for _, item := range resp.Responses[r.tableName] {
+				handle, _ := item["handle"].(*types.AttributeValueMemberS)
+				userID, _ := item["user_id"].(*types.AttributeValueMemberS)
+				if handle != nil && userID != nil {
+					resolved[handle.Value] = userID.Value
+				}
+			}
+			pending = resp.UnprocessedKeys
+		}
+	}
+
+	return resolved, nil
+}
+
+// DynamoDBBlockList reads blocks from a DynamoDB table.
+// Table key: owner (hash) + blocked (range), one item per blocked user.
+type DynamoDBBlockList struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBBlockList creates a DynamoDB-backed block list
+func NewDynamoDBBlockList(region, tableName string) (*DynamoDBBlockList, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DynamoDBBlockList{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// Blocked reports whether owner blocked userID
+func (b *DynamoDBBlockList) Blocked(ctx context.Context, owner, userID string) (bool, error) {
+	start := time.Now()
+	resp, err := b.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(b.tableName),
+		Key: map[string]types.AttributeValue{
+			"owner":   &types.AttributeValueMemberS{Value: owner},
+			"blocked": &types.AttributeValueMemberS{Value: userID},
+		},
+		ProjectionExpression: aws.String("#owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner", // 'owner' is a reserved keyword
+		},
+	})
+	observeDynamoDB("GetItem", start, err)
+	if err != nil {
+		return false, fmt.Errorf("failed to check block: %v", err)
+	}
+	return resp.Item != nil, nil
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// identityResolver resolves every handle to the user id of the same name
+var identityResolver = HandleResolverFunc(func(ctx context.Context, handles []string) (map[string]string, error) {
+	resolved := make(map[string]string, len(handles))
+	for _, handle := range handles {
+		resolved[handle] = handle
+	}
+	return resolved, nil
+})
+
+func TestNotifyMentionsCap(t *testing.T) {
+	tests := []struct {
+		maxMentions int
+		wantCreated int
+		wantCapped  int
+	}{
+		{0, 5, 0}, // No limit
+		{1, 1, 4},
+		{3, 3, 2},
+		{10, 5, 0},
+	}
+
+	event := models.ContentCreatedEvent{
+		AuthorID:     "author",
+		AuthorName:   "Author",
+		ResourceType: models.ResourceTypePost,
+		ResourceID:   "post-1",
+		Body:         "hi @ann @bob @cat @dan @eve",
+	}
+
+	for _, tt := range tests {
+		store, err := NewMemoryNotificationStore("")
+		if err != nil {
+			t.Fatalf("NewMemoryNotificationStore: %v", err)
+		}
+		mentions := NewMentionService(NewNotificationServiceWithStore(store, "", "", "", ""), identityResolver, tt.maxMentions)
+
+		outcomes, err := mentions.NotifyMentions(context.Background(), event)
+		if err != nil {
+			t.Fatalf("max %d: NotifyMentions: %v", tt.maxMentions, err)
+		}
+		if outcomes[mentionCreated] != tt.wantCreated || outcomes[mentionCapped] != tt.wantCapped {
+			t.Errorf("max %d: outcomes = %v, want %d created and %d capped", tt.maxMentions, outcomes, tt.wantCreated, tt.wantCapped)
+		}
+	}
+}
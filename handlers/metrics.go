@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes every series exposed by the worker
+const metricsNamespace = "notification_worker"
+
+// Pipeline counters, labelled by NATS subject and action
+var (
+	eventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_received_total",
+		Help:      "Events received from NATS.",
+	}, []string{"subject", "action"})
+
+	eventsInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_invalid_total",
+		Help:      "Events rejected because they could not be decoded or failed validation.",
+	}, []string{"subject", "action"})
+
+	eventsSelfSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_self_skipped_total",
+		Help:      "Events skipped because the owner triggered them.",
+	}, []string{"subject", "action"})
+
+	eventsDeduplicated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_deduplicated_total",
+		Help:      "Events skipped because a notification with the same action key exists.",
+	}, []string{"subject", "action"})
+
+	eventsRedelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_redelivered_total",
+		Help:      "Events skipped because their event id was already processed.",
+	}, []string{"subject", "action"})
+
+	eventsDeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_dead_lettered_total",
+		Help:      "Events recorded in the dead letter queue, by reason.",
+	}, []string{"subject", "reason"})
+
+	notificationsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_created_total",
+		Help:      "Notifications stored in DynamoDB.",
+	}, []string{"subject", "action"})
+
+	notificationsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_failed_total",
+		Help:      "Events that could not be stored as notifications.",
+	}, []string{"subject", "action"})
+)
+
+// Lifecycle counters
+var (
+	lifecycleEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "lifecycle_events_total",
+		Help:      "Lifecycle events (resource deleted, ...) by outcome: processed, invalid or failed.",
+	}, []string{"subject", "outcome"})
+
+	notificationsRemoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_removed_total",
+		Help:      "Notifications deleted by lifecycle events, by reason.",
+	}, []string{"reason"})
+
+	mentionsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "mentions_total",
+		Help:      "Mentions found in new content by outcome: created, duplicate, self, unknown, blocked or capped.",
+	}, []string{"outcome"})
+
+	fanOutNotificationsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fanout_notifications_total",
+		Help:      "Notifications written to followers by post fan-outs.",
+	})
+
+	fanOutsCapped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fanouts_capped_total",
+		Help:      "Post fan-outs stopped at the follower cap.",
+	})
+
+	snapshotsRefreshed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "snapshots_refreshed_total",
+		Help:      "Notifications whose trigger user profile snapshot was refreshed.",
+	})
+
+	broadcastNotificationsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "broadcast_notifications_total",
+		Help:      "Notifications written to audience members by broadcasts.",
+	})
+)
+
+// Latency histograms
+var (
+	processingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "processing_duration_seconds",
+		Help:      "End-to-end time to handle one NATS event.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"subject"})
+
+	dynamoDBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "dynamodb_duration_seconds",
+		Help:      "DynamoDB call latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "status"})
+
+	pusherDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "pusher_duration_seconds",
+		Help:      "Pusher trigger latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"status"})
+)
+
+// inFlightHandlers tracks events currently being handled
+var inFlightHandlers = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: metricsNamespace,
+	Name:      "in_flight_handlers",
+	Help:      "NATS events currently being processed.",
+})
+
+// RegisterNATSMetrics exposes the NATS connection state as a gauge
+// (1 = connected, 0 = disconnected, reconnecting or closed)
+func RegisterNATSMetrics(nc *nats.Conn) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "nats_connected",
+		Help:      "Whether the NATS connection is established.",
+	}, func() float64 {
+		if nc.IsConnected() {
+			return 1
+		}
+		return 0
+	})
+}
+
+// actionLabel formats an action for use as a label value
+func actionLabel(action int) string {
+	if _, ok := models.LookupAction(action); !ok {
+		return "unknown" // Keep label cardinality bounded
+	}
+	return strconv.Itoa(action)
+}
+
+// statusLabel returns "ok" or "error" for latency histograms
+func statusLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// observeDynamoDB records the latency of a DynamoDB call
+func observeDynamoDB(operation string, start time.Time, err error) {
+	dynamoDBDuration.WithLabelValues(operation, statusLabel(err)).Observe(time.Since(start).Seconds())
+}
+
+// MetricsHandler serves the Prometheus metrics endpoint
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// ErrInvalidToken is returned by a PushSender when the device token is no
+// longer valid and the device should be removed from the registry
+var ErrInvalidToken = errors.New("invalid device token")
+
+// MobileMessage is the platform-independent content of an OS push
+type MobileMessage struct {
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// PushSender delivers a message to a single device of one platform
+type PushSender interface {
+	Platform() string
+	Send(device models.Device, msg MobileMessage) error
+}
+
+// buildMobileMessage localizes a notification for a device
+func buildMobileMessage(renderer *MessageRenderer, notif models.Notification, locale string) MobileMessage {
+	text := renderer.Render(notif, locale)
+
+	return MobileMessage{
+		Title: text.Title,
+		Body:  text.Body,
+		Data: map[string]string{
+			"id":            notif.Id,
+			"action":        fmt.Sprint(notif.Action),
+			"user_id":       notif.UserId,
+			"resource_id":   notif.ResourceId,
+			"resource_type": notif.ResourceType,
+			"action_key":    notif.ActionKey,
+		},
+	}
+}
+
+// MobilePushDeliverer sends notifications to a user's registered devices
+type MobilePushDeliverer struct {
+	devices  DeviceStore
+	senders  map[string]PushSender
+	renderer *MessageRenderer
+}
+
+// NewMobilePushDeliverer creates a new mobile push deliverer for the given senders
+func NewMobilePushDeliverer(devices DeviceStore, renderer *MessageRenderer, senders ...PushSender) *MobilePushDeliverer {
+	bySender := make(map[string]PushSender, len(senders))
+	for _, sender := range senders {
+		bySender[sender.Platform()] = sender
+	}
+
+	return &MobilePushDeliverer{
+		devices:  devices,
+		senders:  bySender,
+		renderer: renderer,
+	}
+}
+
+// Name returns the deliverer name
+func (d *MobilePushDeliverer) Name() string {
+	return "mobilepush"
+}
+
+// Deliver pushes the notification to every device of the owner
+func (d *MobilePushDeliverer) Deliver(notif models.Notification) error {
+	if !models.DefaultPreferenceFor(notif.Action).Push {
+		return nil
+	}
+
+	devices, err := d.devices.ListDevices(notif.Owner)
+	if err != nil {
+		return err
+	}
+
+	var sent, failed int
+	for _, device := range devices {
+		sender, ok := d.senders[device.Platform]
+		if !ok {
+			continue // Platform not configured
+		}
+
+		err := sender.Send(device, buildMobileMessage(d.renderer, notif, device.Locale))
+		switch {
+		case errors.Is(err, ErrInvalidToken):
+			slog.Info("dropping invalid device token", "owner", device.Owner, "platform", device.Platform)
+			if err := d.devices.UnregisterDevice(device.Owner, device.Token); err != nil {
+				slog.Error("failed to drop device token", "owner", device.Owner, "error", err)
+			}
+		case err != nil:
+			slog.Error("mobile push failed", "owner", device.Owner, "platform", device.Platform, "action_key", notif.ActionKey, "error", err)
+			failed++
+		default:
+			sent++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d mobile pushes failed", failed, failed+sent)
+	}
+
+	if sent > 0 {
+		slog.Debug("mobile push sent", "owner", notif.Owner, "action_key", notif.ActionKey, "devices", sent)
+	}
+
+	return nil
+}
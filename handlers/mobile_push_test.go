@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// memoryDevices is a DeviceStore for tests
+type memoryDevices struct {
+	mu      sync.Mutex
+	devices map[string]models.Device // token -> device
+}
+
+func newMemoryDevices(devices ...models.Device) *memoryDevices {
+	store := &memoryDevices{devices: make(map[string]models.Device)}
+	for _, device := range devices {
+		store.devices[device.Token] = device
+	}
+	return store
+}
+
+func (s *memoryDevices) RegisterDevice(device models.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[device.Token] = device
+	return nil
+}
+
+func (s *memoryDevices) UnregisterDevice(owner, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if device, ok := s.devices[token]; ok && device.Owner == owner {
+		delete(s.devices, token)
+	}
+	return nil
+}
+
+func (s *memoryDevices) ListDevices(owner string) ([]models.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var devices []models.Device
+	for _, device := range s.devices {
+		if device.Owner == owner {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// writePKCS8Key writes a private key as a PKCS#8 PEM file and returns its path
+func writePKCS8Key(t *testing.T, key interface{}) (string, string) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path, string(data)
+}
+
+// testLikeNotification is a push-enabled notification for mobile tests
+func testLikeNotification() models.Notification {
+	return models.Notification{
+		Id:           "n1",
+		Owner:        "owner-1",
+		UserId:       "u1",
+		UserName:     "Jane",
+		Action:       models.ActionLikePost,
+		ResourceType: models.ResourceTypePost,
+		ResourceId:   "post-1",
+		ActionKey:    "u1#post-1#1#0",
+		CreatedAt:    time.Now(),
+	}
+}
+
+func newTestMessageRenderer(t *testing.T) *MessageRenderer {
+	t.Helper()
+	renderer, err := NewMessageRenderer("en")
+	if err != nil {
+		t.Fatalf("NewMessageRenderer: %v", err)
+	}
+	return renderer
+}
+
+// fcmStandIn serves the Google OAuth token endpoint and the FCM v1 send API
+type fcmStandIn struct {
+	server    *httptest.Server
+	publicKey *rsa.PublicKey
+	status    int    // Send response status
+	response  string // Send response body
+
+	tokenRequests atomic.Int32
+
+	mu       sync.Mutex
+	messages []map[string]interface{}
+	errors   []string
+}
+
+func newFCMStandIn(t *testing.T, publicKey *rsa.PublicKey) *fcmStandIn {
+	t.Helper()
+
+	s := &fcmStandIn{publicKey: publicKey, status: http.StatusOK, response: `{"name":"projects/exobook-test/messages/1"}`}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/v1/projects/exobook-test/messages:send", s.handleSend)
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// fail records a problem with a request; handlers can't call t.Fatal
+func (s *fcmStandIn) fail(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, fmt.Sprintf(format, args...))
+}
+
+func (s *fcmStandIn) handleToken(w http.ResponseWriter, r *http.Request) {
+	s.tokenRequests.Add(1)
+
+	if err := r.ParseForm(); err != nil {
+		s.fail("token request: %v", err)
+	}
+	if grant := r.PostForm.Get("grant_type"); grant != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		s.fail("grant_type = %q", grant)
+	}
+
+	claims, err := verifyRS256JWT(r.PostForm.Get("assertion"), s.publicKey)
+	if err != nil {
+		s.fail("assertion: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if claims["iss"] != "worker@exobook-test.iam.gserviceaccount.com" {
+		s.fail("iss = %v", claims["iss"])
+	}
+	if claims["scope"] != fcmScope {
+		s.fail("scope = %v", claims["scope"])
+	}
+	if claims["aud"] != s.server.URL+"/token" {
+		s.fail("aud = %v, want the token uri", claims["aud"])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"access_token":"ya29.stand-in","expires_in":3600,"token_type":"Bearer"}`)
+}
+
+func (s *fcmStandIn) handleSend(w http.ResponseWriter, r *http.Request) {
+	if got := r.Header.Get("Authorization"); got != "Bearer ya29.stand-in" {
+		s.fail("Authorization = %q", got)
+	}
+	if got := r.Header.Get("Content-Type"); got != "application/json" {
+		s.fail("Content-Type = %q", got)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.fail("send body: %v", err)
+	}
+	s.mu.Lock()
+	s.messages = append(s.messages, body)
+	s.mu.Unlock()
+
+	w.WriteHeader(s.status)
+	io.WriteString(w, s.response)
+}
+
+// check fails the test with every problem the stand-in recorded
+func (s *fcmStandIn) check(t *testing.T) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, problem := range s.errors {
+		t.Error(problem)
+	}
+}
+
+// newTestFCMSender writes a service account key file for the stand-in
+func newTestFCMSender(t *testing.T) (*FCMSender, *fcmStandIn) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	standIn := newFCMStandIn(t, &key.PublicKey)
+
+	_, pemKey := writePKCS8Key(t, key)
+	account, _ := json.Marshal(fcmServiceAccount{
+		ProjectID:   "exobook-test",
+		ClientEmail: "worker@exobook-test.iam.gserviceaccount.com",
+		PrivateKey:  pemKey,
+		TokenURI:    standIn.server.URL + "/token",
+	})
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	if err := os.WriteFile(path, account, 0o600); err != nil {
+		t.Fatalf("write credentials: %v", err)
+	}
+
+	sender, err := NewFCMSender(path, standIn.server.URL)
+	if err != nil {
+		t.Fatalf("NewFCMSender: %v", err)
+	}
+	return sender, standIn
+}
+
+func TestFCMSenderSend(t *testing.T) {
+	sender, standIn := newTestFCMSender(t)
+	device := models.Device{Owner: "owner-1", Token: "fcm-token-1", Platform: models.PlatformAndroid, Locale: "fr"}
+	deliverer := NewMobilePushDeliverer(newMemoryDevices(device), newTestMessageRenderer(t), sender)
+
+	// Two deliveries share one access token
+	for i := 0; i < 2; i++ {
+		if err := deliverer.Deliver(testLikeNotification()); err != nil {
+			t.Fatalf("Deliver: %v", err)
+		}
+	}
+	standIn.check(t)
+
+	if got := standIn.tokenRequests.Load(); got != 1 {
+		t.Errorf("token requests = %d, want 1", got)
+	}
+
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+	if len(standIn.messages) != 2 {
+		t.Fatalf("messages = %d, want 2", len(standIn.messages))
+	}
+
+	message, _ := standIn.messages[0]["message"].(map[string]interface{})
+	if message["token"] != "fcm-token-1" {
+		t.Errorf("token = %v", message["token"])
+	}
+	notification, _ := message["notification"].(map[string]interface{})
+	if notification["title"] != "Nouveau j'aime" || notification["body"] != "Jane a aimé votre publication" {
+		t.Errorf("notification = %v, want the French like message", notification)
+	}
+	data, _ := message["data"].(map[string]interface{})
+	if data["id"] != "n1" || data["action"] != "1" || data["resource_id"] != "post-1" || data["resource_type"] != "POST" {
+		t.Errorf("data = %v", data)
+	}
+}
+
+func TestFCMSenderDropsInvalidTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+	}{
+		{"not found", http.StatusNotFound, `{"error":{"status":"NOT_FOUND"}}`},
+		{"unregistered", http.StatusBadRequest, `{"error":{"status":"INVALID_ARGUMENT","details":[{"errorCode":"UNREGISTERED"}]}}`},
+		{"bad token", http.StatusBadRequest, `{"error":{"status":"INVALID_ARGUMENT","message":"The registration token is not a valid FCM registration token"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, standIn := newTestFCMSender(t)
+			standIn.status, standIn.response = tt.status, tt.response
+
+			devices := newMemoryDevices(models.Device{Owner: "owner-1", Token: "fcm-stale", Platform: models.PlatformAndroid})
+			deliverer := NewMobilePushDeliverer(devices, newTestMessageRenderer(t), sender)
+			if err := deliverer.Deliver(testLikeNotification()); err != nil {
+				t.Fatalf("Deliver: %v", err)
+			}
+			standIn.check(t)
+
+			if left, _ := devices.ListDevices("owner-1"); len(left) != 0 {
+				t.Errorf("devices left = %d, want the invalid token unregistered", len(left))
+			}
+		})
+	}
+}
+
+func TestFCMSenderKeepsTokenOnServerError(t *testing.T) {
+	sender, standIn := newTestFCMSender(t)
+	standIn.status, standIn.response = http.StatusServiceUnavailable, `{"error":{"status":"UNAVAILABLE"}}`
+
+	devices := newMemoryDevices(models.Device{Owner: "owner-1", Token: "fcm-token-1", Platform: models.PlatformAndroid})
+	deliverer := NewMobilePushDeliverer(devices, newTestMessageRenderer(t), sender)
+	if err := deliverer.Deliver(testLikeNotification()); err == nil {
+		t.Fatal("Deliver succeeded although FCM failed")
+	}
+
+	if left, _ := devices.ListDevices("owner-1"); len(left) != 1 {
+		t.Errorf("devices left = %d, want 1", len(left))
+	}
+}
+
+// apnsRequest is a request received by the APNs stand-in
+type apnsRequest struct {
+	Path   string
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+// newAPNsStandIn starts an APNs stand-in answering with status and reason
+func newAPNsStandIn(t *testing.T, status int, reason string) (*httptest.Server, *[]apnsRequest, *sync.Mutex) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []apnsRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		requests = append(requests, apnsRequest{Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+		mu.Unlock()
+
+		w.WriteHeader(status)
+		if reason != "" {
+			json.NewEncoder(w).Encode(map[string]string{"reason": reason})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests, &mu
+}
+
+// newTestAPNsSender writes a .p8 key and creates a sender for baseURL
+func newTestAPNsSender(t *testing.T, baseURL string) (*APNsSender, *ecdsa.PublicKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ec key: %v", err)
+	}
+	path, _ := writePKCS8Key(t, key)
+
+	sender, err := NewAPNsSender(path, "KEY123", "TEAM456", "com.exobook.app", baseURL)
+	if err != nil {
+		t.Fatalf("NewAPNsSender: %v", err)
+	}
+	return sender, &key.PublicKey
+}
+
+func TestAPNsSenderSend(t *testing.T) {
+	server, requests, mu := newAPNsStandIn(t, http.StatusOK, "")
+	sender, publicKey := newTestAPNsSender(t, server.URL)
+
+	device := models.Device{Owner: "owner-1", Token: "apns-token-1", Platform: models.PlatformIOS, Locale: "en"}
+	deliverer := NewMobilePushDeliverer(newMemoryDevices(device), newTestMessageRenderer(t), sender)
+	if err := deliverer.Deliver(testLikeNotification()); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+
+	if req.Path != "/3/device/apns-token-1" {
+		t.Errorf("path = %q", req.Path)
+	}
+	if got := req.Header.Get("apns-topic"); got != "com.exobook.app" {
+		t.Errorf("apns-topic = %q", got)
+	}
+	if got := req.Header.Get("apns-push-type"); got != "alert" {
+		t.Errorf("apns-push-type = %q", got)
+	}
+
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "bearer ")
+	if !ok {
+		t.Fatalf("Authorization = %q, want a bearer token", req.Header.Get("Authorization"))
+	}
+	var header map[string]interface{}
+	decodeJWTPart(t, strings.Split(token, ".")[0], &header)
+	if header["kid"] != "KEY123" {
+		t.Errorf("kid = %v", header["kid"])
+	}
+	claims := verifyES256JWT(t, token, publicKey)
+	if claims["iss"] != "TEAM456" {
+		t.Errorf("iss = %v", claims["iss"])
+	}
+	iat, _ := claims["iat"].(float64)
+	if age := time.Since(time.Unix(int64(iat), 0)); age < -time.Minute || age > time.Minute {
+		t.Errorf("iat is %v old, want now", age)
+	}
+
+	aps, _ := req.Body["aps"].(map[string]interface{})
+	alert, _ := aps["alert"].(map[string]interface{})
+	if alert["title"] != "New like" || alert["body"] != "Jane liked your post" {
+		t.Errorf("alert = %v", alert)
+	}
+	if req.Body["id"] != "n1" || req.Body["resource_id"] != "post-1" {
+		t.Errorf("custom data = %v", req.Body)
+	}
+}
+
+func TestAPNsSenderDropsInvalidTokens(t *testing.T) {
+	tests := []struct {
+		status int
+		reason string
+	}{
+		{http.StatusGone, "Unregistered"},
+		{http.StatusBadRequest, "BadDeviceToken"},
+		{http.StatusBadRequest, "DeviceTokenNotForTopic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			server, _, _ := newAPNsStandIn(t, tt.status, tt.reason)
+			sender, _ := newTestAPNsSender(t, server.URL)
+
+			devices := newMemoryDevices(models.Device{Owner: "owner-1", Token: "apns-stale", Platform: models.PlatformIOS})
+			deliverer := NewMobilePushDeliverer(devices, newTestMessageRenderer(t), sender)
+			if err := deliverer.Deliver(testLikeNotification()); err != nil {
+				t.Fatalf("Deliver: %v", err)
+			}
+
+			if left, _ := devices.ListDevices("owner-1"); len(left) != 0 {
+				t.Errorf("devices left = %d, want the invalid token unregistered", len(left))
+			}
+		})
+	}
+}
+
+func TestAPNsSenderKeepsTokenOnServerError(t *testing.T) {
+	server, _, _ := newAPNsStandIn(t, http.StatusTooManyRequests, "TooManyRequests")
+	sender, _ := newTestAPNsSender(t, server.URL)
+
+	devices := newMemoryDevices(models.Device{Owner: "owner-1", Token: "apns-token-1", Platform: models.PlatformIOS})
+	deliverer := NewMobilePushDeliverer(devices, newTestMessageRenderer(t), sender)
+	if err := deliverer.Deliver(testLikeNotification()); err == nil {
+		t.Fatal("Deliver succeeded although APNs failed")
+	}
+
+	if left, _ := devices.ListDevices("owner-1"); len(left) != 1 {
+		t.Errorf("devices left = %d, want 1", len(left))
+	}
+}
+
+// verifyRS256JWT checks a compact RS256 JWT's signature and returns its claims
+func verifyRS256JWT(token string, publicKey *rsa.PublicKey) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token has %d parts, want 3", len(parts))
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, err
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
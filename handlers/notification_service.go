@@ -2,36 +2,49 @@ package handlers
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
+	"time"
 
 	"github.com/aslotsu/notification-worker/models"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/google/uuid"
 	"github.com/pusher/pusher-http-go/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrDuplicateNotification is returned by CreateNotification when a
+// notification with the same action key already exists for the owner
+var ErrDuplicateNotification = errors.New("notification already exists")
+
+// ErrNotificationNotFound is returned when an owner has no notification
+// with the given id
+var ErrNotificationNotFound = errors.New("notification not found")
+
 type NotificationService struct {
-	client       *dynamodb.Client
-	tableName    string
+	store        NotificationStore
 	pusherClient *pusher.Client
+	deliverers   []Deliverer
+	renderer     *MessageRenderer
+
+	snapshotWindow time.Duration // How far back profile refreshes go, 0 for no limit
+	profiles       *ProfileCache // Recent profile updates overlaid on reads
 }
 
-// NewNotificationService creates a new notification service
+// NewNotificationService creates a notification service backed by DynamoDB
 func NewNotificationService(region, tableName, pusherAppID, pusherKey, pusherSecret, pusherCluster string) (*NotificationService, error) {
-	// Load AWS SDK configuration
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	store, err := NewDynamoDBNotificationStore(region, tableName)
 	if err != nil {
-		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+		return nil, err
 	}
 
-	// Create DynamoDB client
-	client := dynamodb.NewFromConfig(cfg)
+	return NewNotificationServiceWithStore(store, pusherAppID, pusherKey, pusherSecret, pusherCluster), nil
+}
 
+// NewNotificationServiceWithStore creates a notification service backed by
+// any store, e.g. MemoryNotificationStore in dev mode
+func NewNotificationServiceWithStore(store NotificationStore, pusherAppID, pusherKey, pusherSecret, pusherCluster string) *NotificationService {
 	// Initialize Pusher client for real-time notifications
 	var pusherClient *pusher.Client
 	if pusherAppID != "" && pusherKey != "" && pusherSecret != "" {
@@ -42,155 +55,167 @@ func NewNotificationService(region, tableName, pusherAppID, pusherKey, pusherSec
 			Cluster: pusherCluster,
 			Secure:  true,
 		}
-		log.Println("✅ Pusher client initialized for real-time notifications")
+		slog.Info("pusher client initialized for real-time notifications")
 	} else {
-		log.Println("⚠️ Pusher credentials not provided - real-time notifications disabled")
+		slog.Warn("pusher credentials not provided, real-time notifications disabled")
 	}
 
 	return &NotificationService{
-		client:       client,
-		tableName:    tableName,
+		store:        store,
 		pusherClient: pusherClient,
-	}, nil
+	}
 }
 
-// CreateNotification creates a notification in DynamoDB
-func (s *NotificationService) CreateNotification(notif models.Notification) error {
-	// Generate unique ID
-	notif.Id = uuid.New().String()
+// SetMessageRenderer enables rendering of notification titles and bodies
+func (s *NotificationService) SetMessageRenderer(renderer *MessageRenderer) {
+	s.renderer = renderer
+}
 
-	// Generate action key for deduplication
-	notif.ActionKey = notif.GenerateActionKey()
+// SetSnapshotRefreshWindow limits profile snapshot refreshes to
+// notifications created within window. Zero refreshes every unexpired one.
+func (s *NotificationService) SetSnapshotRefreshWindow(window time.Duration) {
+	s.snapshotWindow = window
+}
 
-	// Set read status to false by default
-	notif.ReadStatus = false
+// SetProfileCache enables read-time hydration from recent profile updates
+func (s *NotificationService) SetProfileCache(cache *ProfileCache) {
+	s.profiles = cache
+}
 
-	// Marshal to DynamoDB format
-	item, err := attributevalue.MarshalMap(notif)
-	if err != nil {
-		return fmt.Errorf("failed to marshal notification: %v", err)
+// CreateNotification stores a notification and delivers it
+func (s *NotificationService) CreateNotification(ctx context.Context, notif models.Notification) error {
+	// Generate unique ID unless the caller chose one
+	if notif.Id == "" {
+		notif.Id = uuid.New().String()
 	}
 
-	// Check if notification already exists (deduplication)
-	// We use action_key to prevent duplicate notifications
-	// For example: user likes same post multiple times, only create one notification
-	existing, err := s.client.Query(context.TODO(), &dynamodb.QueryInput{
-		TableName:              aws.String(s.tableName),
-		IndexName:              aws.String("OwnerIndex"),
-		KeyConditionExpression: aws.String("#owner = :owner"),
-		FilterExpression:       aws.String("action_key = :action_key"),
-		ExpressionAttributeNames: map[string]string{
-			"#owner": "owner", // 'owner' is a reserved keyword
-		},
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":owner":      &types.AttributeValueMemberS{Value: notif.Owner},
-			":action_key": &types.AttributeValueMemberS{Value: notif.ActionKey},
-		},
-		Limit: aws.Int32(1),
-	})
+	s.prepare(&notif)
 
-	if err != nil {
-		log.Printf("Warning: Failed to check for duplicate notification: %v", err)
-		// Continue anyway - better to have duplicate than miss notification
-	} else if existing.Count > 0 {
-		log.Printf("Notification already exists for action_key: %s, skipping", notif.ActionKey)
-		return nil // Not an error, just skip
+	// Deduplicated actions are stored under an id derived from the
+	// action_key, so the write itself rejects a duplicate. For example:
+	// user likes same post multiple times, only create one notification
+	var err error
+	if models.DedupPolicyFor(notif.Action) == models.DedupNever {
+		err = s.store.PutNotification(ctx, notif)
+	} else {
+		notif.Id = derivedNotificationID(notif.Owner, notif.ActionKey)
+		err = s.store.PutNewNotification(ctx, notif)
+	}
+	if errors.Is(err, ErrDuplicateNotification) {
+		slog.Debug("notification already exists, skipping", "owner", notif.Owner, "action_key", notif.ActionKey)
+		return ErrDuplicateNotification // Not a failure, callers just skip
 	}
-
-	// Store in DynamoDB
-	_, err = s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: aws.String(s.tableName),
-		Item:      item,
-	})
-
 	if err != nil {
-		return fmt.Errorf("failed to create notification: %v", err)
+		return err
 	}
 
-	log.Printf("✅ Created notification: owner=%s, action=%d, resource=%s",
-		notif.Owner, notif.Action, notif.ResourceId)
+	slog.Debug("created notification",
+		"owner", notif.Owner,
+		"action", notif.Action,
+		"resource_id", notif.ResourceId,
+		"action_key", notif.ActionKey,
+	)
 
 	// Trigger Pusher event for real-time notification delivery
 	if s.pusherClient != nil {
-		go s.triggerPusherNotification(notif)
+		go s.triggerPusherNotification(ctx, notif)
 	}
 
+	// Fan out to any extra delivery channels (web push, ...)
+	s.runDeliverers(notif)
+
 	return nil
 }
 
+// derivedNotificationID derives a notification id from its owner and
+// action key, so writing the same notification twice replaces it
+func derivedNotificationID(owner, actionKey string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(owner+"#"+actionKey)).String()
+}
+
+// prepare fills in everything derived from an event: the dedup key, read
+// status, expiry and rendered text
+func (s *NotificationService) prepare(notif *models.Notification) {
+	// Generate action key for deduplication
+	notif.ActionKey = notif.GenerateActionKey()
+
+	// Set read status to false by default
+	notif.ReadStatus = false
+
+	// Expire per the action's retention policy; DynamoDB TTL deletes it later
+	notif.SetExpiry()
+
+	// An event published before a profile change may carry the old profile
+	if s.profiles != nil {
+		if update, ok := s.profiles.Get(notif.UserId); ok {
+			update.Apply(notif)
+		}
+	}
+
+	// Render title and body so every client shows the same text
+	if s.renderer != nil {
+		notif.Locale = s.renderer.resolveLocale(notif.Locale)
+		msg := s.renderer.Render(*notif, notif.Locale)
+		notif.Title = msg.Title
+		notif.Body = msg.Body
+	}
+}
+
 // triggerPusherNotification sends a real-time notification via Pusher
-func (s *NotificationService) triggerPusherNotification(notif models.Notification) {
-	channelName := fmt.Sprintf("user-%s-notifications", notif.Owner)
+func (s *NotificationService) triggerPusherNotification(ctx context.Context, notif models.Notification) {
+	channelName := pusherChannelName(notif.Owner)
 
 	// Create event data matching frontend expectations
-	eventData := map[string]interface{}{
-		"id":           notif.Id,
-		"action":       notif.Action,
-		"username":     notif.UserName,
-		"user_id":      notif.UserId,
-		"user_pic":     notif.UserPic,
-		"resource_id":  notif.ResourceId,
-		"resource_type": notif.ResourceType,
-		"excerpt":      notif.Excerpt,
-		"read_status":  notif.ReadStatus,
-		"created_at":   notif.CreatedAt,
-		"action_key":   notif.ActionKey,
-	}
+	eventData := notificationPayload(notif)
+
+	_, span := tracer.Start(ctx, "pusher trigger",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("pusher.channel", channelName)),
+	)
 
+	start := time.Now()
 	err := s.pusherClient.Trigger(channelName, "new-notification", eventData)
+	pusherDuration.WithLabelValues(statusLabel(err)).Observe(time.Since(start).Seconds())
+	endSpan(span, err)
 	if err != nil {
-		log.Printf("❌ Failed to trigger Pusher notification for user %s: %v", notif.Owner, err)
+		slog.Error("failed to trigger pusher notification", "owner", notif.Owner, "action_key", notif.ActionKey, "error", err, "duration", time.Since(start))
 	} else {
-		log.Printf("📤 Pusher notification sent to channel: %s", channelName)
+		slog.Debug("pusher notification sent", "owner", notif.Owner, "channel", channelName, "duration", time.Since(start))
 	}
 }
 
 // GetNotificationsByOwner retrieves notifications for a user
 func (s *NotificationService) GetNotificationsByOwner(owner string, limit int32) ([]models.Notification, error) {
-	resp, err := s.client.Query(context.TODO(), &dynamodb.QueryInput{
-		TableName:              aws.String(s.tableName),
-		IndexName:              aws.String("OwnerIndex"),
-		KeyConditionExpression: aws.String("owner = :owner"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":owner": &types.AttributeValueMemberS{Value: owner},
-		},
-		ScanIndexForward: aws.Bool(false), // Latest first
-		Limit:            aws.Int32(limit),
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to query notifications: %v", err)
-	}
-
-	var notifications []models.Notification
-	err = attributevalue.UnmarshalListOfMaps(resp.Items, &notifications)
+	notifications, err := s.store.ListNotifications(context.TODO(), owner, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal notifications: %v", err)
+		return nil, err
 	}
 
+	s.hydrate(notifications)
 	return notifications, nil
 }
 
-// MarkAsRead marks a notification as read
-func (s *NotificationService) MarkAsRead(owner, actionKey string) error {
-	_, err := s.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
-		TableName: aws.String(s.tableName),
-		Key: map[string]types.AttributeValue{
-			"owner":      &types.AttributeValueMemberS{Value: owner},
-			"action_key": &types.AttributeValueMemberS{Value: actionKey},
-		},
-		UpdateExpression: aws.String("SET #read = :true"),
-		ExpressionAttributeNames: map[string]string{
-			"#read": "read",
-		},
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":true": &types.AttributeValueMemberBOOL{Value: true},
-		},
-	})
+// MarkAsRead marks one of an owner's notifications as read
+func (s *NotificationService) MarkAsRead(owner, id string) error {
+	return s.store.MarkAsRead(context.TODO(), owner, id)
+}
 
+// MarkAllAsRead marks every unread notification of an owner as read and
+// returns how many were updated
+func (s *NotificationService) MarkAllAsRead(owner string) (int, error) {
+	return s.store.MarkAllAsRead(context.TODO(), owner)
+}
+
+// DescribeTable checks the notification store and returns its status
+func (s *NotificationService) DescribeTable(ctx context.Context) (string, error) {
+	return s.store.DescribeTable(ctx)
+}
+
+// endSpan records an error, if any, and ends the span
+func endSpan(span trace.Span, err error) {
 	if err != nil {
-		return fmt.Errorf("failed to mark notification as read: %v", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
-
-	return nil
+	span.End()
 }
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+func TestCreateNotificationDedupPolicies(t *testing.T) {
+	now := time.Now()
+	like := models.Notification{Owner: "owner-1", UserId: "user-2", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-1", CreatedAt: now}
+	reply := models.Notification{Owner: "owner-1", UserId: "user-2", Action: models.ActionReplyPost, ResourceType: models.ResourceTypePost, ResourceId: "post-1", SourceId: "comment-1", CreatedAt: now}
+	otherReply := reply
+	otherReply.SourceId = "comment-2"
+	system := models.Notification{Owner: "owner-1", UserId: "exobook", Action: models.ActionSystem, ResourceType: models.ResourceTypeSystem, ResourceId: "notice-1", Excerpt: "Hi", CreatedAt: now}
+
+	tests := []struct {
+		name   string
+		events []models.Notification
+		want   int // Notifications stored
+		dups   int // ErrDuplicateNotification returned
+	}{
+		{"like twice (forever)", []models.Notification{like, like}, 1, 1},
+		{"same reply twice (by source)", []models.Notification{reply, reply}, 1, 1},
+		{"two replies (by source)", []models.Notification{reply, otherReply}, 2, 0},
+		{"system twice (never)", []models.Notification{system, system}, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, store, _ := newTestMemoryService(t)
+			service.pusherClient = nil
+
+			dups := 0
+			for _, notif := range tt.events {
+				err := service.CreateNotification(context.Background(), notif)
+				if errors.Is(err, ErrDuplicateNotification) {
+					dups++
+				} else if err != nil {
+					t.Fatalf("CreateNotification: %v", err)
+				}
+			}
+
+			if ids := storedIDs(t, store, "owner-1"); len(ids) != tt.want {
+				t.Errorf("stored %d notifications, want %d", len(ids), tt.want)
+			}
+			if dups != tt.dups {
+				t.Errorf("%d duplicates, want %d", dups, tt.dups)
+			}
+		})
+	}
+}
+
+func TestCreateNotificationReplacesExpiredDuplicate(t *testing.T) {
+	service, store, _ := newTestMemoryService(t)
+	service.pusherClient = nil
+	ctx := context.Background()
+
+	like := models.Notification{Owner: "owner-1", UserId: "user-2", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-1"}
+
+	old := like
+	old.CreatedAt = time.Now().Add(-31 * 24 * time.Hour)
+	if err := service.CreateNotification(ctx, old); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+
+	like.CreatedAt = time.Now()
+	if err := service.CreateNotification(ctx, like); err != nil {
+		t.Fatalf("like after the old one expired: %v", err)
+	}
+
+	notifications, _ := store.ListNotifications(ctx, "owner-1", 0)
+	if len(notifications) != 1 || notifications[0].Expired(time.Now()) {
+		t.Errorf("notifications = %+v, want the new like only", notifications)
+	}
+}
+
+func TestCreateNotificationConcurrentDuplicates(t *testing.T) {
+	service, store, _ := newTestMemoryService(t)
+	service.pusherClient = nil
+
+	like := models.Notification{Owner: "owner-1", UserId: "user-2", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-1", CreatedAt: time.Now()}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := service.CreateNotification(context.Background(), like); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Errorf("%d concurrent likes created a notification, want 1", created)
+	}
+	if ids := storedIDs(t, store, "owner-1"); len(ids) != 1 {
+		t.Errorf("stored %v, want one notification", ids)
+	}
+}
+
+func TestDynamoDBPutNewNotificationConditionFailed(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`))
+	}))
+	defer server.Close()
+
+	store := testDynamoDBStore(server.URL)
+
+	err := store.PutNewNotification(context.Background(), models.Notification{Id: "n-1", Owner: "owner-1"})
+	if !errors.Is(err, ErrDuplicateNotification) {
+		t.Errorf("PutNewNotification = %v, want ErrDuplicateNotification", err)
+	}
+	if !strings.Contains(body, "attribute_not_exists(id)") {
+		t.Errorf("PutItem request %s has no attribute_not_exists(id) condition", body)
+	}
+}
@@ -0,0 +1,682 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NotificationStore persists notifications. NotificationService handles
+// rendering, dedup and delivery around it.
+type NotificationStore interface {
+	PutNotification(ctx context.Context, notif models.Notification) error
+	// PutNotifications stores notifications in batches. Existing ids are
+	// replaced, so writing the same notifications twice is harmless.
+	PutNotifications(ctx context.Context, notifications []models.Notification) error
+	// PutNewNotification stores a notification unless one with its id
+	// exists and hasn't expired, in which case it returns
+	// ErrDuplicateNotification. The check and write are one operation.
+	PutNewNotification(ctx context.Context, notif models.Notification) error
+	// ListNotifications returns an owner's notifications, latest first
+	ListNotifications(ctx context.Context, owner string, limit int32) ([]models.Notification, error)
+	MarkAsRead(ctx context.Context, owner, id string) error
+	// Dismiss deletes one of an owner's notifications and returns it.
+	// Returns ErrNotificationNotFound when the owner has no such notification.
+	Dismiss(ctx context.Context, owner, id string) (models.Notification, error)
+	// MarkAllAsRead returns how many notifications were updated
+	MarkAllAsRead(ctx context.Context, owner string) (int, error)
+	// ListByResource pages through the notifications whose resource, or
+	// source (reply/comment), is the given id, across owners. Cursors work
+	// like ListByUser's.
+	ListByResource(ctx context.Context, relation ResourceRelation, resourceID, cursor string, limit int32) ([]models.Notification, string, error)
+	// ListByUser pages through every notification a user owns or
+	// triggered, expired ones included. An empty cursor starts at the
+	// beginning; the returned cursor is empty after the last page.
+	ListByUser(ctx context.Context, relation UserRelation, userID, cursor string, limit int32) ([]models.Notification, string, error)
+	// ListTriggeredSince pages through the notifications a user triggered
+	// created at or after since, all of them for a zero since. Cursors work
+	// like ListByUser's.
+	ListTriggeredSince(ctx context.Context, userID string, since time.Time, cursor string, limit int32) ([]models.Notification, string, error)
+	// UpdateSnapshots writes the trigger user fields (username, userpic,
+	// userbio) and rendered text of notifications. Missing ones are skipped.
+	UpdateSnapshots(ctx context.Context, notifications []models.Notification) error
+	// DeleteNotifications deletes notifications by id
+	DeleteNotifications(ctx context.Context, ids []string) error
+	// DescribeTable returns the store status, used by readiness checks
+	DescribeTable(ctx context.Context) (string, error)
+}
+
+// userPageSize is how many notifications jobs read per ListByUser page
+const userPageSize = 100
+
+// UserRelation says how a user relates to a notification
+type UserRelation int
+
+const (
+	// RelationOwner matches notifications the user received
+	RelationOwner UserRelation = iota
+	// RelationTrigger matches notifications about the user's actions (userid)
+	RelationTrigger
+)
+
+// ResourceRelation says how a resource relates to a notification
+type ResourceRelation int
+
+const (
+	// RelationResource matches notifications about the resource (resource_id)
+	RelationResource ResourceRelation = iota
+	// RelationSource matches replies and mentions caused by it (source_id)
+	RelationSource
+)
+
+// DynamoDBNotificationStore keeps notifications in DynamoDB.
+// Table key: id. Indexes are listed in tableMigrations.
+type DynamoDBNotificationStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewDynamoDBNotificationStore creates a DynamoDB-backed notification store
+func NewDynamoDBNotificationStore(region, tableName string) (*DynamoDBNotificationStore, error) {
+	// Load AWS SDK configuration
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &DynamoDBNotificationStore{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// PutNotification stores a notification
+func (s *DynamoDBNotificationStore) PutNotification(ctx context.Context, notif models.Notification) error {
+	// Marshal to DynamoDB format
+	item, err := attributevalue.MarshalMap(notif)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+
+	ctx, span := s.startSpan(ctx, "PutItem", "PutItem")
+	start := time.Now()
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	observeDynamoDB("PutItem", start, err)
+	endSpan(span, err)
+
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %v", err)
+	}
+	return nil
+}
+
+// PutNotifications stores notifications in BatchWriteItem batches
+func (s *DynamoDBNotificationStore) PutNotifications(ctx context.Context, notifications []models.Notification) error {
+	requests := make([]types.WriteRequest, len(notifications))
+	for i, notif := range notifications {
+		item, err := attributevalue.MarshalMap(notif)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification: %v", err)
+		}
+		requests[i] = types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+	}
+
+	if err := s.batchWrite(ctx, requests); err != nil {
+		return fmt.Errorf("failed to create notifications: %v", err)
+	}
+	return nil
+}
+
+// PutNewNotification stores a notification with a PutItem conditioned on
+// no live item having its id. An expired one TTL hasn't deleted yet is
+// replaced, so a like after the old notification expired notifies again.
+func (s *DynamoDBNotificationStore) PutNewNotification(ctx context.Context, notif models.Notification) error {
+	item, err := attributevalue.MarshalMap(notif)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+
+	ctx, span := s.startSpan(ctx, "PutItem", "PutItem")
+	start := time.Now()
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(s.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id) OR expires_at <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": nowValue(),
+		},
+	})
+	observeDynamoDB("PutItem", start, err)
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		endSpan(span, nil)
+		return fmt.Errorf("%w: %s", ErrDuplicateNotification, notif.Id)
+	}
+	endSpan(span, err)
+
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %v", err)
+	}
+	return nil
+}
+
+// ListNotifications queries the owner's latest notifications, skipping
+// expired ones TTL hasn't deleted yet. The filter is applied after each
+// page is read, so pages are fetched until limit items are found.
+func (s *DynamoDBNotificationStore) ListNotifications(ctx context.Context, owner string, limit int32) ([]models.Notification, error) {
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String("OwnerIndex"),
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		FilterExpression:       aws.String(notExpiredFilter),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner", // 'owner' is a reserved keyword
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+			":now":   nowValue(),
+		},
+		ScanIndexForward: aws.Bool(false), // Latest first
+		Limit:            aws.Int32(limit),
+	})
+
+	var notifications []models.Notification
+	for paginator.HasMorePages() && len(notifications) < int(limit) {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		observeDynamoDB("Query", start, err)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to query notifications: %v", err)
+		}
+
+		var items []models.Notification
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal notifications: %v", err)
+		}
+		notifications = append(notifications, items...)
+	}
+
+	if len(notifications) > int(limit) {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+// MarkAsRead marks one of an owner's notifications as read
+func (s *DynamoDBNotificationStore) MarkAsRead(ctx context.Context, owner, id string) error {
+	start := time.Now()
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:    aws.String("SET read_status = :true"),
+		ConditionExpression: aws.String("#owner = :owner"), // Don't create items or touch other owners'
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner", // 'owner' is a reserved keyword
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true":  &types.AttributeValueMemberBOOL{Value: true},
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	observeDynamoDB("UpdateItem", start, err)
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return fmt.Errorf("notification %s not found for %s", id, owner)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mark notification as read: %v", err)
+	}
+
+	return nil
+}
+
+// Dismiss deletes one of an owner's notifications and returns it
+func (s *DynamoDBNotificationStore) Dismiss(ctx context.Context, owner, id string) (models.Notification, error) {
+	ctx, span := s.startSpan(ctx, "DeleteItem", "DeleteItem")
+
+	start := time.Now()
+	out, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConditionExpression: aws.String("#owner = :owner"), // Don't delete other owners'
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner", // 'owner' is a reserved keyword
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	observeDynamoDB("DeleteItem", start, err)
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		endSpan(span, nil)
+		return models.Notification{}, fmt.Errorf("%w: %s for %s", ErrNotificationNotFound, id, owner)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return models.Notification{}, fmt.Errorf("failed to dismiss notification: %v", err)
+	}
+
+	var notif models.Notification
+	if err := attributevalue.UnmarshalMap(out.Attributes, &notif); err != nil {
+		return models.Notification{}, fmt.Errorf("failed to unmarshal dismissed notification: %v", err)
+	}
+	return notif, nil
+}
+
+// MarkAllAsRead marks every unread notification of an owner as read
+func (s *DynamoDBNotificationStore) MarkAllAsRead(ctx context.Context, owner string) (int, error) {
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String("OwnerIndex"),
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		FilterExpression:       aws.String("read_status = :false"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner", // 'owner' is a reserved keyword
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+		},
+		ProjectionExpression: aws.String("id"),
+	})
+
+	updated := 0
+	for paginator.HasMorePages() {
+		start := time.Now()
+		page, err := paginator.NextPage(ctx)
+		observeDynamoDB("Query", start, err)
+		if err != nil {
+			return updated, fmt.Errorf("failed to query unread notifications: %v", err)
+		}
+
+		for _, item := range page.Items {
+			id, ok := item["id"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			if err := s.MarkAsRead(ctx, owner, id.Value); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+
+	return updated, nil
+}
+
+// ListByResource queries ResourceIndex or SourceIndex one page at a time.
+// The cursor is the page's LastEvaluatedKey, encoded.
+func (s *DynamoDBNotificationStore) ListByResource(ctx context.Context, relation ResourceRelation, resourceID, cursor string, limit int32) ([]models.Notification, string, error) {
+	index, attribute := "ResourceIndex", "resource_id"
+	if relation == RelationSource {
+		index, attribute = "SourceIndex", "source_id"
+	}
+
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, span := s.startSpan(ctx, "Query", "resource query")
+
+	start := time.Now()
+	resp, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(index),
+		KeyConditionExpression: aws.String(attribute + " = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: resourceID},
+		},
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(limit),
+	})
+	observeDynamoDB("Query", start, err)
+	if err != nil {
+		err = fmt.Errorf("failed to query %s: %v", index, err)
+		endSpan(span, err)
+		return nil, "", err
+	}
+
+	var notifications []models.Notification
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, &notifications); err != nil {
+		err = fmt.Errorf("failed to unmarshal notifications: %v", err)
+		endSpan(span, err)
+		return nil, "", err
+	}
+	endSpan(span, nil)
+
+	next, err := encodeCursor(resp.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return notifications, next, nil
+}
+
+// ListByUser queries OwnerIndex or TriggerUserIndex one page at a time.
+// The cursor is the page's LastEvaluatedKey, encoded.
+func (s *DynamoDBNotificationStore) ListByUser(ctx context.Context, relation UserRelation, userID, cursor string, limit int32) ([]models.Notification, string, error) {
+	index, attribute := "OwnerIndex", "owner"
+	if relation == RelationTrigger {
+		index, attribute = "TriggerUserIndex", "userid"
+	}
+
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := time.Now()
+	resp, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String(index),
+		KeyConditionExpression: aws.String("#user = :user"),
+		ExpressionAttributeNames: map[string]string{
+			"#user": attribute, // 'owner' is a reserved keyword
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user": &types.AttributeValueMemberS{Value: userID},
+		},
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(limit),
+	})
+	observeDynamoDB("Query", start, err)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query %s: %v", index, err)
+	}
+
+	var notifications []models.Notification
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, &notifications); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal notifications: %v", err)
+	}
+
+	next, err := encodeCursor(resp.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return notifications, next, nil
+}
+
+// ListTriggeredSince queries TriggerUserIndex from since onwards, so only
+// recent notifications are read however long the user's history is
+func (s *DynamoDBNotificationStore) ListTriggeredSince(ctx context.Context, userID string, since time.Time, cursor string, limit int32) ([]models.Notification, string, error) {
+	if since.IsZero() {
+		return s.ListByUser(ctx, RelationTrigger, userID, cursor, limit)
+	}
+
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	// Marshalled like the notifications' own created_at, which the index sorts on
+	sinceValue, err := attributevalue.Marshal(since)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal since: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		IndexName:              aws.String("TriggerUserIndex"),
+		KeyConditionExpression: aws.String("userid = :user AND created_at >= :since"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user":  &types.AttributeValueMemberS{Value: userID},
+			":since": sinceValue,
+		},
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(limit),
+	})
+	observeDynamoDB("Query", start, err)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query TriggerUserIndex: %v", err)
+	}
+
+	var notifications []models.Notification
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, &notifications); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal notifications: %v", err)
+	}
+
+	next, err := encodeCursor(resp.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return notifications, next, nil
+}
+
+// UpdateSnapshots writes the snapshot fields with UpdateItem, running up
+// to 25 updates at once. Only those fields are written, so concurrent read
+// status changes are kept.
+func (s *DynamoDBNotificationStore) UpdateSnapshots(ctx context.Context, notifications []models.Notification) error {
+	ctx, span := s.startSpan(ctx, "UpdateItem", "snapshot update")
+
+	for len(notifications) > 0 {
+		n := min(len(notifications), batchWriteSize)
+		batch := notifications[:n]
+		notifications = notifications[n:]
+
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i, notif := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = s.updateSnapshot(ctx, notif)
+			}()
+		}
+		wg.Wait()
+
+		if err := errors.Join(errs...); err != nil {
+			endSpan(span, err)
+			return err
+		}
+	}
+
+	endSpan(span, nil)
+	return nil
+}
+
+// updateSnapshot writes one notification's snapshot fields
+func (s *DynamoDBNotificationStore) updateSnapshot(ctx context.Context, notif models.Notification) error {
+	start := time.Now()
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: notif.Id},
+		},
+		UpdateExpression:    aws.String("SET #username = :username, #userpic = :userpic, #userbio = :userbio, #title = :title, #body = :body"),
+		ConditionExpression: aws.String("userid = :userid"), // Don't recreate deleted items
+		ExpressionAttributeNames: map[string]string{
+			"#username": "username",
+			"#userpic":  "userpic",
+			"#userbio":  "userbio",
+			"#title":    "title",
+			"#body":     "body",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userid":   &types.AttributeValueMemberS{Value: notif.UserId},
+			":username": &types.AttributeValueMemberS{Value: notif.UserName},
+			":userpic":  &types.AttributeValueMemberS{Value: notif.UserPic},
+			":userbio":  &types.AttributeValueMemberS{Value: notif.UserBio},
+			":title":    &types.AttributeValueMemberS{Value: notif.Title},
+			":body":     &types.AttributeValueMemberS{Value: notif.Body},
+		},
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		err = nil
+	}
+	observeDynamoDB("UpdateItem", start, err)
+
+	if err != nil {
+		return fmt.Errorf("failed to update notification %s: %v", notif.Id, err)
+	}
+	return nil
+}
+
+// DeleteNotifications deletes notifications in BatchWriteItem batches
+func (s *DynamoDBNotificationStore) DeleteNotifications(ctx context.Context, ids []string) error {
+	requests := make([]types.WriteRequest, len(ids))
+	for i, id := range ids {
+		requests[i] = types.WriteRequest{
+			DeleteRequest: &types.DeleteRequest{
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: id},
+				},
+			},
+		}
+	}
+
+	if err := s.batchWrite(ctx, requests); err != nil {
+		return fmt.Errorf("failed to delete notifications: %v", err)
+	}
+	return nil
+}
+
+// batchWrite sends write requests in batches of 25, the BatchWriteItem
+// limit, retrying unprocessed items with backoff
+func (s *DynamoDBNotificationStore) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	ctx, span := s.startSpan(ctx, "BatchWriteItem", "BatchWriteItem")
+
+	for len(requests) > 0 {
+		n := min(len(requests), batchWriteSize)
+		pending := map[string][]types.WriteRequest{s.tableName: requests[:n]}
+		requests = requests[n:]
+
+		for attempt := 0; len(pending[s.tableName]) > 0; attempt++ {
+			if attempt == batchWriteAttempts {
+				err := fmt.Errorf("%d items still unprocessed after %d attempts", len(pending[s.tableName]), attempt)
+				endSpan(span, err)
+				return err
+			}
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					endSpan(span, ctx.Err())
+					return ctx.Err()
+				case <-time.After(batchWriteBackoff << (attempt - 1)):
+				}
+			}
+
+			start := time.Now()
+			resp, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			observeDynamoDB("BatchWriteItem", start, err)
+			if err != nil {
+				endSpan(span, err)
+				return err
+			}
+			pending = resp.UnprocessedItems
+		}
+	}
+
+	endSpan(span, nil)
+	return nil
+}
+
+// DescribeTable checks the notifications table and returns its status
+func (s *DynamoDBNotificationStore) DescribeTable(ctx context.Context) (string, error) {
+	start := time.Now()
+	resp, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.tableName),
+	})
+	observeDynamoDB("DescribeTable", start, err)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(resp.Table.TableStatus), nil
+}
+
+// encodeCursor encodes a LastEvaluatedKey as an opaque string. Every key
+// attribute of the table and its indexes is a string.
+func encodeCursor(key map[string]types.AttributeValue) (string, error) {
+	if len(key) == 0 {
+		return "", nil
+	}
+
+	values := make(map[string]string, len(key))
+	if err := attributevalue.UnmarshalMap(key, &values); err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %v", err)
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decodes a cursor from encodeCursor
+func decodeCursor(cursor string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+	key, err := attributevalue.MarshalMap(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %v", err)
+	}
+	return key, nil
+}
+
+// BatchWriteItem limits and retries
+const (
+	batchWriteSize     = 25
+	batchWriteAttempts = 5
+	batchWriteBackoff  = 100 * time.Millisecond
+)
+
+// notExpiredFilter matches notifications without an expiry or not yet
+// expired. It needs ":now" set to nowValue().
+const notExpiredFilter = "(attribute_not_exists(expires_at) OR expires_at > :now)"
+
+// nowValue returns the current Unix time for notExpiredFilter
+func nowValue() types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)}
+}
+
+// startSpan starts a client span around a DynamoDB call
+func (s *DynamoDBNotificationStore) startSpan(ctx context.Context, operation, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "DynamoDB "+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "dynamodb"),
+			attribute.String("db.operation", operation),
+			attribute.StringSlice("aws.dynamodb.table_names", []string{s.tableName}),
+		),
+	)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// PreferenceStore reads and updates per-user notification preferences
+type PreferenceStore interface {
+	ListDigestPreferences(frequency models.DigestFrequency) ([]models.NotificationPreference, error)
+	MarkDigestSent(owner string, sentAt time.Time) error
+}
+
+// PreferenceService stores notification preferences in DynamoDB
+type PreferenceService struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewPreferenceService creates a new preference service
+func NewPreferenceService(region, tableName string) (*PreferenceService, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &PreferenceService{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// ListDigestPreferences returns every user subscribed to the given digest frequency
+func (s *PreferenceService) ListDigestPreferences(frequency models.DigestFrequency) ([]models.NotificationPreference, error) {
+	var prefs []models.NotificationPreference
+
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName:        aws.String(s.tableName),
+		FilterExpression: aws.String("digest_frequency = :frequency"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":frequency": &types.AttributeValueMemberS{Value: string(frequency)},
+		},
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan preferences: %v", err)
+		}
+
+		var batch []models.NotificationPreference
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal preferences: %v", err)
+		}
+		prefs = append(prefs, batch...)
+	}
+
+	return prefs, nil
+}
+
+// MarkDigestSent records when the last digest was sent to a user
+func (s *PreferenceService) MarkDigestSent(owner string, sentAt time.Time) error {
+	sentAtValue, err := attributevalue.Marshal(sentAt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal digest time: %v", err)
+	}
+
+	_, err = s.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"owner": &types.AttributeValueMemberS{Value: owner},
+		},
+		UpdateExpression: aws.String("SET last_digest_sent_at = :sent_at"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sent_at": sentAtValue,
+		},
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to mark digest sent: %v", err)
+	}
+
+	return nil
+}
+
+// GetPreference returns a user's preferences, or nil if they have none
+func (s *PreferenceService) GetPreference(owner string) (*models.NotificationPreference, error) {
+	resp, err := s.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences: %v", err)
+	}
+	if resp.Item == nil {
+		return nil, nil
+	}
+
+	var pref models.NotificationPreference
+	if err := attributevalue.UnmarshalMap(resp.Item, &pref); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal preferences: %v", err)
+	}
+
+	return &pref, nil
+}
+
+// DeletePreference removes a user's preferences
+func (s *PreferenceService) DeletePreference(owner string) error {
+	_, err := s.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete preferences: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/nats-io/nats.go"
+)
+
+// SubjectUserDeleted is published by the API when a user deletes their account
+const SubjectUserDeleted = "users.deleted"
+
+// Purge phases, in order
+const (
+	purgeOwned     = "owned"
+	purgeTriggered = "triggered"
+	purgeWebhooks  = "webhooks"
+	purgeRecords   = "records"
+	purgeDone      = "done"
+)
+
+// UserPreferenceStore reads and deletes one user's preferences
+type UserPreferenceStore interface {
+	GetPreference(owner string) (*models.NotificationPreference, error)
+	DeletePreference(owner string) error
+}
+
+// WebhookDeliveryStore pages through and deletes the webhook deliveries
+// of notifications a user received or triggered. Payloads copy the
+// trigger user's name, picture and the excerpt.
+type WebhookDeliveryStore interface {
+	// ListWebhookDeliveriesByUser pages through a user's deliveries.
+	// Cursors work like NotificationStore.ListByUser's.
+	ListWebhookDeliveriesByUser(userID, cursor string, limit int32) ([]models.WebhookDelivery, string, error)
+	DeleteWebhookDelivery(id string) error
+}
+
+// PrivacyService purges and exports everything the notification system
+// holds about a user. Preferences, devices, push subscriptions, webhook
+// deliveries and dead letters are only covered when their stores are set.
+type PrivacyService struct {
+	notifications *NotificationService
+	checkpoints   CheckpointStore
+	preferences   UserPreferenceStore
+	devices       DeviceStore
+	subscriptions PushSubscriptionStore
+	webhooks      WebhookDeliveryStore
+	deadLetters   DeadLetterStore
+}
+
+// NewPrivacyService creates a privacy service. Purges save their progress
+// in checkpoints so a redelivered event resumes an interrupted purge.
+func NewPrivacyService(notifications *NotificationService, checkpoints CheckpointStore) *PrivacyService {
+	return &PrivacyService{
+		notifications: notifications,
+		checkpoints:   checkpoints,
+	}
+}
+
+// SetPreferenceStore includes preferences in purges and exports
+func (s *PrivacyService) SetPreferenceStore(store UserPreferenceStore) {
+	s.preferences = store
+}
+
+// SetDeviceStore includes mobile devices in purges and exports
+func (s *PrivacyService) SetDeviceStore(store DeviceStore) {
+	s.devices = store
+}
+
+// SetPushSubscriptionStore includes browser push subscriptions in purges and exports
+func (s *PrivacyService) SetPushSubscriptionStore(store PushSubscriptionStore) {
+	s.subscriptions = store
+}
+
+// SetWebhookDeliveryStore includes the webhook delivery log in purges and exports
+func (s *PrivacyService) SetWebhookDeliveryStore(store WebhookDeliveryStore) {
+	s.webhooks = store
+}
+
+// SetDeadLetterStore includes dead letters that mention the user in
+// purges and exports
+func (s *PrivacyService) SetDeadLetterStore(store DeadLetterStore) {
+	s.deadLetters = store
+}
+
+// HandleUserDeleted purges a deleted user. Register it with
+// NotificationWorker.HandleLifecycle.
+func (s *PrivacyService) HandleUserDeleted(ctx context.Context, msg *nats.Msg) error {
+	var event models.UserDeletedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return &ValidationError{Field: "payload", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if strings.TrimSpace(event.UserID) == "" {
+		return &ValidationError{Field: "user_id", Message: "user_id is required"}
+	}
+
+	checkpoint, err := s.PurgeUser(ctx, event.UserID)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("purged deleted user",
+		"user_id", event.UserID,
+		"event_id", event.EventID,
+		"owned", checkpoint.Counts[purgeOwned],
+		"triggered", checkpoint.Counts[purgeTriggered],
+		"webhook_deliveries", checkpoint.Counts[purgeWebhooks],
+		"records", checkpoint.Counts[purgeRecords],
+	)
+	return nil
+}
+
+// PurgeUser deletes the notifications a user owns, the notifications
+// others received about the user's actions, the webhook deliveries of
+// both, the user's preferences, devices and push subscriptions, and dead
+// letters that mention the user. Owners of the triggered notifications
+// get notification-removed over Pusher. Progress is checkpointed after
+// every page; a purge that failed part way resumes from its checkpoint.
+func (s *PrivacyService) PurgeUser(ctx context.Context, userID string) (*models.Checkpoint, error) {
+	id := "purge#" + userID
+
+	checkpoint, err := s.checkpoints.GetCheckpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint == nil || checkpoint.Done {
+		// A finished purge is redone from the start: anything created
+		// since (a late event, a re-registered device) goes too
+		checkpoint = &models.Checkpoint{Id: id, Phase: purgeOwned}
+	} else {
+		slog.Info("resuming user purge", "user_id", userID, "phase", checkpoint.Phase)
+	}
+
+	for !checkpoint.Done {
+		switch checkpoint.Phase {
+		case purgeOwned, purgeTriggered:
+			err = s.purgePage(ctx, userID, checkpoint)
+		case purgeWebhooks:
+			err = s.purgeWebhookPage(userID, checkpoint)
+		case purgeRecords:
+			err = s.purgeRecords(userID, checkpoint)
+		default:
+			checkpoint.Done = true
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoint.UpdatedAt = time.Now()
+		if err := s.checkpoints.SaveCheckpoint(ctx, checkpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	return checkpoint, nil
+}
+
+// purgePage deletes one page of owned or triggered notifications and
+// moves the checkpoint on
+func (s *PrivacyService) purgePage(ctx context.Context, userID string, checkpoint *models.Checkpoint) error {
+	relation := RelationOwner
+	if checkpoint.Phase == purgeTriggered {
+		relation = RelationTrigger
+	}
+
+	page, cursor, err := s.notifications.store.ListByUser(ctx, relation, userID, checkpoint.Cursor, userPageSize)
+	if err != nil {
+		return err
+	}
+
+	if relation == RelationOwner {
+		// The owner is gone; nobody is left to tell
+		ids := make([]string, len(page))
+		for i, notif := range page {
+			ids[i] = notif.Id
+		}
+		if len(ids) > 0 {
+			if err := s.notifications.store.DeleteNotifications(ctx, ids); err != nil {
+				return err
+			}
+			notificationsRemoved.WithLabelValues(models.RemovedUserDeleted).Add(float64(len(ids)))
+		}
+	} else if err := s.notifications.removeNotifications(ctx, page, models.RemovedUserDeleted); err != nil {
+		return err
+	}
+
+	checkpoint.Add(checkpoint.Phase, len(page))
+	checkpoint.Cursor = cursor
+	if cursor == "" {
+		checkpoint.Phase = nextPurgePhase(checkpoint.Phase)
+	}
+	return nil
+}
+
+// purgeWebhookPage deletes one page of the user's webhook deliveries and
+// moves the checkpoint on
+func (s *PrivacyService) purgeWebhookPage(userID string, checkpoint *models.Checkpoint) error {
+	if s.webhooks == nil {
+		checkpoint.Phase = purgeRecords
+		return nil
+	}
+
+	page, cursor, err := s.webhooks.ListWebhookDeliveriesByUser(userID, checkpoint.Cursor, userPageSize)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range page {
+		if err := s.webhooks.DeleteWebhookDelivery(delivery.Id); err != nil {
+			return err
+		}
+	}
+
+	checkpoint.Add(purgeWebhooks, len(page))
+	checkpoint.Cursor = cursor
+	if cursor == "" {
+		checkpoint.Phase = purgeRecords
+	}
+	return nil
+}
+
+// purgeRecords deletes the user's preferences, devices, push
+// subscriptions and the dead letters that mention them
+func (s *PrivacyService) purgeRecords(userID string, checkpoint *models.Checkpoint) error {
+	if s.preferences != nil {
+		if err := s.preferences.DeletePreference(userID); err != nil {
+			return err
+		}
+	}
+
+	if s.devices != nil {
+		devices, err := s.devices.ListDevices(userID)
+		if err != nil {
+			return err
+		}
+		for _, device := range devices {
+			if err := s.devices.UnregisterDevice(userID, device.Token); err != nil {
+				return err
+			}
+		}
+		checkpoint.Add(purgeRecords, len(devices))
+	}
+
+	if s.subscriptions != nil {
+		subs, err := s.subscriptions.ListPushSubscriptions(userID)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			if err := s.subscriptions.DeletePushSubscription(userID, sub.Endpoint); err != nil {
+				return err
+			}
+		}
+		checkpoint.Add(purgeRecords, len(subs))
+	}
+
+	if s.deadLetters != nil {
+		letters, err := s.userDeadLetters(userID)
+		if err != nil {
+			return err
+		}
+		for _, letter := range letters {
+			if err := s.deadLetters.DeleteDeadLetter(letter.Id); err != nil {
+				return err
+			}
+		}
+		checkpoint.Add(purgeRecords, len(letters))
+	}
+
+	checkpoint.Phase = purgeDone
+	return nil
+}
+
+// nextPurgePhase returns the phase after a notification phase
+func nextPurgePhase(phase string) string {
+	if phase == purgeOwned {
+		return purgeTriggered
+	}
+	return purgeWebhooks
+}
+
+// userDeadLetters returns the dead letters whose payload mentions the
+// user. The dead letter queue is scanned whole; it should stay small.
+func (s *PrivacyService) userDeadLetters(userID string) ([]models.DeadLetter, error) {
+	letters, err := s.deadLetters.ListDeadLetters(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []models.DeadLetter
+	for _, letter := range letters {
+		if deadLetterMentionsUser(letter, userID) {
+			matched = append(matched, letter)
+		}
+	}
+	return matched, nil
+}
+
+// Export gathers everything held about a user, expired notifications included
+func (s *PrivacyService) Export(ctx context.Context, userID string) (*models.UserArchive, error) {
+	archive := &models.UserArchive{
+		UserID:     userID,
+		ExportedAt: time.Now().UTC(),
+	}
+
+	var err error
+	archive.Notifications, err = s.listAll(ctx, RelationOwner, userID)
+	if err != nil {
+		return nil, err
+	}
+	archive.Triggered, err = s.listAll(ctx, RelationTrigger, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.preferences != nil {
+		if archive.Preferences, err = s.preferences.GetPreference(userID); err != nil {
+			return nil, err
+		}
+	}
+	if s.devices != nil {
+		if archive.Devices, err = s.devices.ListDevices(userID); err != nil {
+			return nil, err
+		}
+	}
+	if s.subscriptions != nil {
+		if archive.PushSubscriptions, err = s.subscriptions.ListPushSubscriptions(userID); err != nil {
+			return nil, err
+		}
+	}
+	if s.webhooks != nil {
+		cursor := ""
+		for {
+			page, next, err := s.webhooks.ListWebhookDeliveriesByUser(userID, cursor, userPageSize)
+			if err != nil {
+				return nil, err
+			}
+			archive.WebhookDeliveries = append(archive.WebhookDeliveries, page...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+	}
+	if s.deadLetters != nil {
+		if archive.DeadLetters, err = s.userDeadLetters(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return archive, nil
+}
+
+// listAll reads every page of a user's owned or triggered notifications
+func (s *PrivacyService) listAll(ctx context.Context, relation UserRelation, userID string) ([]models.Notification, error) {
+	notifications := []models.Notification{}
+	cursor := ""
+	for {
+		page, next, err := s.notifications.store.ListByUser(ctx, relation, userID, cursor, userPageSize)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, page...)
+		if next == "" {
+			return notifications, nil
+		}
+		cursor = next
+	}
+}
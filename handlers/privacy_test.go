@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// memoryDeadLetters is a DeadLetterStore kept in a map
+type memoryDeadLetters struct {
+	mu      sync.Mutex
+	letters map[string]models.DeadLetter
+}
+
+func newMemoryDeadLetters(letters ...models.DeadLetter) *memoryDeadLetters {
+	s := &memoryDeadLetters{letters: make(map[string]models.DeadLetter)}
+	for _, letter := range letters {
+		s.letters[letter.Id] = letter
+	}
+	return s
+}
+
+func (s *memoryDeadLetters) RecordDeadLetter(letter models.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters[letter.Id] = letter
+	return nil
+}
+
+func (s *memoryDeadLetters) ListDeadLetters(limit int32) ([]models.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var letters []models.DeadLetter
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].Id < letters[j].Id })
+	return letters, nil
+}
+
+func (s *memoryDeadLetters) GetDeadLetter(id string) (*models.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letter, ok := s.letters[id]
+	if !ok {
+		return nil, fmt.Errorf("dead letter %s not found", id)
+	}
+	return &letter, nil
+}
+
+func (s *memoryDeadLetters) DeleteDeadLetter(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.letters, id)
+	return nil
+}
+
+// ids returns the ids left, sorted
+func (s *memoryDeadLetters) ids() []string {
+	letters, _ := s.ListDeadLetters(0)
+	ids := make([]string, len(letters))
+	for i, letter := range letters {
+		ids[i] = letter.Id
+	}
+	return ids
+}
+
+// newTestPrivacyService creates a privacy service over in-memory stores
+// holding deliveries and dead letters that do and don't involve user-123
+func newTestPrivacyService(t *testing.T) (*PrivacyService, *MemoryWebhookStore, *memoryDeadLetters) {
+	t.Helper()
+
+	store, err := NewMemoryNotificationStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryNotificationStore: %v", err)
+	}
+	now := time.Now()
+	err = store.PutNotifications(context.Background(), []models.Notification{
+		{Id: "n-owned", Owner: "user-123", UserId: "user-9", Action: models.ActionLikePost, ResourceType: models.ResourceTypePost, ResourceId: "post-1", CreatedAt: now},
+		{Id: "n-triggered", Owner: "user-9", UserId: "user-123", Action: models.ActionFollow, ResourceType: models.ResourceTypeUser, ResourceId: "user-9", CreatedAt: now},
+	})
+	if err != nil {
+		t.Fatalf("PutNotifications: %v", err)
+	}
+
+	webhooks := NewMemoryWebhookStore(nil, 100)
+	for _, delivery := range []models.WebhookDelivery{
+		{Id: "d-owned", NotificationId: "n-owned", Owner: "user-123", UserId: "user-9", Payload: `{"data":{"user_id":"user-9","excerpt":"Hi"}}`},
+		{Id: "d-triggered", NotificationId: "n-triggered", Owner: "user-9", UserId: "user-123", Payload: `{"data":{"user_id":"user-123","username":"Jane"}}`},
+		{Id: "d-legacy", NotificationId: "n-old", Payload: `{"data":{"user_id":"user-123","username":"Jane"}}`},
+		{Id: "d-other", NotificationId: "n-other", Owner: "user-9", UserId: "user-1234", Payload: `{"data":{"user_id":"user-1234","excerpt":"ask user-123"}}`},
+	} {
+		webhooks.RecordWebhookDelivery(delivery)
+	}
+
+	deadLetters := newMemoryDeadLetters(
+		models.DeadLetter{Id: "l-owner", Data: []byte(`{"owner":"user-123","trigger_user":"user-9","username":"Omar"}`)},
+		models.DeadLetter{Id: "l-cloudevent", Data: []byte(`{"specversion":"1.0","data":{"owner":"user-9","trigger_user":"user-123"}}`)},
+		models.DeadLetter{Id: "l-protobuf", Data: append([]byte{0x1a, 0x08}, "user-123"...)},
+		models.DeadLetter{Id: "l-other", Data: []byte(`{"owner":"user-1234","excerpt":"not user-123's"}`)},
+	)
+
+	privacy := NewPrivacyService(NewNotificationServiceWithStore(store, "", "", "", ""), NewMemoryCheckpointStore())
+	privacy.SetWebhookDeliveryStore(webhooks)
+	privacy.SetDeadLetterStore(deadLetters)
+	return privacy, webhooks, deadLetters
+}
+
+func TestPrivacyExportIncludesDeliveriesAndDeadLetters(t *testing.T) {
+	privacy, _, _ := newTestPrivacyService(t)
+
+	archive, err := privacy.Export(context.Background(), "user-123")
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	var deliveries []string
+	for _, delivery := range archive.WebhookDeliveries {
+		deliveries = append(deliveries, delivery.Id)
+	}
+	if fmt.Sprint(deliveries) != "[d-legacy d-owned d-triggered]" {
+		t.Errorf("exported deliveries = %v, want d-legacy, d-owned and d-triggered", deliveries)
+	}
+
+	var letters []string
+	for _, letter := range archive.DeadLetters {
+		letters = append(letters, letter.Id)
+	}
+	if fmt.Sprint(letters) != "[l-cloudevent l-owner l-protobuf]" {
+		t.Errorf("exported dead letters = %v, want l-cloudevent, l-owner and l-protobuf", letters)
+	}
+}
+
+func TestPurgeUserDeletesDeliveriesAndDeadLetters(t *testing.T) {
+	privacy, webhooks, deadLetters := newTestPrivacyService(t)
+
+	checkpoint, err := privacy.PurgeUser(context.Background(), "user-123")
+	if err != nil {
+		t.Fatalf("PurgeUser: %v", err)
+	}
+	if !checkpoint.Done {
+		t.Errorf("checkpoint = %+v, want done", checkpoint)
+	}
+	if got := checkpoint.Counts[purgeWebhooks]; got != 3 {
+		t.Errorf("purged %d webhook deliveries, want 3", got)
+	}
+
+	remaining, _, err := webhooks.ListWebhookDeliveriesByUser("user-9", "", 0)
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveriesByUser: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].Id != "d-other" {
+		t.Errorf("deliveries left = %+v, want only d-other", remaining)
+	}
+	if ids := deadLetters.ids(); fmt.Sprint(ids) != "[l-other]" {
+		t.Errorf("dead letters left = %v, want only l-other", ids)
+	}
+}
+
+func TestPurgeUserResumesWebhookPhase(t *testing.T) {
+	privacy, webhooks, _ := newTestPrivacyService(t)
+	ctx := context.Background()
+
+	// A purge interrupted in the webhook phase, past d-legacy
+	err := privacy.checkpoints.SaveCheckpoint(ctx, &models.Checkpoint{
+		Id:     "purge#user-123",
+		Phase:  purgeWebhooks,
+		Cursor: "d-legacy",
+		Counts: map[string]int{purgeWebhooks: 1},
+	})
+	if err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+
+	checkpoint, err := privacy.PurgeUser(ctx, "user-123")
+	if err != nil {
+		t.Fatalf("PurgeUser: %v", err)
+	}
+	if got := checkpoint.Counts[purgeWebhooks]; got != 3 {
+		t.Errorf("purged %d webhook deliveries in total, want 3", got)
+	}
+
+	// The resumed run starts after the cursor
+	left, _, _ := webhooks.ListWebhookDeliveriesByUser("user-123", "", 0)
+	if len(left) != 1 || left[0].Id != "d-legacy" {
+		t.Errorf("user deliveries left = %+v, want d-legacy, before the cursor", left)
+	}
+}
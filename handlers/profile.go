@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/nats-io/nats.go"
+)
+
+// SubjectProfileUpdated is published by the API when a user changes their
+// name, picture or bio
+const SubjectProfileUpdated = "users.profile.updated"
+
+// HandleProfileUpdated refreshes the trigger user snapshot of the
+// notifications a user triggered. Register it with
+// NotificationWorker.HandleLifecycle.
+func (s *NotificationService) HandleProfileUpdated(ctx context.Context, msg *nats.Msg) error {
+	var event models.ProfileUpdatedEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return &ValidationError{Field: "payload", Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if strings.TrimSpace(event.UserID) == "" {
+		return &ValidationError{Field: "user_id", Message: "user_id is required"}
+	}
+	if event.Empty() {
+		return &ValidationError{Field: "payload", Message: "one of username, userpic or userbio is required"}
+	}
+
+	// Reads are hydrated right away; the refresh below can take a while
+	if s.profiles != nil {
+		s.profiles.Update(event.UserID, event.ProfileUpdate)
+	}
+
+	refreshed, err := s.RefreshUserSnapshots(ctx, event.UserID, event.ProfileUpdate)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("refreshed profile snapshots",
+		"user_id", event.UserID,
+		"event_id", event.EventID,
+		"refreshed", refreshed,
+	)
+	return nil
+}
+
+// RefreshUserSnapshots applies a profile update to the unexpired
+// notifications a user triggered within the refresh window, re-rendering
+// their text when the name changed. Only the window is read. Notifications
+// already up to date are skipped, so running it twice is harmless. Returns
+// how many were updated.
+func (s *NotificationService) RefreshUserSnapshots(ctx context.Context, userID string, update models.ProfileUpdate) (int, error) {
+	now := time.Now()
+	var since time.Time
+	if s.snapshotWindow > 0 {
+		since = now.Add(-s.snapshotWindow)
+	}
+
+	refreshed := 0
+	cursor := ""
+	for {
+		page, next, err := s.store.ListTriggeredSince(ctx, userID, since, cursor, userPageSize)
+		if err != nil {
+			return refreshed, err
+		}
+
+		var changed []models.Notification
+		for _, notif := range page {
+			if notif.Expired(now) {
+				continue
+			}
+			if s.applyProfile(&notif, update) {
+				changed = append(changed, notif)
+			}
+		}
+
+		if len(changed) > 0 {
+			if err := s.store.UpdateSnapshots(ctx, changed); err != nil {
+				return refreshed, err
+			}
+			refreshed += len(changed)
+			snapshotsRefreshed.Add(float64(len(changed)))
+		}
+
+		if next == "" {
+			return refreshed, nil
+		}
+		cursor = next
+	}
+}
+
+// applyProfile applies a profile update to a notification, re-rendering
+// its text if the name changed. Reports whether anything changed.
+func (s *NotificationService) applyProfile(notif *models.Notification, update models.ProfileUpdate) bool {
+	name := notif.UserName
+	if !update.Apply(notif) {
+		return false
+	}
+
+	// Only text rendered at creation is re-rendered
+	if s.renderer != nil && notif.UserName != name && notif.Title != "" {
+		msg := s.renderer.Render(*notif, notif.Locale)
+		notif.Title = msg.Title
+		notif.Body = msg.Body
+	}
+	return true
+}
+
+// hydrate overlays cached profiles onto notifications read from the store
+func (s *NotificationService) hydrate(notifications []models.Notification) {
+	if s.profiles == nil {
+		return
+	}
+	for i := range notifications {
+		if update, ok := s.profiles.Get(notifications[i].UserId); ok {
+			s.applyProfile(&notifications[i], update)
+		}
+	}
+}
+
+// ProfileCache remembers recent profile updates so notifications are
+// shown with the new profile before, or without, a snapshot refresh.
+// It holds at most size users, dropping the least recently updated.
+type ProfileCache struct {
+	mu       sync.Mutex
+	size     int
+	profiles map[string]cachedProfile // user id -> merged updates
+}
+
+// cachedProfile is a user's merged profile updates
+type cachedProfile struct {
+	update    models.ProfileUpdate
+	updatedAt time.Time
+}
+
+// NewProfileCache creates a cache holding up to size users
+func NewProfileCache(size int) *ProfileCache {
+	return &ProfileCache{
+		size:     size,
+		profiles: make(map[string]cachedProfile),
+	}
+}
+
+// Update merges a profile update into the user's cached profile
+func (c *ProfileCache) Update(userID string, update models.ProfileUpdate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := c.profiles[userID]
+	if update.UserName != nil {
+		cached.update.UserName = update.UserName
+	}
+	if update.UserPic != nil {
+		cached.update.UserPic = update.UserPic
+	}
+	if update.UserBio != nil {
+		cached.update.UserBio = update.UserBio
+	}
+	cached.updatedAt = time.Now()
+	c.profiles[userID] = cached
+
+	// Profile changes are rare, so a scan for the oldest entry is cheap enough
+	for len(c.profiles) > c.size {
+		oldest := ""
+		for id, profile := range c.profiles {
+			if oldest == "" || profile.updatedAt.Before(c.profiles[oldest].updatedAt) {
+				oldest = id
+			}
+		}
+		delete(c.profiles, oldest)
+	}
+}
+
+// Get returns a user's cached profile
+func (c *ProfileCache) Get(userID string) (models.ProfileUpdate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.profiles[userID]
+	return cached.update, ok
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// sinceRecordingStore records the since of each ListTriggeredSince call
+type sinceRecordingStore struct {
+	*MemoryNotificationStore
+	since []time.Time
+}
+
+func (s *sinceRecordingStore) ListTriggeredSince(ctx context.Context, userID string, since time.Time, cursor string, limit int32) ([]models.Notification, string, error) {
+	s.since = append(s.since, since)
+	return s.MemoryNotificationStore.ListTriggeredSince(ctx, userID, since, cursor, limit)
+}
+
+// triggeredBy returns a notification user-2 triggered age ago, with its
+// expiry set
+func triggeredBy(id string, action int, age time.Duration) models.Notification {
+	notif := models.Notification{
+		Id:           id,
+		Owner:        "owner-1",
+		UserId:       "user-2",
+		UserName:     "Jane",
+		Action:       action,
+		ResourceType: models.ResourceTypePost,
+		ResourceId:   "post-" + id,
+		CreatedAt:    time.Now().Add(-age),
+	}
+	notif.SetExpiry()
+	return notif
+}
+
+func TestRefreshUserSnapshotsOnlyReadsTheWindow(t *testing.T) {
+	day := 24 * time.Hour
+	memory, err := NewMemoryNotificationStore("")
+	if err != nil {
+		t.Fatalf("NewMemoryNotificationStore: %v", err)
+	}
+	stranger := triggeredBy("n-stranger", models.ActionLikePost, day)
+	stranger.UserId = "user-3"
+	err = memory.PutNotifications(context.Background(), []models.Notification{
+		triggeredBy("n-recent", models.ActionLikePost, day),
+		triggeredBy("n-reply", models.ActionReplyPost, 50*day),
+		triggeredBy("n-expired", models.ActionLikePost, 40*day), // Likes are kept 30 days
+		triggeredBy("n-old", models.ActionReplyPost, 70*day),
+		stranger,
+	})
+	if err != nil {
+		t.Fatalf("PutNotifications: %v", err)
+	}
+
+	store := &sinceRecordingStore{MemoryNotificationStore: memory}
+	service := NewNotificationServiceWithStore(store, "", "", "", "")
+	service.SetSnapshotRefreshWindow(60 * day)
+
+	name := "Johnny"
+	refreshed, err := service.RefreshUserSnapshots(context.Background(), "user-2", models.ProfileUpdate{UserName: &name})
+	if err != nil {
+		t.Fatalf("RefreshUserSnapshots: %v", err)
+	}
+	if refreshed != 2 {
+		t.Errorf("refreshed %d notifications, want 2", refreshed)
+	}
+
+	if len(store.since) == 0 || time.Since(store.since[0]) < 60*day-time.Minute {
+		t.Errorf("listed since %v, want 60 days ago", store.since)
+	}
+
+	notifications, _ := memory.ListNotifications(context.Background(), "owner-1", 0)
+	for _, notif := range notifications {
+		want := "Jane"
+		if notif.Id == "n-recent" || notif.Id == "n-reply" {
+			want = "Johnny"
+		}
+		if notif.UserName != want {
+			t.Errorf("%s username = %q, want %q", notif.Id, notif.UserName, want)
+		}
+	}
+
+	// Up to date notifications are skipped
+	refreshed, err = service.RefreshUserSnapshots(context.Background(), "user-2", models.ProfileUpdate{UserName: &name})
+	if err != nil || refreshed != 0 {
+		t.Errorf("second refresh = %d, %v, want 0", refreshed, err)
+	}
+}
+
+func TestRefreshUserSnapshotsRerendersText(t *testing.T) {
+	service, store, _ := newTestMemoryService(t)
+	service.pusherClient = nil
+	service.SetMessageRenderer(newTestMessageRenderer(t))
+
+	notif := triggeredBy("", models.ActionLikePost, time.Hour)
+	if err := service.CreateNotification(context.Background(), notif); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+
+	name := "Johnny"
+	if _, err := service.RefreshUserSnapshots(context.Background(), "user-2", models.ProfileUpdate{UserName: &name}); err != nil {
+		t.Fatalf("RefreshUserSnapshots: %v", err)
+	}
+
+	notifications, _ := store.ListNotifications(context.Background(), "owner-1", 0)
+	if len(notifications) != 1 || !strings.Contains(notifications[0].Title+notifications[0].Body, "Johnny") {
+		t.Errorf("notifications = %+v, want text naming Johnny", notifications)
+	}
+}
+
+func TestProfileCacheMergesAndEvicts(t *testing.T) {
+	cache := NewProfileCache(2)
+	name, pic := "Johnny", "https://cdn.exobook.test/j.png"
+
+	cache.Update("user-1", models.ProfileUpdate{UserName: &name})
+	cache.Update("user-1", models.ProfileUpdate{UserPic: &pic})
+	update, ok := cache.Get("user-1")
+	if !ok || update.UserName == nil || *update.UserName != name || update.UserPic == nil || *update.UserPic != pic {
+		t.Errorf("user-1 = %+v, want the name and picture merged", update)
+	}
+
+	time.Sleep(time.Millisecond)
+	cache.Update("user-2", models.ProfileUpdate{UserName: &name})
+	time.Sleep(time.Millisecond)
+	cache.Update("user-1", models.ProfileUpdate{UserName: &name}) // user-2 is now the oldest
+	time.Sleep(time.Millisecond)
+	cache.Update("user-3", models.ProfileUpdate{UserName: &name})
+
+	if _, ok := cache.Get("user-2"); ok {
+		t.Error("user-2 was kept, want it evicted as the least recently updated")
+	}
+	for _, id := range []string{"user-1", "user-3"} {
+		if _, ok := cache.Get(id); !ok {
+			t.Errorf("%s was evicted", id)
+		}
+	}
+}
+
+func TestGetNotificationsByOwnerHydratesCachedProfiles(t *testing.T) {
+	service, store, _ := newTestMemoryService(t, triggeredBy("n-1", models.ActionLikePost, time.Hour))
+	cache := NewProfileCache(10)
+	service.SetProfileCache(cache)
+
+	name := "Johnny"
+	cache.Update("user-2", models.ProfileUpdate{UserName: &name})
+
+	notifications, err := service.GetNotificationsByOwner("owner-1", 10)
+	if err != nil {
+		t.Fatalf("GetNotificationsByOwner: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].UserName != "Johnny" {
+		t.Errorf("notifications = %+v, want the cached name", notifications)
+	}
+
+	// Hydration only changes what is read
+	stored, _ := store.ListNotifications(context.Background(), "owner-1", 0)
+	if stored[0].UserName != "Jane" {
+		t.Errorf("stored username = %q, want Jane", stored[0].UserName)
+	}
+}
+
+func TestDynamoDBListTriggeredSinceUsesSortKey(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.Write([]byte(`{"Count":0,"Items":[],"ScannedCount":0}`))
+	}))
+	defer server.Close()
+
+	store := testDynamoDBStore(server.URL)
+	since := time.Date(2026, 7, 20, 0, 0, 0, 0, time.UTC)
+	if _, _, err := store.ListTriggeredSince(context.Background(), "user-2", since, "", 100); err != nil {
+		t.Fatalf("ListTriggeredSince: %v", err)
+	}
+
+	for _, want := range []string{`"IndexName":"TriggerUserIndex"`, `created_at >= :since`, `"2026-07-20T00:00:00Z"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Query %s lacks %s", body, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/nats-io/nats.go"
+)
+
+// NATS subjects used by the API to manage browser push subscriptions
+const (
+	SubjectWebPushRegister   = "webpush.subscriptions.register"
+	SubjectWebPushUnregister = "webpush.subscriptions.unregister"
+)
+
+// PushSubscriptionStore stores browser push subscriptions per user
+type PushSubscriptionStore interface {
+	SavePushSubscription(sub models.PushSubscription) error
+	DeletePushSubscription(owner, endpoint string) error
+	ListPushSubscriptions(owner string) ([]models.PushSubscription, error)
+}
+
+// PushSubscriptionService stores push subscriptions in DynamoDB
+// Table key: owner (hash) + endpoint (range)
+type PushSubscriptionService struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// NewPushSubscriptionService creates a new push subscription service
+func NewPushSubscriptionService(region, tableName string) (*PushSubscriptionService, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &PushSubscriptionService{
+		client:    dynamodb.NewFromConfig(cfg),
+		tableName: tableName,
+	}, nil
+}
+
+// SavePushSubscription creates or replaces a subscription
+func (s *PushSubscriptionService) SavePushSubscription(sub models.PushSubscription) error {
+	if sub.CreatedAt.IsZero() {
+		sub.CreatedAt = time.Now()
+	}
+
+	item, err := attributevalue.MarshalMap(sub)
+	if err != nil {
+		return fmt.Errorf("failed to marshal push subscription: %v", err)
+	}
+
+	_, err = s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save push subscription: %v", err)
+	}
+
+	return nil
+}
+
+// DeletePushSubscription removes a subscription
+func (s *PushSubscriptionService) DeletePushSubscription(owner, endpoint string) error {
+	_, err := s.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"owner":    &types.AttributeValueMemberS{Value: owner},
+			"endpoint": &types.AttributeValueMemberS{Value: endpoint},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete push subscription: %v", err)
+	}
+
+	return nil
+}
+
+// ListPushSubscriptions returns every subscription registered by a user
+func (s *PushSubscriptionService) ListPushSubscriptions(owner string) ([]models.PushSubscription, error) {
+	resp, err := s.client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner", // 'owner' is a reserved keyword
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query push subscriptions: %v", err)
+	}
+
+	var subs []models.PushSubscription
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, &subs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal push subscriptions: %v", err)
+	}
+
+	return subs, nil
+}
+
+// SubscribePushRegistrations listens for subscription register/unregister
+// messages from the API and applies them to the store
+func SubscribePushRegistrations(nc *nats.Conn, store PushSubscriptionStore) ([]*nats.Subscription, error) {
+	register, err := nc.Subscribe(SubjectWebPushRegister, func(msg *nats.Msg) {
+		var sub models.PushSubscription
+		if err := json.Unmarshal(msg.Data, &sub); err != nil {
+			slog.Error("failed to unmarshal push subscription", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		if sub.Owner == "" || sub.Endpoint == "" || sub.P256dh == "" || sub.Auth == "" {
+			slog.Warn("invalid push subscription: owner, endpoint, p256dh and auth are required", "subject", msg.Subject)
+			return
+		}
+
+		if err := store.SavePushSubscription(sub); err != nil {
+			slog.Error("push subscription update failed", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		slog.Info("registered push subscription", "owner", sub.Owner)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unregister, err := nc.Subscribe(SubjectWebPushUnregister, func(msg *nats.Msg) {
+		var sub models.PushSubscription
+		if err := json.Unmarshal(msg.Data, &sub); err != nil {
+			slog.Error("failed to unmarshal push subscription", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		if err := store.DeletePushSubscription(sub.Owner, sub.Endpoint); err != nil {
+			slog.Error("push subscription update failed", "subject", msg.Subject, "error", err)
+			return
+		}
+
+		slog.Info("removed push subscription", "owner", sub.Owner)
+	})
+	if err != nil {
+		register.Unsubscribe()
+		return nil, err
+	}
+
+	return []*nats.Subscription{register, unregister}, nil
+}
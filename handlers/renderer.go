@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+//go:embed templates/messages/*.json
+var messageCatalogs embed.FS
+
+// defaultMessageKey is used for actions without their own templates
+const defaultMessageKey = "default"
+
+// RenderedMessage is the human readable text of a notification
+type RenderedMessage struct {
+	Title string
+	Body  string
+}
+
+// messageData is the data passed to message templates
+type messageData struct {
+	Actor        string // Trigger user's name (the most recent one for aggregates)
+	Count        int    // Number of notifications rendered together
+	Others       int    // Count - 1
+	Excerpt      string
+	ResourceType string
+}
+
+// pluralForms holds one template per plural category ("=0", "one", "other", ...)
+type pluralForms map[string]*template.Template
+
+// UnmarshalJSON accepts either a plain string or an object of plural forms
+func (p *pluralForms) UnmarshalJSON(data []byte) error {
+	var forms map[string]string
+
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		forms = map[string]string{"other": single}
+	} else if err := json.Unmarshal(data, &forms); err != nil {
+		return err
+	}
+
+	if _, ok := forms["other"]; !ok {
+		return fmt.Errorf("plural forms must include \"other\"")
+	}
+
+	*p = make(pluralForms, len(forms))
+	for form, text := range forms {
+		tmpl, err := template.New(form).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return fmt.Errorf("form %q: %v", form, err)
+		}
+		(*p)[form] = tmpl
+	}
+
+	return nil
+}
+
+// execute renders the form matching n
+func (p pluralForms) execute(locale string, n int, data messageData) string {
+	tmpl, ok := p["="+strconv.Itoa(n)]
+	if !ok {
+		tmpl, ok = p[pluralCategory(locale, n)]
+	}
+	if !ok {
+		tmpl = p["other"]
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+// pluralCategory returns the CLDR cardinal category for an integer
+func pluralCategory(locale string, n int) string {
+	switch locale {
+	case "fr":
+		// French treats 0 and 1 as singular
+		if n == 0 || n == 1 {
+			return "one"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+	}
+	return "other"
+}
+
+// messageTemplate is one catalog entry
+type messageTemplate struct {
+	Title pluralForms `json:"title"` // Pluralized on the total count
+	Body  pluralForms `json:"body"`  // Pluralized on the number of other actors
+}
+
+// MessageRenderer renders notification text from per-locale catalogs
+type MessageRenderer struct {
+	defaultLocale string
+	catalogs      map[string]map[string]messageTemplate
+}
+
+// NewMessageRenderer loads the embedded message catalogs
+func NewMessageRenderer(defaultLocale string) (*MessageRenderer, error) {
+	files, err := messageCatalogs.ReadDir("templates/messages")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message catalogs: %v", err)
+	}
+
+	catalogs := make(map[string]map[string]messageTemplate, len(files))
+	for _, file := range files {
+		data, err := messageCatalogs.ReadFile(path.Join("templates/messages", file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file.Name(), err)
+		}
+
+		var catalog map[string]messageTemplate
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", file.Name(), err)
+		}
+
+		if _, ok := catalog[defaultMessageKey]; !ok {
+			return nil, fmt.Errorf("%s is missing the %q message", file.Name(), defaultMessageKey)
+		}
+
+		catalogs[strings.TrimSuffix(file.Name(), ".json")] = catalog
+	}
+
+	if _, ok := catalogs[defaultLocale]; !ok {
+		return nil, fmt.Errorf("no message catalog for default locale %q", defaultLocale)
+	}
+
+	return &MessageRenderer{
+		defaultLocale: defaultLocale,
+		catalogs:      catalogs,
+	}, nil
+}
+
+// Render renders a single notification in the given locale
+func (r *MessageRenderer) Render(notif models.Notification, locale string) RenderedMessage {
+	return r.RenderAggregate([]models.Notification{notif}, locale)
+}
+
+// RenderAggregate renders notifications that share an action and resource
+// as one message, e.g. "Jo and 3 others liked your post". The first
+// notification is treated as the most recent one.
+func (r *MessageRenderer) RenderAggregate(notifs []models.Notification, locale string) RenderedMessage {
+	if len(notifs) == 0 {
+		return RenderedMessage{}
+	}
+
+	locale = r.resolveLocale(locale)
+	latest := notifs[0]
+	msg := r.lookup(locale, latest.Action, latest.ResourceType)
+
+	data := messageData{
+		Actor:        latest.UserName,
+		Count:        len(notifs),
+		Others:       countOtherActors(notifs),
+		Excerpt:      latest.Excerpt,
+		ResourceType: latest.ResourceType,
+	}
+
+	return RenderedMessage{
+		Title: msg.Title.execute(locale, data.Count, data),
+		Body:  msg.Body.execute(locale, data.Others, data),
+	}
+}
+
+// resolveLocale maps a requested locale (fr-CA, fr_ca, FR) to a loaded catalog
+func (r *MessageRenderer) resolveLocale(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if _, ok := r.catalogs[locale]; ok {
+		return locale
+	}
+
+	language, _, _ := strings.Cut(locale, "-")
+	if _, ok := r.catalogs[language]; ok {
+		return language
+	}
+
+	return r.defaultLocale
+}
+
+// lookup finds the template for an action and resource type, preferring
+// "key.resource" over "key" and falling back to the default message
+func (r *MessageRenderer) lookup(locale string, action int, resourceType string) messageTemplate {
+	catalog := r.catalogs[locale]
+	key := messageKey(action)
+
+	candidates := []string{
+		key + "." + strings.ToLower(resourceType),
+		key,
+		defaultMessageKey,
+	}
+
+	for _, candidate := range candidates {
+		if msg, ok := catalog[candidate]; ok && msg.Title != nil && msg.Body != nil {
+			return msg
+		}
+	}
+
+	// The locale's catalog has no default - fall back to the default locale
+	return r.catalogs[r.defaultLocale][defaultMessageKey]
+}
+
+// messageKey returns the catalog key used for an action
+func messageKey(action int) string {
+	if a, ok := models.LookupAction(action); ok && a.TemplateKey != "" {
+		return a.TemplateKey
+	}
+	return defaultMessageKey
+}
+
+// countOtherActors counts distinct trigger users besides the most recent one
+func countOtherActors(notifs []models.Notification) int {
+	seen := map[string]bool{notifs[0].UserId: true}
+	for _, notif := range notifs[1:] {
+		seen[notif.UserId] = true
+	}
+	return len(seen) - 1
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TableInfo summarizes the notifications table
+type TableInfo struct {
+	Name          string      `json:"name"`
+	Status        string      `json:"status"`
+	KeySchema     []string    `json:"key_schema"` // "attribute (HASH|RANGE)"
+	Indexes       []IndexInfo `json:"indexes"`
+	ItemCount     int64       `json:"item_count"`     // Approximate, refreshed by DynamoDB every ~6 hours
+	TTL           string      `json:"ttl"`            // TTL attribute and status, empty when disabled
+	SchemaVersion int         `json:"schema_version"` // Latest applied migration, see TableMigrations
+}
+
+// IndexInfo summarizes a global secondary index
+type IndexInfo struct {
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	KeySchema []string `json:"key_schema"`
+}
+
+// DescribeTableInfo returns the notifications table's key schema, indexes and TTL
+func (s *DynamoDBNotificationStore) DescribeTableInfo(ctx context.Context) (*TableInfo, error) {
+	start := time.Now()
+	resp, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.tableName),
+	})
+	observeDynamoDB("DescribeTable", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe table %s: %v", s.tableName, err)
+	}
+
+	table := resp.Table
+	info := &TableInfo{
+		Name:      aws.ToString(table.TableName),
+		Status:    string(table.TableStatus),
+		KeySchema: formatKeySchema(table.KeySchema),
+		ItemCount: aws.ToInt64(table.ItemCount),
+	}
+
+	for _, index := range table.GlobalSecondaryIndexes {
+		info.Indexes = append(info.Indexes, IndexInfo{
+			Name:      aws.ToString(index.IndexName),
+			Status:    string(index.IndexStatus),
+			KeySchema: formatKeySchema(index.KeySchema),
+		})
+	}
+
+	ttl, err := s.client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(s.tableName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe TTL of %s: %v", s.tableName, err)
+	}
+	if desc := ttl.TimeToLiveDescription; desc != nil && desc.TimeToLiveStatus != types.TimeToLiveStatusDisabled {
+		info.TTL = fmt.Sprintf("%s (%s)", aws.ToString(desc.AttributeName), desc.TimeToLiveStatus)
+	}
+
+	info.SchemaVersion, err = s.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// formatKeySchema formats key elements as "attribute (HASH|RANGE)"
+func formatKeySchema(keys []types.KeySchemaElement) []string {
+	formatted := make([]string, len(keys))
+	for i, key := range keys {
+		formatted[i] = fmt.Sprintf("%s (%s)", aws.ToString(key.AttributeName), key.KeyType)
+	}
+	return formatted
+}
@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// NotificationTTLAttribute is the attribute DynamoDB TTL expires notifications by
+const NotificationTTLAttribute = "expires_at"
+
+// tableSchemaItemID is the id of the item recording the applied migration
+// version. It has no owner, so it never shows up in OwnerIndex queries.
+const tableSchemaItemID = "_schema"
+
+// tableWaitTimeout bounds how long table creation and index backfills may take
+const tableWaitTimeout = 30 * time.Minute
+
+// indexPollInterval is how often a new index's status is checked
+var indexPollInterval = 5 * time.Second
+
+// IndexSpec describes a global secondary index. Keys are string attributes
+// and every attribute is projected.
+type IndexSpec struct {
+	Name         string
+	PartitionKey string
+	SortKey      string // Optional
+}
+
+// TableMigration adds an index to the notifications table. Migrations are
+// applied in version order and the latest applied version is recorded in
+// the table, so each runs once.
+type TableMigration struct {
+	Version     int
+	Description string
+	AddIndex    IndexSpec
+}
+
+// tableMigrations lists every migration, in version order. Append new
+// indexes here; never edit or reorder applied migrations.
+var tableMigrations = []TableMigration{
+	{
+		Version:     1,
+		Description: "Query notifications by owner, latest first",
+		AddIndex:    IndexSpec{Name: "OwnerIndex", PartitionKey: "owner", SortKey: "created_at"},
+	},
+	{
+		Version:     2,
+		Description: "Find notifications about a resource when it is deleted",
+		AddIndex:    IndexSpec{Name: "ResourceIndex", PartitionKey: "resource_id", SortKey: "created_at"},
+	},
+	{
+		Version:     3,
+		Description: "Find replies and mentions caused by a deleted comment",
+		AddIndex:    IndexSpec{Name: "SourceIndex", PartitionKey: "source_id", SortKey: "created_at"},
+	},
+	{
+		Version:     4,
+		Description: "Find notifications a user triggered, for privacy purges and exports",
+		AddIndex:    IndexSpec{Name: "TriggerUserIndex", PartitionKey: "userid", SortKey: "created_at"},
+	},
+}
+
+// TableMigrations returns every migration, in version order
+func TableMigrations() []TableMigration {
+	return append([]TableMigration(nil), tableMigrations...)
+}
+
+// LatestTableVersion returns the version of the last migration
+func LatestTableVersion() int {
+	return tableMigrations[len(tableMigrations)-1].Version
+}
+
+// EnsureTable creates the notifications table if it is missing, enables
+// TTL and applies pending migrations. It is safe to run repeatedly and
+// returns the steps it took.
+func (s *DynamoDBNotificationStore) EnsureTable(ctx context.Context) ([]string, error) {
+	var steps []string
+
+	_, err := s.describeTable(ctx)
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		if err := s.createTable(ctx); err != nil {
+			return steps, err
+		}
+		steps = append(steps, fmt.Sprintf("created table %s", s.tableName))
+	case err != nil:
+		return steps, fmt.Errorf("failed to describe table %s: %v", s.tableName, err)
+	}
+
+	enabled, err := s.enableTTL(ctx)
+	if err != nil {
+		return steps, err
+	}
+	if enabled {
+		steps = append(steps, fmt.Sprintf("enabled TTL on %s", NotificationTTLAttribute))
+	}
+
+	applied, err := s.Migrate(ctx, false)
+	for _, migration := range applied {
+		steps = append(steps, fmt.Sprintf("applied migration %d: %s", migration.Version, migration.Description))
+	}
+	return steps, err
+}
+
+// Migrate applies pending migrations and returns them. With dryRun it only
+// returns what would be applied.
+func (s *DynamoDBNotificationStore) Migrate(ctx context.Context, dryRun bool) ([]TableMigration, error) {
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []TableMigration
+	for _, migration := range tableMigrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+	if dryRun {
+		return pending, nil
+	}
+
+	var applied []TableMigration
+	for _, migration := range pending {
+		slog.Info("applying table migration", "table", s.tableName, "version", migration.Version, "description", migration.Description)
+
+		if err := s.addIndex(ctx, migration.AddIndex); err != nil {
+			return applied, fmt.Errorf("migration %d failed: %v", migration.Version, err)
+		}
+		if err := s.setSchemaVersion(ctx, migration.Version); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// ValidateTable checks the key schema and indexes every migration expects
+// and returns the problems found
+func (s *DynamoDBNotificationStore) ValidateTable(ctx context.Context) ([]string, error) {
+	table, err := s.describeTable(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe table %s: %v", s.tableName, err)
+	}
+
+	var problems []string
+	if got := formatKeySchema(table.KeySchema); !slices.Equal(got, []string{"id (HASH)"}) {
+		problems = append(problems, fmt.Sprintf("key schema is %s, expected id (HASH)", strings.Join(got, ", ")))
+	}
+
+	indexes := make(map[string]types.GlobalSecondaryIndexDescription, len(table.GlobalSecondaryIndexes))
+	for _, index := range table.GlobalSecondaryIndexes {
+		indexes[aws.ToString(index.IndexName)] = index
+	}
+
+	for _, migration := range tableMigrations {
+		spec := migration.AddIndex
+		index, ok := indexes[spec.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("index %s is missing (migration %d)", spec.Name, migration.Version))
+			continue
+		}
+		if got, want := formatKeySchema(index.KeySchema), formatKeySchema(spec.keySchema()); !slices.Equal(got, want) {
+			problems = append(problems, fmt.Sprintf("index %s key schema is %s, expected %s", spec.Name, strings.Join(got, ", "), strings.Join(want, ", ")))
+		}
+		if index.IndexStatus != types.IndexStatusActive {
+			problems = append(problems, fmt.Sprintf("index %s is %s", spec.Name, index.IndexStatus))
+		}
+	}
+
+	return problems, nil
+}
+
+// SchemaVersion returns the latest applied migration version, 0 if none
+func (s *DynamoDBNotificationStore) SchemaVersion(ctx context.Context) (int, error) {
+	start := time.Now()
+	resp, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: tableSchemaItemID},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	observeDynamoDB("GetItem", start, err)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	value, ok := resp.Item["schema_version"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value.Value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %v", value.Value, err)
+	}
+	return version, nil
+}
+
+// setSchemaVersion records the latest applied migration version
+func (s *DynamoDBNotificationStore) setSchemaVersion(ctx context.Context, version int) error {
+	start := time.Now()
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]types.AttributeValue{
+			"id":             &types.AttributeValueMemberS{Value: tableSchemaItemID},
+			"schema_version": &types.AttributeValueMemberN{Value: strconv.Itoa(version)},
+			"migrated_at":    &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	observeDynamoDB("PutItem", start, err)
+	if err != nil {
+		return fmt.Errorf("failed to record schema version %d: %v", version, err)
+	}
+	return nil
+}
+
+// createTable creates the table with every migration's index and waits
+// until it is active. The schema version is recorded as the latest.
+func (s *DynamoDBNotificationStore) createTable(ctx context.Context) error {
+	attributes := []string{"id"}
+	var indexes []types.GlobalSecondaryIndex
+	for _, migration := range tableMigrations {
+		spec := migration.AddIndex
+		indexes = append(indexes, spec.globalSecondaryIndex())
+		for _, name := range spec.attributes() {
+			if !slices.Contains(attributes, name) {
+				attributes = append(attributes, name)
+			}
+		}
+	}
+
+	var definitions []types.AttributeDefinition
+	for _, name := range attributes {
+		definitions = append(definitions, types.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+	}
+
+	slog.Info("creating notifications table", "table", s.tableName)
+
+	start := time.Now()
+	_, err := s.client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(s.tableName),
+		AttributeDefinitions: definitions,
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("id"), KeyType: types.KeyTypeHash},
+		},
+		GlobalSecondaryIndexes: indexes,
+		BillingMode:            types.BillingModePayPerRequest,
+	})
+	observeDynamoDB("CreateTable", start, err)
+
+	// Another instance may have created it first
+	var inUse *types.ResourceInUseException
+	created := err == nil
+	if err != nil && !errors.As(err, &inUse) {
+		return fmt.Errorf("failed to create table %s: %v", s.tableName, err)
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(s.client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.tableName)}, tableWaitTimeout); err != nil {
+		return fmt.Errorf("table %s did not become active: %v", s.tableName, err)
+	}
+	if err := s.waitForIndexes(ctx); err != nil {
+		return err
+	}
+
+	// A new table already has every index
+	if !created {
+		return nil
+	}
+	return s.setSchemaVersion(ctx, LatestTableVersion())
+}
+
+// addIndex creates an index unless it already exists, then waits for the
+// backfill to finish. DynamoDB creates one index per UpdateTable call.
+func (s *DynamoDBNotificationStore) addIndex(ctx context.Context, spec IndexSpec) error {
+	table, err := s.describeTable(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to describe table %s: %v", s.tableName, err)
+	}
+	for _, index := range table.GlobalSecondaryIndexes {
+		if aws.ToString(index.IndexName) == spec.Name {
+			return s.waitForIndexes(ctx)
+		}
+	}
+
+	var definitions []types.AttributeDefinition
+	for _, name := range spec.attributes() {
+		definitions = append(definitions, types.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+	}
+
+	index := spec.globalSecondaryIndex()
+	update := &types.CreateGlobalSecondaryIndexAction{
+		IndexName:  index.IndexName,
+		KeySchema:  index.KeySchema,
+		Projection: index.Projection,
+	}
+	// Provisioned tables need throughput for the index too
+	if table.BillingModeSummary == nil || table.BillingModeSummary.BillingMode != types.BillingModePayPerRequest {
+		if throughput := table.ProvisionedThroughput; throughput != nil {
+			update.ProvisionedThroughput = &types.ProvisionedThroughput{
+				ReadCapacityUnits:  throughput.ReadCapacityUnits,
+				WriteCapacityUnits: throughput.WriteCapacityUnits,
+			}
+		}
+	}
+
+	start := time.Now()
+	_, err = s.client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName:            aws.String(s.tableName),
+		AttributeDefinitions: definitions,
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+			{Create: update},
+		},
+	})
+	observeDynamoDB("UpdateTable", start, err)
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %v", spec.Name, err)
+	}
+
+	return s.waitForIndexes(ctx)
+}
+
+// waitForIndexes polls until the table and every index are active
+func (s *DynamoDBNotificationStore) waitForIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, tableWaitTimeout)
+	defer cancel()
+
+	for {
+		table, err := s.describeTable(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to describe table %s: %v", s.tableName, err)
+		}
+
+		active := table.TableStatus == types.TableStatusActive
+		for _, index := range table.GlobalSecondaryIndexes {
+			if index.IndexStatus != types.IndexStatusActive {
+				active = false
+			}
+		}
+		if active {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("indexes of %s did not become active: %v", s.tableName, ctx.Err())
+		case <-time.After(indexPollInterval):
+		}
+	}
+}
+
+// enableTTL turns on TTL for NotificationTTLAttribute and reports whether
+// it had to. TTL can't be switched to another attribute while enabled.
+func (s *DynamoDBNotificationStore) enableTTL(ctx context.Context) (bool, error) {
+	start := time.Now()
+	resp, err := s.client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(s.tableName),
+	})
+	observeDynamoDB("DescribeTimeToLive", start, err)
+	if err != nil {
+		return false, fmt.Errorf("failed to describe TTL of %s: %v", s.tableName, err)
+	}
+
+	if desc := resp.TimeToLiveDescription; desc != nil {
+		switch desc.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if name := aws.ToString(desc.AttributeName); name != NotificationTTLAttribute {
+				return false, fmt.Errorf("TTL of %s uses %s, expected %s", s.tableName, name, NotificationTTLAttribute)
+			}
+			return false, nil
+		}
+	}
+
+	start = time.Now()
+	_, err = s.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(s.tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(NotificationTTLAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	observeDynamoDB("UpdateTimeToLive", start, err)
+	if err != nil {
+		return false, fmt.Errorf("failed to enable TTL on %s: %v", s.tableName, err)
+	}
+	return true, nil
+}
+
+// describeTable returns the table description
+func (s *DynamoDBNotificationStore) describeTable(ctx context.Context) (*types.TableDescription, error) {
+	start := time.Now()
+	resp, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.tableName),
+	})
+	observeDynamoDB("DescribeTable", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Table, nil
+}
+
+// attributes returns the index's key attributes
+func (spec IndexSpec) attributes() []string {
+	if spec.SortKey == "" {
+		return []string{spec.PartitionKey}
+	}
+	return []string{spec.PartitionKey, spec.SortKey}
+}
+
+// keySchema returns the index's key schema
+func (spec IndexSpec) keySchema() []types.KeySchemaElement {
+	keys := []types.KeySchemaElement{
+		{AttributeName: aws.String(spec.PartitionKey), KeyType: types.KeyTypeHash},
+	}
+	if spec.SortKey != "" {
+		keys = append(keys, types.KeySchemaElement{AttributeName: aws.String(spec.SortKey), KeyType: types.KeyTypeRange})
+	}
+	return keys
+}
+
+// globalSecondaryIndex returns the index definition used by CreateTable
+func (spec IndexSpec) globalSecondaryIndex() types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName:  aws.String(spec.Name),
+		KeySchema:  spec.keySchema(),
+		Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+	}
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// testDynamoDBStore returns a notification store talking to a stand-in
+// DynamoDB endpoint
+func testDynamoDBStore(endpoint string) *DynamoDBNotificationStore {
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RetryMaxAttempts: 1,
+	})
+	return &DynamoDBNotificationStore{client: client, tableName: "notifications-test"}
+}
+
+// fakeKey is a key schema element in the DynamoDB JSON protocol
+type fakeKey struct {
+	AttributeName string
+	KeyType       string
+}
+
+// fakeIndex is a global secondary index of a fakeTable
+type fakeIndex struct {
+	IndexName   string
+	KeySchema   []fakeKey
+	IndexStatus string
+}
+
+// fakeTable answers the table management calls EnsureTable and
+// ValidateTable make, keeping the table in memory
+type fakeTable struct {
+	mu         sync.Mutex
+	exists     bool
+	keySchema  []fakeKey
+	indexes    []fakeIndex
+	version    int    // Recorded schema version, 0 for none
+	ttl        string // TTL attribute, empty when disabled
+	operations []string
+}
+
+// newFakeTable serves a fakeTable and returns it with a store using it
+func newFakeTable(t *testing.T, table *fakeTable) *DynamoDBNotificationStore {
+	t.Helper()
+
+	previous := indexPollInterval
+	indexPollInterval = time.Millisecond
+	t.Cleanup(func() { indexPollInterval = previous })
+
+	server := httptest.NewServer(table)
+	t.Cleanup(server.Close)
+	return testDynamoDBStore(server.URL)
+}
+
+// existingTable returns a table keyed on id with the given indexes active
+func existingTable(version int, indexes ...IndexSpec) *fakeTable {
+	table := &fakeTable{exists: true, keySchema: []fakeKey{{"id", "HASH"}}, version: version, ttl: NotificationTTLAttribute}
+	for _, spec := range indexes {
+		table.indexes = append(table.indexes, fakeIndexFor(spec, "ACTIVE"))
+	}
+	return table
+}
+
+// fakeIndexFor describes an index as DynamoDB would
+func fakeIndexFor(spec IndexSpec, status string) fakeIndex {
+	keys := []fakeKey{{spec.PartitionKey, "HASH"}}
+	if spec.SortKey != "" {
+		keys = append(keys, fakeKey{spec.SortKey, "RANGE"})
+	}
+	return fakeIndex{IndexName: spec.Name, KeySchema: keys, IndexStatus: status}
+}
+
+func (f *fakeTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+	var req struct {
+		KeySchema                   []fakeKey
+		GlobalSecondaryIndexes      []fakeIndex
+		GlobalSecondaryIndexUpdates []struct{ Create *fakeIndex }
+		Item                        map[string]map[string]string
+		TimeToLiveSpecification     struct{ AttributeName string }
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.operations = append(f.operations, operation)
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if !f.exists && operation != "CreateTable" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException","message":"Requested resource not found"}`)
+		return
+	}
+
+	var resp any
+	switch operation {
+	case "CreateTable":
+		f.exists = true
+		f.keySchema = req.KeySchema
+		for _, index := range req.GlobalSecondaryIndexes {
+			index.IndexStatus = "ACTIVE"
+			f.indexes = append(f.indexes, index)
+		}
+		resp = map[string]any{"TableDescription": f.description()}
+	case "DescribeTable":
+		resp = map[string]any{"Table": f.description()}
+	case "UpdateTable":
+		for _, update := range req.GlobalSecondaryIndexUpdates {
+			index := *update.Create
+			index.IndexStatus = "ACTIVE"
+			f.indexes = append(f.indexes, index)
+		}
+		resp = map[string]any{"TableDescription": f.description()}
+	case "GetItem":
+		resp = map[string]any{}
+		if f.version > 0 {
+			resp = map[string]any{"Item": map[string]any{
+				"id":             map[string]string{"S": tableSchemaItemID},
+				"schema_version": map[string]string{"N": fmt.Sprint(f.version)},
+			}}
+		}
+	case "PutItem":
+		fmt.Sscan(req.Item["schema_version"]["N"], &f.version)
+		resp = map[string]any{}
+	case "DescribeTimeToLive":
+		description := map[string]any{"TimeToLiveStatus": "DISABLED"}
+		if f.ttl != "" {
+			description = map[string]any{"TimeToLiveStatus": "ENABLED", "AttributeName": f.ttl}
+		}
+		resp = map[string]any{"TimeToLiveDescription": description}
+	case "UpdateTimeToLive":
+		f.ttl = req.TimeToLiveSpecification.AttributeName
+		resp = map[string]any{"TimeToLiveSpecification": map[string]any{"AttributeName": f.ttl, "Enabled": true}}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"__type":"com.amazon.coral.validate#ValidationException","message":"unexpected %s"}`, operation)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// description returns the table as DescribeTable reports it
+func (f *fakeTable) description() map[string]any {
+	return map[string]any{
+		"TableName":              "notifications-test",
+		"TableStatus":            "ACTIVE",
+		"KeySchema":              f.keySchema,
+		"GlobalSecondaryIndexes": f.indexes,
+		"BillingModeSummary":     map[string]string{"BillingMode": "PAY_PER_REQUEST"},
+	}
+}
+
+// indexNames returns the table's index names
+func (f *fakeTable) indexNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, index := range f.indexes {
+		names = append(names, index.IndexName)
+	}
+	return names
+}
+
+func TestTableMigrationsAreVersionedInOrder(t *testing.T) {
+	migrations := TableMigrations()
+	names := make(map[string]bool)
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, migration.Version, i+1)
+		}
+		if names[migration.AddIndex.Name] {
+			t.Errorf("index %s is added twice", migration.AddIndex.Name)
+		}
+		names[migration.AddIndex.Name] = true
+	}
+	if LatestTableVersion() != migrations[len(migrations)-1].Version {
+		t.Errorf("LatestTableVersion() = %d, want the last migration's", LatestTableVersion())
+	}
+
+	// Callers get a copy
+	migrations[0].Version = 99
+	if TableMigrations()[0].Version != 1 {
+		t.Error("TableMigrations() returned the registry itself")
+	}
+}
+
+func TestEnsureTableCreatesMissingTable(t *testing.T) {
+	table := &fakeTable{}
+	store := newFakeTable(t, table)
+	ctx := context.Background()
+
+	steps, err := store.EnsureTable(ctx)
+	if err != nil {
+		t.Fatalf("EnsureTable: %v", err)
+	}
+	want := []string{"created table notifications-test", "enabled TTL on expires_at"}
+	if !slices.Equal(steps, want) {
+		t.Errorf("steps = %q, want %q", steps, want)
+	}
+	if table.version != LatestTableVersion() {
+		t.Errorf("schema version = %d, want %d", table.version, LatestTableVersion())
+	}
+	if names := table.indexNames(); len(names) != len(TableMigrations()) {
+		t.Errorf("indexes = %v, want one per migration", names)
+	}
+
+	problems, err := store.ValidateTable(ctx)
+	if err != nil || len(problems) != 0 {
+		t.Errorf("ValidateTable = %v, %v, want no problems", problems, err)
+	}
+
+	// Running again changes nothing
+	steps, err = store.EnsureTable(ctx)
+	if err != nil || len(steps) != 0 {
+		t.Errorf("second EnsureTable = %q, %v, want no steps", steps, err)
+	}
+}
+
+func TestEnsureTableAppliesPendingMigrations(t *testing.T) {
+	migrations := TableMigrations()
+	table := existingTable(1, migrations[0].AddIndex)
+	store := newFakeTable(t, table)
+	ctx := context.Background()
+
+	pending, err := store.Migrate(ctx, true)
+	if err != nil || len(pending) != len(migrations)-1 {
+		t.Fatalf("dry run = %d migrations, %v, want %d", len(pending), err, len(migrations)-1)
+	}
+	if table.version != 1 || len(table.indexNames()) != 1 {
+		t.Fatal("dry run changed the table")
+	}
+
+	steps, err := store.EnsureTable(ctx)
+	if err != nil {
+		t.Fatalf("EnsureTable: %v", err)
+	}
+	for _, migration := range migrations[1:] {
+		step := fmt.Sprintf("applied migration %d: %s", migration.Version, migration.Description)
+		if !slices.Contains(steps, step) {
+			t.Errorf("steps = %q, want %q", steps, step)
+		}
+	}
+	want := []string{"OwnerIndex", "ResourceIndex", "SourceIndex", "TriggerUserIndex"}
+	if names := table.indexNames(); !slices.Equal(names, want) {
+		t.Errorf("indexes = %v, want %v", names, want)
+	}
+	if table.version != LatestTableVersion() {
+		t.Errorf("schema version = %d, want %d", table.version, LatestTableVersion())
+	}
+}
+
+func TestMigrateSkipsIndexesThatExist(t *testing.T) {
+	// An earlier run created the index but died before recording the version
+	migrations := TableMigrations()
+	table := existingTable(1, migrations[0].AddIndex, migrations[1].AddIndex)
+	store := newFakeTable(t, table)
+
+	if _, err := store.Migrate(context.Background(), false); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if names := table.indexNames(); len(names) != len(migrations) {
+		t.Errorf("indexes = %v, want each once", names)
+	}
+	if n := strings.Count(strings.Join(table.operations, ","), "UpdateTable"); n != len(migrations)-2 {
+		t.Errorf("%d UpdateTable calls, want %d", n, len(migrations)-2)
+	}
+}
+
+func TestValidateTableReportsSchemaProblems(t *testing.T) {
+	table := existingTable(LatestTableVersion(),
+		IndexSpec{Name: "OwnerIndex", PartitionKey: "owner", SortKey: "created_at"},
+		IndexSpec{Name: "ResourceIndex", PartitionKey: "resource_id"},
+	)
+	table.keySchema = []fakeKey{{"owner", "HASH"}, {"id", "RANGE"}}
+	table.indexes = append(table.indexes, fakeIndexFor(IndexSpec{Name: "TriggerUserIndex", PartitionKey: "userid", SortKey: "created_at"}, "CREATING"))
+	store := newFakeTable(t, table)
+
+	problems, err := store.ValidateTable(context.Background())
+	if err != nil {
+		t.Fatalf("ValidateTable: %v", err)
+	}
+	want := []string{
+		"key schema is owner (HASH), id (RANGE), expected id (HASH)",
+		"index ResourceIndex key schema is resource_id (HASH), expected resource_id (HASH), created_at (RANGE)",
+		"index SourceIndex is missing (migration 3)",
+		"index TriggerUserIndex is CREATING",
+	}
+	if !slices.Equal(problems, want) {
+		t.Errorf("problems =\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestEnsureTableRejectsTTLOnAnotherAttribute(t *testing.T) {
+	table := existingTable(LatestTableVersion())
+	table.ttl = "ttl"
+	store := newFakeTable(t, table)
+
+	_, err := store.EnsureTable(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "uses ttl") {
+		t.Errorf("EnsureTable = %v, want an error naming the other TTL attribute", err)
+	}
+}
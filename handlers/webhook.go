@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// Webhook request headers
+const (
+	WebhookSignatureHeader = "X-Exobook-Signature" // sha256=<hex HMAC of "{timestamp}.{body}">
+	WebhookTimestampHeader = "X-Exobook-Timestamp" // Unix seconds, part of the signed string
+	WebhookDeliveryHeader  = "X-Exobook-Delivery"  // Delivery log id, stable across replays
+	WebhookEventHeader     = "X-Exobook-Event"
+)
+
+// NATS subjects for webhook administration (request/reply)
+const (
+	SubjectWebhookRegister   = "webhooks.endpoints.register"
+	SubjectWebhookDeliveries = "webhooks.deliveries.list"
+	SubjectWebhookReplay     = "webhooks.deliveries.replay"
+)
+
+// webhookEventCreated is the event type sent for new notifications
+const webhookEventCreated = "notification.created"
+
+// WebhookDeliverer sends signed HTTP callbacks for created notifications
+type WebhookDeliverer struct {
+	store            WebhookStore
+	httpClient       *http.Client
+	maxAttempts      int
+	backoff          time.Duration // Doubled after every failed attempt
+	disableThreshold int           // Consecutive failed deliveries before disabling
+
+	mu sync.Mutex // Serializes endpoint failure counter updates
+}
+
+// NewWebhookDeliverer creates a new webhook deliverer
+func NewWebhookDeliverer(store WebhookStore, maxAttempts int, backoff time.Duration, disableThreshold int) *WebhookDeliverer {
+	return &WebhookDeliverer{
+		store:            store,
+		httpClient:       &http.Client{Timeout: 10 * time.Second},
+		maxAttempts:      maxAttempts,
+		backoff:          backoff,
+		disableThreshold: disableThreshold,
+	}
+}
+
+// Name returns the deliverer name
+func (d *WebhookDeliverer) Name() string {
+	return "webhook"
+}
+
+// Deliver sends the notification to every enabled endpoint interested in it
+func (d *WebhookDeliverer) Deliver(notif models.Notification) error {
+	endpoints, err := d.store.ListWebhookEndpoints()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, endpoint := range endpoints {
+		if endpoint.Disabled || !endpoint.Accepts(notif.Action) {
+			continue
+		}
+
+		deliveryID := uuid.New().String()
+		payload, err := json.Marshal(map[string]interface{}{
+			"id":         deliveryID,
+			"type":       webhookEventCreated,
+			"created_at": time.Now().UTC(),
+			"data":       notificationPayload(notif),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal webhook payload: %v", err)
+		}
+
+		delivery := models.WebhookDelivery{
+			Id:             deliveryID,
+			EndpointId:     endpoint.Id,
+			NotificationId: notif.Id,
+			Owner:          notif.Owner,
+			UserId:         notif.UserId,
+			Payload:        string(payload),
+			CreatedAt:      time.Now(),
+		}
+
+		wg.Add(1)
+		go func(endpoint models.WebhookEndpoint) {
+			defer wg.Done()
+			d.deliver(endpoint, delivery)
+		}(endpoint)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// Replay re-sends a logged delivery to its endpoint, even if it had succeeded
+func (d *WebhookDeliverer) Replay(deliveryID string) (*models.WebhookDelivery, error) {
+	delivery, err := d.store.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := d.store.GetWebhookEndpoint(delivery.EndpointId)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("replaying webhook delivery", "delivery_id", delivery.Id, "endpoint", endpoint.URL)
+
+	result := d.deliver(*endpoint, *delivery)
+	return &result, nil
+}
+
+// deliver attempts a delivery with retries, logs it and updates the endpoint health
+func (d *WebhookDeliverer) deliver(endpoint models.WebhookEndpoint, delivery models.WebhookDelivery) models.WebhookDelivery {
+	delivery.Attempts = 0
+	delivery.Error = ""
+	wait := d.backoff
+
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		delivery.Attempts = attempt
+		delivery.LastAttemptAt = time.Now()
+
+		status, retryable, err := d.post(endpoint, delivery)
+		delivery.ResponseCode = status
+
+		if err == nil {
+			delivery.Status = models.WebhookDeliverySucceeded
+			delivery.Error = ""
+			break
+		}
+
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.Error = err.Error()
+
+		if !retryable || attempt == d.maxAttempts {
+			break
+		}
+
+		time.Sleep(wait)
+		wait *= 2
+	}
+
+	if err := d.store.RecordWebhookDelivery(delivery); err != nil {
+		slog.Error("failed to record webhook delivery", "delivery_id", delivery.Id, "error", err)
+	}
+
+	d.updateEndpointHealth(endpoint.Id, delivery.Status == models.WebhookDeliverySucceeded)
+
+	if delivery.Status == models.WebhookDeliverySucceeded {
+		slog.Info("webhook delivered", "endpoint", endpoint.URL, "delivery_id", delivery.Id, "attempts", delivery.Attempts)
+	} else {
+		slog.Error("webhook delivery failed", "endpoint", endpoint.URL, "delivery_id", delivery.Id, "attempts", delivery.Attempts, "error", delivery.Error)
+	}
+
+	return delivery
+}
+
+// post sends one signed request and reports whether a failure is worth retrying
+func (d *WebhookDeliverer) post(endpoint models.WebhookEndpoint, delivery models.WebhookDelivery) (int, bool, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to build webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "exobook-notification-worker")
+	req.Header.Set(WebhookEventHeader, webhookEventCreated)
+	req.Header.Set(WebhookDeliveryHeader, delivery.Id)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(endpoint.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, true, fmt.Errorf("webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+
+	// Client errors won't fix themselves, except timeouts and rate limits
+	retryable := resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+
+	return resp.StatusCode, retryable, err
+}
+
+// updateEndpointHealth tracks consecutive failures and disables broken endpoints
+func (d *WebhookDeliverer) updateEndpointHealth(endpointID string, succeeded bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	endpoint, err := d.store.GetWebhookEndpoint(endpointID)
+	if err != nil {
+		slog.Error("failed to load webhook endpoint", "endpoint_id", endpointID, "error", err)
+		return
+	}
+
+	if succeeded {
+		if endpoint.ConsecutiveFailures == 0 {
+			return
+		}
+		endpoint.ConsecutiveFailures = 0
+	} else {
+		endpoint.ConsecutiveFailures++
+		if endpoint.ConsecutiveFailures >= d.disableThreshold && !endpoint.Disabled {
+			endpoint.Disabled = true
+			slog.Warn("disabling webhook endpoint", "endpoint", endpoint.URL, "consecutive_failures", endpoint.ConsecutiveFailures)
+		}
+	}
+
+	if err := d.store.SaveWebhookEndpoint(*endpoint); err != nil {
+		slog.Error("failed to save webhook endpoint", "endpoint_id", endpointID, "error", err)
+	}
+}
+
+// SignWebhook computes the signature header value for a payload.
+// Receivers should recompute it and reject stale timestamps.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SubscribeWebhookAdmin serves endpoint registration, delivery log queries
+// and replays over NATS request/reply
+func SubscribeWebhookAdmin(nc *nats.Conn, deliverer *WebhookDeliverer, store WebhookStore) ([]*nats.Subscription, error) {
+	handlers := map[string]func(data []byte) (interface{}, error){
+		SubjectWebhookRegister: func(data []byte) (interface{}, error) {
+			var req struct {
+				models.WebhookEndpoint
+				Secret string `json:"secret"`
+			}
+			if err := json.Unmarshal(data, &req); err != nil {
+				return nil, err
+			}
+
+			endpoint := req.WebhookEndpoint
+			endpoint.Secret = req.Secret
+			if endpoint.URL == "" || endpoint.Secret == "" {
+				return nil, fmt.Errorf("url and secret are required")
+			}
+			if endpoint.Id == "" {
+				endpoint.Id = uuid.New().String()
+			}
+			if endpoint.CreatedAt.IsZero() {
+				endpoint.CreatedAt = time.Now()
+			}
+
+			// Re-registering an endpoint re-enables it
+			endpoint.Disabled = false
+			endpoint.ConsecutiveFailures = 0
+
+			if err := store.SaveWebhookEndpoint(endpoint); err != nil {
+				return nil, err
+			}
+			return endpoint, nil
+		},
+		SubjectWebhookDeliveries: func(data []byte) (interface{}, error) {
+			var req struct {
+				EndpointId string `json:"endpoint_id"`
+				Limit      int32  `json:"limit"`
+			}
+			if err := json.Unmarshal(data, &req); err != nil {
+				return nil, err
+			}
+			if req.Limit <= 0 {
+				req.Limit = 50
+			}
+			return store.ListWebhookDeliveries(req.EndpointId, req.Limit)
+		},
+		SubjectWebhookReplay: func(data []byte) (interface{}, error) {
+			var req struct {
+				Id string `json:"id"`
+			}
+			if err := json.Unmarshal(data, &req); err != nil {
+				return nil, err
+			}
+			return deliverer.Replay(req.Id)
+		},
+	}
+
+	var subs []*nats.Subscription
+	for subject, handle := range handlers {
+		handle := handle
+		sub, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+			result, err := handle(msg.Data)
+			respondJSON(msg, result, err)
+		})
+		if err != nil {
+			for _, s := range subs {
+				s.Unsubscribe()
+			}
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, nil
+}
+
+// respondJSON replies to a NATS request with a result or an error
+func respondJSON(msg *nats.Msg, result interface{}, err error) {
+	if msg.Reply == "" {
+		return
+	}
+
+	var reply []byte
+	if err != nil {
+		reply, _ = json.Marshal(map[string]string{"error": err.Error()})
+	} else {
+		reply, _ = json.Marshal(result)
+	}
+
+	if err := msg.Respond(reply); err != nil {
+		slog.Error("failed to respond", "subject", msg.Subject, "error", err)
+	}
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// WebhookStore holds webhook endpoints and their delivery log
+type WebhookStore interface {
+	ListWebhookEndpoints() ([]models.WebhookEndpoint, error)
+	GetWebhookEndpoint(id string) (*models.WebhookEndpoint, error)
+	SaveWebhookEndpoint(endpoint models.WebhookEndpoint) error
+	RecordWebhookDelivery(delivery models.WebhookDelivery) error
+	GetWebhookDelivery(id string) (*models.WebhookDelivery, error)
+	ListWebhookDeliveries(endpointID string, limit int32) ([]models.WebhookDelivery, error)
+	WebhookDeliveryStore
+}
+
+// ParseWebhookEndpoints parses endpoints declared in configuration.
+// Format: "url|secret,url|secret". Ids are derived from the URL so they
+// stay stable across restarts.
+func ParseWebhookEndpoints(spec string) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		url, secret, ok := strings.Cut(entry, "|")
+		if !ok || url == "" || secret == "" {
+			return nil, fmt.Errorf("invalid webhook endpoint %q: expected url|secret", entry)
+		}
+
+		sum := sha256.Sum256([]byte(url))
+		endpoints = append(endpoints, models.WebhookEndpoint{
+			Id:        "cfg-" + hex.EncodeToString(sum[:6]),
+			URL:       url,
+			Secret:    secret,
+			CreatedAt: time.Now(),
+		})
+	}
+
+	return endpoints, nil
+}
+
+// MemoryWebhookStore keeps endpoints and a bounded delivery log in memory.
+// Used for endpoints declared in configuration.
+type MemoryWebhookStore struct {
+	mu         sync.Mutex
+	endpoints  map[string]models.WebhookEndpoint
+	deliveries []models.WebhookDelivery
+	maxLog     int
+}
+
+// NewMemoryWebhookStore creates a store seeded with the given endpoints
+func NewMemoryWebhookStore(endpoints []models.WebhookEndpoint, maxLog int) *MemoryWebhookStore {
+	store := &MemoryWebhookStore{
+		endpoints: make(map[string]models.WebhookEndpoint, len(endpoints)),
+		maxLog:    maxLog,
+	}
+	for _, endpoint := range endpoints {
+		store.endpoints[endpoint.Id] = endpoint
+	}
+	return store
+}
+
+// ListWebhookEndpoints returns every endpoint, sorted by id
+func (s *MemoryWebhookStore) ListWebhookEndpoints() ([]models.WebhookEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := make([]models.WebhookEndpoint, 0, len(s.endpoints))
+	for _, endpoint := range s.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Id < endpoints[j].Id })
+
+	return endpoints, nil
+}
+
+// GetWebhookEndpoint returns an endpoint by id
+func (s *MemoryWebhookStore) GetWebhookEndpoint(id string) (*models.WebhookEndpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoint, ok := s.endpoints[id]
+	if !ok {
+		return nil, fmt.Errorf("webhook endpoint %s not found", id)
+	}
+	return &endpoint, nil
+}
+
+// SaveWebhookEndpoint creates or replaces an endpoint
+func (s *MemoryWebhookStore) SaveWebhookEndpoint(endpoint models.WebhookEndpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.endpoints[endpoint.Id] = endpoint
+	return nil
+}
+
+// RecordWebhookDelivery appends to the log, dropping the oldest entries
+func (s *MemoryWebhookStore) RecordWebhookDelivery(delivery models.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replays reuse the delivery id - update in place
+	for i := range s.deliveries {
+		if s.deliveries[i].Id == delivery.Id {
+			s.deliveries[i] = delivery
+			return nil
+		}
+	}
+
+	s.deliveries = append(s.deliveries, delivery)
+	if len(s.deliveries) > s.maxLog {
+		s.deliveries = s.deliveries[len(s.deliveries)-s.maxLog:]
+	}
+	return nil
+}
+
+// GetWebhookDelivery returns a delivery by id
+func (s *MemoryWebhookStore) GetWebhookDelivery(id string) (*models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delivery := range s.deliveries {
+		if delivery.Id == id {
+			return &delivery, nil
+		}
+	}
+	return nil, fmt.Errorf("webhook delivery %s not found", id)
+}
+
+// ListWebhookDeliveries returns the latest deliveries, optionally for one endpoint
+func (s *MemoryWebhookStore) ListWebhookDeliveries(endpointID string, limit int32) ([]models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []models.WebhookDelivery
+	for i := len(s.deliveries) - 1; i >= 0 && int32(len(deliveries)) < limit; i-- {
+		if endpointID == "" || s.deliveries[i].EndpointId == endpointID {
+			deliveries = append(deliveries, s.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// ListWebhookDeliveriesByUser pages through a user's deliveries in id
+// order. The cursor is the last id returned.
+func (s *MemoryWebhookStore) ListWebhookDeliveriesByUser(userID, cursor string, limit int32) ([]models.WebhookDelivery, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		if delivery.Id > cursor && deliveryInvolves(delivery, userID) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Id < deliveries[j].Id })
+
+	if limit <= 0 || len(deliveries) <= int(limit) {
+		return deliveries, "", nil
+	}
+	deliveries = deliveries[:limit]
+	return deliveries, deliveries[limit-1].Id, nil
+}
+
+// DeleteWebhookDelivery removes a delivery from the log
+func (s *MemoryWebhookStore) DeleteWebhookDelivery(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.deliveries {
+		if s.deliveries[i].Id == id {
+			s.deliveries = append(s.deliveries[:i], s.deliveries[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// deliveryInvolves reports whether a delivery is of a notification the
+// user received or triggered. Deliveries logged before owner and userid
+// were recorded only carry the trigger user, in the payload.
+func deliveryInvolves(delivery models.WebhookDelivery, userID string) bool {
+	if delivery.Owner == userID || delivery.UserId == userID {
+		return true
+	}
+
+	var payload struct {
+		Data struct {
+			UserID string `json:"user_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(delivery.Payload), &payload); err != nil {
+		return false
+	}
+	return payload.Data.UserID == userID
+}
+
+// WebhookService stores webhook endpoints and deliveries in DynamoDB
+// Endpoints table key: id. Deliveries table key: id, with an
+// EndpointIndex GSI on endpoint_id + created_at.
+type WebhookService struct {
+	client          *dynamodb.Client
+	endpointsTable  string
+	deliveriesTable string
+}
+
+// NewWebhookService creates a new webhook service
+func NewWebhookService(region, endpointsTable, deliveriesTable string) (*WebhookService, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config: %v", err)
+	}
+
+	return &WebhookService{
+		client:          dynamodb.NewFromConfig(cfg),
+		endpointsTable:  endpointsTable,
+		deliveriesTable: deliveriesTable,
+	}, nil
+}
+
+// ListWebhookEndpoints returns every registered endpoint
+func (s *WebhookService) ListWebhookEndpoints() ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{
+		TableName: aws.String(s.endpointsTable),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook endpoints: %v", err)
+		}
+
+		var batch []models.WebhookEndpoint
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal webhook endpoints: %v", err)
+		}
+		endpoints = append(endpoints, batch...)
+	}
+
+	return endpoints, nil
+}
+
+// GetWebhookEndpoint returns an endpoint by id
+func (s *WebhookService) GetWebhookEndpoint(id string) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := s.getItem(s.endpointsTable, id, &endpoint); err != nil {
+		return nil, fmt.Errorf("failed to get webhook endpoint: %v", err)
+	}
+	return &endpoint, nil
+}
+
+// SaveWebhookEndpoint creates or replaces an endpoint
+func (s *WebhookService) SaveWebhookEndpoint(endpoint models.WebhookEndpoint) error {
+	if err := s.putItem(s.endpointsTable, endpoint); err != nil {
+		return fmt.Errorf("failed to save webhook endpoint: %v", err)
+	}
+	return nil
+}
+
+// RecordWebhookDelivery writes a delivery log entry
+func (s *WebhookService) RecordWebhookDelivery(delivery models.WebhookDelivery) error {
+	if err := s.putItem(s.deliveriesTable, delivery); err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// GetWebhookDelivery returns a delivery by id
+func (s *WebhookService) GetWebhookDelivery(id string) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := s.getItem(s.deliveriesTable, id, &delivery); err != nil {
+		return nil, fmt.Errorf("failed to get webhook delivery: %v", err)
+	}
+	return &delivery, nil
+}
+
+// ListWebhookDeliveries returns the latest deliveries for an endpoint
+func (s *WebhookService) ListWebhookDeliveries(endpointID string, limit int32) ([]models.WebhookDelivery, error) {
+	if endpointID == "" {
+		return nil, fmt.Errorf("endpoint id is required")
+	}
+
+	resp, err := s.client.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(s.deliveriesTable),
+		IndexName:              aws.String("EndpointIndex"),
+		KeyConditionExpression: aws.String("endpoint_id = :endpoint_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":endpoint_id": &types.AttributeValueMemberS{Value: endpointID},
+		},
+		ScanIndexForward: aws.Bool(false), // Latest first
+		Limit:            aws.Int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %v", err)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, &deliveries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook deliveries: %v", err)
+	}
+
+	return deliveries, nil
+}
+
+// ListWebhookDeliveriesByUser scans the delivery log one page at a time.
+// The table has no index on users; privacy purges and exports are rare
+// enough for a filtered scan. The cursor is the page's LastEvaluatedKey,
+// encoded.
+func (s *WebhookService) ListWebhookDeliveriesByUser(userID, cursor string, limit int32) ([]models.WebhookDelivery, string, error) {
+	startKey, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := time.Now()
+	resp, err := s.client.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName: aws.String(s.deliveriesTable),
+		// The payload match catches deliveries logged without owner and userid
+		FilterExpression: aws.String("#owner = :user OR userid = :user OR contains(payload, :user)"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner", // 'owner' is a reserved keyword
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user": &types.AttributeValueMemberS{Value: userID},
+		},
+		ExclusiveStartKey: startKey,
+		Limit:             aws.Int32(limit),
+	})
+	observeDynamoDB("Scan", start, err)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan webhook deliveries: %v", err)
+	}
+
+	var scanned []models.WebhookDelivery
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, &scanned); err != nil {
+		return nil, "", fmt.Errorf("failed to unmarshal webhook deliveries: %v", err)
+	}
+
+	// contains() matches substrings; keep the user's own deliveries
+	deliveries := scanned[:0]
+	for _, delivery := range scanned {
+		if deliveryInvolves(delivery, userID) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	next, err := encodeCursor(resp.LastEvaluatedKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return deliveries, next, nil
+}
+
+// DeleteWebhookDelivery removes a delivery from the log
+func (s *WebhookService) DeleteWebhookDelivery(id string) error {
+	_, err := s.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.deliveriesTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// putItem marshals and stores an item
+func (s *WebhookService) putItem(table string, v interface{}) error {
+	item, err := attributevalue.MarshalMap(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      item,
+	})
+	return err
+}
+
+// getItem loads an item by id
+func (s *WebhookService) getItem(table, id string, v interface{}) error {
+	resp, err := s.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.Item == nil {
+		return fmt.Errorf("%s not found", id)
+	}
+
+	return attributevalue.UnmarshalMap(resp.Item, v)
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// webPushTTL is how long the push service keeps an undelivered message
+	webPushTTL = 24 * time.Hour
+
+	// webPushRecordSize is the aes128gcm record size (RFC 8188)
+	webPushRecordSize = 4096
+
+	// vapidTokenLifetime is how long a VAPID JWT is valid (max 24h, RFC 8292)
+	vapidTokenLifetime = 12 * time.Hour
+)
+
+// VAPIDKeys identifies this application server to push services (RFC 8292)
+type VAPIDKeys struct {
+	Subject    string // mailto: or https: contact for the push service operator
+	PublicKey  string // Uncompressed P-256 point, base64url
+	privateKey *ecdsa.PrivateKey
+}
+
+// NewVAPIDKeys parses a base64url encoded VAPID key pair
+func NewVAPIDKeys(subject, publicKey, privateKey string) (*VAPIDKeys, error) {
+	if subject == "" {
+		return nil, fmt.Errorf("vapid subject is required")
+	}
+
+	raw, err := decodeBase64URL(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vapid private key: %v", err)
+	}
+
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vapid private key: %v", err)
+	}
+
+	pub := key.PublicKey().Bytes()
+	if publicKey != "" && publicKey != base64.RawURLEncoding.EncodeToString(pub) {
+		return nil, fmt.Errorf("vapid public key does not match private key")
+	}
+
+	return &VAPIDKeys{
+		Subject:   subject,
+		PublicKey: base64.RawURLEncoding.EncodeToString(pub),
+		privateKey: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(pub[1:33]),
+				Y:     new(big.Int).SetBytes(pub[33:65]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+	}, nil
+}
+
+// authorization builds the VAPID Authorization header for a push endpoint
+func (k *VAPIDKeys) authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid push endpoint: %v", err)
+	}
+
+	token, err := signES256JWT(k.privateKey, map[string]interface{}{}, map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidTokenLifetime).Unix(),
+		"sub": k.Subject,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign vapid token: %v", err)
+	}
+
+	return fmt.Sprintf("vapid t=%s, k=%s", token, k.PublicKey), nil
+}
+
+// encryptWebPush encrypts a payload for a subscription (RFC 8291, aes128gcm)
+func encryptWebPush(sub models.PushSubscription, plaintext []byte) ([]byte, error) {
+	uaPublicRaw, err := decodeBase64URL(sub.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh: %v", err)
+	}
+
+	authSecret, err := decodeBase64URL(sub.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth secret: %v", err)
+	}
+
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh: %v", err)
+	}
+
+	// Fresh application server key pair and salt for every message
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh failed: %v", err)
+	}
+
+	// IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0x00 || ua_public || as_public)
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicRaw...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdfExpand(ecdhSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := hkdfExpand(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := hkdfExpand(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single record: plaintext followed by the last-record delimiter
+	if len(plaintext)+1+gcm.Overhead() > webPushRecordSize {
+		return nil, fmt.Errorf("payload too large for web push (%d bytes)", len(plaintext))
+	}
+	record := append(append([]byte{}, plaintext...), 0x02)
+
+	// Header: salt (16) || record size (4) || key id length (1) || key id
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(webPushRecordSize))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	body.Write(gcm.Seal(nil, nonce, record, nil))
+
+	return body.Bytes(), nil
+}
+
+// hkdfExpand runs HKDF-SHA256 and returns length bytes
+func hkdfExpand(secret, salt, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out); err != nil {
+		return nil, fmt.Errorf("hkdf failed: %v", err)
+	}
+	return out, nil
+}
+
+// decodeBase64URL accepts base64url with or without padding
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// WebPushDeliverer sends notifications to browsers through Web Push
+type WebPushDeliverer struct {
+	subscriptions PushSubscriptionStore
+	keys          *VAPIDKeys
+	httpClient    *http.Client
+	online        OnlineChecker // Optional: skip users connected to Pusher
+}
+
+// NewWebPushDeliverer creates a new web push deliverer.
+// online may be nil to push to every subscription regardless of presence.
+func NewWebPushDeliverer(subscriptions PushSubscriptionStore, keys *VAPIDKeys, online OnlineChecker) *WebPushDeliverer {
+	return &WebPushDeliverer{
+		subscriptions: subscriptions,
+		keys:          keys,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		online:        online,
+	}
+}
+
+// Name returns the deliverer name
+func (d *WebPushDeliverer) Name() string {
+	return "webpush"
+}
+
+// Deliver pushes the notification to every browser the owner subscribed
+func (d *WebPushDeliverer) Deliver(notif models.Notification) error {
+	if !models.DefaultPreferenceFor(notif.Action).Push {
+		return nil
+	}
+
+	if d.online != nil && d.online.IsOwnerOnline(notif.Owner) {
+		return nil
+	}
+
+	subs, err := d.subscriptions.ListPushSubscriptions(notif.Owner)
+	if err != nil {
+		return err
+	}
+
+	if len(subs) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(notificationPayload(notif))
+	if err != nil {
+		return fmt.Errorf("failed to marshal push payload: %v", err)
+	}
+
+	var failed int
+	for _, sub := range subs {
+		if err := d.send(sub, payload); err != nil {
+			slog.Error("web push failed", "owner", sub.Owner, "endpoint", sub.Endpoint, "error", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d web pushes failed", failed, len(subs))
+	}
+
+	slog.Debug("web push sent", "owner", notif.Owner, "action_key", notif.ActionKey, "browsers", len(subs))
+	return nil
+}
+
+// send encrypts and posts a payload to a single subscription
+func (d *WebPushDeliverer) send(sub models.PushSubscription, payload []byte) error {
+	body, err := encryptWebPush(sub, payload)
+	if err != nil {
+		return err
+	}
+
+	auth, err := d.keys.authorization(sub.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build push request: %v", err)
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(webPushTTL.Seconds())))
+	req.Header.Set("Urgency", "normal")
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("push request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		// The browser unsubscribed or the subscription expired - prune it
+		slog.Info("pruning expired push subscription", "owner", sub.Owner, "status", resp.StatusCode)
+		return d.subscriptions.DeletePushSubscription(sub.Owner, sub.Endpoint)
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("push service returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// memoryPushSubscriptions is a PushSubscriptionStore for tests
+type memoryPushSubscriptions struct {
+	mu   sync.Mutex
+	subs map[string]models.PushSubscription // endpoint -> subscription
+}
+
+func newMemoryPushSubscriptions(subs ...models.PushSubscription) *memoryPushSubscriptions {
+	store := &memoryPushSubscriptions{subs: make(map[string]models.PushSubscription)}
+	for _, sub := range subs {
+		store.subs[sub.Endpoint] = sub
+	}
+	return store
+}
+
+func (s *memoryPushSubscriptions) SavePushSubscription(sub models.PushSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[sub.Endpoint] = sub
+	return nil
+}
+
+func (s *memoryPushSubscriptions) DeletePushSubscription(owner, endpoint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.subs[endpoint]; ok && sub.Owner == owner {
+		delete(s.subs, endpoint)
+	}
+	return nil
+}
+
+func (s *memoryPushSubscriptions) ListPushSubscriptions(owner string) ([]models.PushSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var subs []models.PushSubscription
+	for _, sub := range s.subs {
+		if sub.Owner == owner {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+// testBrowser holds the keys a browser generates when it subscribes
+type testBrowser struct {
+	private    *ecdh.PrivateKey
+	authSecret []byte
+}
+
+func newTestBrowser(t *testing.T) *testBrowser {
+	t.Helper()
+
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate browser key: %v", err)
+	}
+	authSecret := make([]byte, 16)
+	if _, err := rand.Read(authSecret); err != nil {
+		t.Fatalf("generate auth secret: %v", err)
+	}
+	return &testBrowser{private: private, authSecret: authSecret}
+}
+
+// subscription returns the browser's subscription to an endpoint
+func (b *testBrowser) subscription(owner, endpoint string) models.PushSubscription {
+	return models.PushSubscription{
+		Owner:    owner,
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(b.private.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(b.authSecret),
+	}
+}
+
+// decrypt reverses RFC 8291 aes128gcm encryption the way a browser does
+func (b *testBrowser) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+
+	// Header: salt (16) || record size (4) || key id length (1) || key id
+	if len(body) < 21 {
+		t.Fatalf("body too short: %d bytes", len(body))
+	}
+	salt := body[:16]
+	recordSize := binary.BigEndian.Uint32(body[16:20])
+	keyIDLen := int(body[20])
+	if keyIDLen != 65 {
+		t.Fatalf("key id length = %d, want 65", keyIDLen)
+	}
+	asPublicRaw := body[21 : 21+keyIDLen]
+	ciphertext := body[21+keyIDLen:]
+	if uint32(len(ciphertext)) > recordSize {
+		t.Fatalf("record of %d bytes exceeds record size %d", len(ciphertext), recordSize)
+	}
+
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicRaw)
+	if err != nil {
+		t.Fatalf("invalid application server key: %v", err)
+	}
+	ecdhSecret, err := b.private.ECDH(asPublic)
+	if err != nil {
+		t.Fatalf("ecdh: %v", err)
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), b.private.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublicRaw...)
+	ikm := mustHKDF(t, ecdhSecret, b.authSecret, keyInfo, 32)
+	cek := mustHKDF(t, ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := mustHKDF(t, ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatalf("aes: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("gcm: %v", err)
+	}
+	record, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+
+	// The last record ends with the 0x02 delimiter, optionally followed by padding
+	end := bytes.LastIndexByte(bytes.TrimRight(record, "\x00"), 0x02)
+	if end < 0 {
+		t.Fatal("record has no last-record delimiter")
+	}
+	return record[:end]
+}
+
+func mustHKDF(t *testing.T, secret, salt, info []byte, length int) []byte {
+	t.Helper()
+	out, err := hkdfExpand(secret, salt, info, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// newTestVAPIDKeys generates a VAPID key pair
+func newTestVAPIDKeys(t *testing.T) *VAPIDKeys {
+	t.Helper()
+
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate vapid key: %v", err)
+	}
+	keys, err := NewVAPIDKeys("mailto:ops@exobook.test", "", base64.RawURLEncoding.EncodeToString(private.Bytes()))
+	if err != nil {
+		t.Fatalf("NewVAPIDKeys: %v", err)
+	}
+	return keys
+}
+
+// verifyVAPID checks a "vapid t=..., k=..." header (RFC 8292) and returns
+// the token's claims
+func verifyVAPID(t *testing.T, header string, keys *VAPIDKeys) map[string]interface{} {
+	t.Helper()
+
+	params, ok := strings.CutPrefix(header, "vapid ")
+	if !ok {
+		t.Fatalf("Authorization = %q, want vapid scheme", header)
+	}
+	values := make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		values[name] = value
+	}
+	if values["k"] != keys.PublicKey {
+		t.Errorf("k = %q, want the VAPID public key %q", values["k"], keys.PublicKey)
+	}
+
+	rawKey, err := base64.RawURLEncoding.DecodeString(values["k"])
+	if err != nil || len(rawKey) != 65 {
+		t.Fatalf("k is not an uncompressed P-256 point: %v", err)
+	}
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(rawKey[1:33]),
+		Y:     new(big.Int).SetBytes(rawKey[33:]),
+	}
+
+	return verifyES256JWT(t, values["t"], publicKey)
+}
+
+// verifyES256JWT checks a compact ES256 JWT's signature and returns its claims
+func verifyES256JWT(t *testing.T, token string, publicKey *ecdsa.PublicKey) map[string]interface{} {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts, want 3", len(parts))
+	}
+
+	var header map[string]interface{}
+	decodeJWTPart(t, parts[0], &header)
+	if header["alg"] != "ES256" {
+		t.Errorf("alg = %v, want ES256", header["alg"])
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("signature is not a 64 byte r || s: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(publicKey, digest[:], r, s) {
+		t.Fatal("token signature does not verify")
+	}
+
+	var claims map[string]interface{}
+	decodeJWTPart(t, parts[1], &claims)
+	return claims
+}
+
+func decodeJWTPart(t *testing.T, part string, v interface{}) {
+	t.Helper()
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		t.Fatalf("decode jwt part: %v", err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatalf("parse jwt part: %v", err)
+	}
+}
+
+// pushRequest is a request received by the push service stand-in
+type pushRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// newPushService starts a push service stand-in answering with status
+func newPushService(t *testing.T, status int) (*httptest.Server, *[]pushRequest, *sync.Mutex) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []pushRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, pushRequest{Header: r.Header.Clone(), Body: body})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests, &mu
+}
+
+func TestWebPushDelivererEncryptsAndSigns(t *testing.T) {
+	server, requests, mu := newPushService(t, http.StatusCreated)
+	browser := newTestBrowser(t)
+	keys := newTestVAPIDKeys(t)
+	endpoint := server.URL + "/push/v1/abc"
+	subs := newMemoryPushSubscriptions(browser.subscription("owner-1", endpoint))
+
+	deliverer := NewWebPushDeliverer(subs, keys, nil)
+	notif := models.Notification{
+		Id:           "n1",
+		Owner:        "owner-1",
+		UserId:       "u1",
+		UserName:     "Jane",
+		Action:       models.ActionLikePost,
+		ResourceType: models.ResourceTypePost,
+		ResourceId:   "post-1",
+		Title:        "New like",
+		Body:         "Jane liked your post",
+		CreatedAt:    time.Now(),
+	}
+	if err := deliverer.Deliver(notif); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*requests) != 1 {
+		t.Fatalf("push service got %d requests, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+
+	if got := req.Header.Get("Content-Encoding"); got != "aes128gcm" {
+		t.Errorf("Content-Encoding = %q, want aes128gcm", got)
+	}
+	if got := req.Header.Get("TTL"); got != "86400" {
+		t.Errorf("TTL = %q, want 86400", got)
+	}
+
+	claims := verifyVAPID(t, req.Header.Get("Authorization"), keys)
+	if claims["aud"] != server.URL {
+		t.Errorf("aud = %v, want the endpoint origin %s", claims["aud"], server.URL)
+	}
+	if claims["sub"] != "mailto:ops@exobook.test" {
+		t.Errorf("sub = %v", claims["sub"])
+	}
+	exp, _ := claims["exp"].(float64)
+	if until := time.Until(time.Unix(int64(exp), 0)); until <= 0 || until > 24*time.Hour {
+		t.Errorf("exp is %v from now, want within 24h", until)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(browser.decrypt(t, req.Body), &payload); err != nil {
+		t.Fatalf("decrypted payload is not JSON: %v", err)
+	}
+	if payload["id"] != "n1" || payload["title"] != "New like" || payload["body"] != "Jane liked your post" {
+		t.Errorf("payload = %v", payload)
+	}
+}
+
+func TestWebPushDelivererPrunesExpiredSubscriptions(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			server, _, _ := newPushService(t, status)
+			browser := newTestBrowser(t)
+			subs := newMemoryPushSubscriptions(browser.subscription("owner-1", server.URL+"/push/expired"))
+
+			deliverer := NewWebPushDeliverer(subs, newTestVAPIDKeys(t), nil)
+			notif := models.Notification{Id: "n1", Owner: "owner-1", Action: models.ActionFollow, ResourceType: models.ResourceTypeUser, ResourceId: "owner-1"}
+			if err := deliverer.Deliver(notif); err != nil {
+				t.Fatalf("Deliver: %v", err)
+			}
+
+			if left, _ := subs.ListPushSubscriptions("owner-1"); len(left) != 0 {
+				t.Errorf("subscriptions left = %d, want the expired one pruned", len(left))
+			}
+		})
+	}
+}
+
+func TestWebPushDelivererKeepsSubscriptionOnServerError(t *testing.T) {
+	server, _, _ := newPushService(t, http.StatusInternalServerError)
+	browser := newTestBrowser(t)
+	subs := newMemoryPushSubscriptions(browser.subscription("owner-1", server.URL+"/push/flaky"))
+
+	deliverer := NewWebPushDeliverer(subs, newTestVAPIDKeys(t), nil)
+	notif := models.Notification{Id: "n1", Owner: "owner-1", Action: models.ActionFollow, ResourceType: models.ResourceTypeUser, ResourceId: "owner-1"}
+	if err := deliverer.Deliver(notif); err == nil {
+		t.Fatal("Deliver succeeded although the push service failed")
+	}
+
+	if left, _ := subs.ListPushSubscriptions("owner-1"); len(left) != 1 {
+		t.Errorf("subscriptions left = %d, want 1", len(left))
+	}
+}
+
+// onlineOwners reports fixed owners as connected to Pusher
+type onlineOwners map[string]bool
+
+func (o onlineOwners) IsOwnerOnline(owner string) bool { return o[owner] }
+
+func TestWebPushDelivererSkips(t *testing.T) {
+	server, requests, mu := newPushService(t, http.StatusCreated)
+	browser := newTestBrowser(t)
+	subs := newMemoryPushSubscriptions(
+		browser.subscription("online", server.URL+"/push/online"),
+		browser.subscription("offline", server.URL+"/push/offline"),
+	)
+	deliverer := NewWebPushDeliverer(subs, newTestVAPIDKeys(t), onlineOwners{"online": true})
+
+	// Connected to Pusher already
+	if err := deliverer.Deliver(models.Notification{Owner: "online", Action: models.ActionFollow}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	// new_post isn't pushed by default
+	if err := deliverer.Deliver(models.Notification{Owner: "offline", Action: models.ActionNewPost}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*requests) != 0 {
+		t.Errorf("push service got %d requests, want 0", len(*requests))
+	}
+}
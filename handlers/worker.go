@@ -1,18 +1,42 @@
 package handlers
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/aslotsu/notification-worker/models"
+	"github.com/aslotsu/notification-worker/schema"
+	"github.com/aslotsu/notification-worker/tracing"
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer creates the worker's spans (see the tracing package for setup)
+var tracer = otel.Tracer("github.com/aslotsu/notification-worker/handlers")
+
+// drainTimeout bounds how long Stop waits for buffered events
+const drainTimeout = 30 * time.Second
+
+// LifecycleHandler processes an event that changes existing notifications
+// (a resource or user was deleted, ...). Returning a *ValidationError
+// dead-letters the event as invalid, any other error as failed.
+type LifecycleHandler func(ctx context.Context, msg *nats.Msg) error
+
 type NotificationWorker struct {
 	nats                *nats.Conn
 	notificationService *NotificationService
-	subscription        *nats.Subscription
+	idempotency         IdempotencyStore
+	deadLetters         DeadLetterStore
+	lifecycle           map[string]LifecycleHandler // subject -> handler
+	subscriptions       []*nats.Subscription        // One per subject
 }
 
 // NewNotificationWorker creates a new notification worker
@@ -20,66 +44,284 @@ func NewNotificationWorker(nc *nats.Conn, notifService *NotificationService) *No
 	return &NotificationWorker{
 		nats:                nc,
 		notificationService: notifService,
+		lifecycle:           make(map[string]LifecycleHandler),
 	}
 }
 
+// HandleLifecycle routes a subject to a lifecycle handler instead of
+// notification creation. Every subject has its own subscription and NATS
+// runs each subscription's callback serially, so a handler that works for
+// minutes (fan-outs, broadcasts) only holds up its own subject. Call
+// before Start.
+func (w *NotificationWorker) HandleLifecycle(subject string, handler LifecycleHandler) {
+	w.lifecycle[subject] = handler
+}
+
+// HandleAction routes a fan-out action's subject, as declared in the
+// action registry, to a lifecycle handler. Call before Start.
+func (w *NotificationWorker) HandleAction(actionID int, handler LifecycleHandler) {
+	w.lifecycle[models.ActionSubject(actionID)] = handler
+}
+
+// SetIdempotencyStore enables skipping events whose id was already processed
+func (w *NotificationWorker) SetIdempotencyStore(store IdempotencyStore) {
+	w.idempotency = store
+}
+
+// SetDeadLetterStore enables recording events that fail processing
+func (w *NotificationWorker) SetDeadLetterStore(store DeadLetterStore) {
+	w.deadLetters = store
+}
+
 // Start begins listening for notification events
 func (w *NotificationWorker) Start() error {
-	log.Println("👂 Starting notification worker...")
+	slog.Info("starting notification worker")
 
-	// Subscribe to all notification events using wildcard
-	sub, err := w.nats.Subscribe("notifications.>", w.handleEvent)
-	if err != nil {
-		return err
+	// The action registry decides which subjects carry notification
+	// events. Fan-out actions are only read by their lifecycle handler.
+	for _, action := range models.ActionTypes() {
+		if action.FanOut {
+			continue
+		}
+		if err := w.subscribe(action.Subject); err != nil {
+			return err
+		}
+		slog.Info("subscribed to notification events", "subject", action.Subject, "action", action.Name)
+	}
+
+	for subject := range w.lifecycle {
+		if err := w.subscribe(subject); err != nil {
+			return err
+		}
+		slog.Info("subscribed to lifecycle events", "subject", subject)
 	}
 
-	w.subscription = sub
-	log.Println("✅ Subscribed to notifications.> (all notification events)")
+	return nil
+}
 
+// subscribe adds a subscription handling a subject's events
+func (w *NotificationWorker) subscribe(subject string) error {
+	sub, err := w.nats.Subscribe(subject, w.handleEvent)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", subject, err)
+	}
+	w.subscriptions = append(w.subscriptions, sub)
 	return nil
 }
 
-// Stop gracefully stops the worker
+// Stop gracefully stops the worker, letting queued events finish
 func (w *NotificationWorker) Stop() error {
-	log.Println("🛑 Stopping notification worker...")
+	slog.Info("stopping notification worker")
 
-	if w.subscription != nil {
-		if err := w.subscription.Unsubscribe(); err != nil {
-			return err
+	// Drain stops new deliveries and processes what is already buffered
+	for _, sub := range w.subscriptions {
+		if err := sub.Drain(); err != nil {
+			slog.Warn("failed to drain subscription", "subject", sub.Subject, "error", err)
 		}
 	}
 
-	return nil
+	deadline := time.Now().Add(drainTimeout)
+	for w.draining() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A fan-out or broadcast cut short resumes from its checkpoint on replay
+	var err error
+	for _, sub := range w.subscriptions {
+		if sub.IsValid() {
+			slog.Warn("drain timed out, unsubscribing", "subject", sub.Subject, "timeout", drainTimeout)
+			if unsubErr := sub.Unsubscribe(); unsubErr != nil {
+				err = unsubErr
+			}
+		}
+	}
+
+	return err
+}
+
+// draining returns true while any subscription still has events to finish
+func (w *NotificationWorker) draining() bool {
+	for _, sub := range w.subscriptions {
+		if sub.IsValid() {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscriptionValid returns true while the worker is subscribed to every
+// subject
+func (w *NotificationWorker) SubscriptionValid() bool {
+	if len(w.subscriptions) == 0 {
+		return false
+	}
+	for _, sub := range w.subscriptions {
+		if !sub.IsValid() {
+			return false
+		}
+	}
+	return true
 }
 
 // handleEvent processes a notification event from NATS
 func (w *NotificationWorker) handleEvent(msg *nats.Msg) {
 	startTime := time.Now()
 
-	log.Printf("📨 Received event on subject: %s", msg.Subject)
+	inFlightHandlers.Inc()
+	defer inFlightHandlers.Dec()
+	defer func() {
+		processingDuration.WithLabelValues(msg.Subject).Observe(time.Since(startTime).Seconds())
+	}()
+
+	// Continue the producer's trace from the W3C headers, if any
+	ctx := tracing.Extract(context.Background(), msg.Header)
+	ctx, span := tracer.Start(ctx, "process "+msg.Subject,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", msg.Subject),
+			attribute.Int("messaging.message.body.size", len(msg.Data)),
+			attribute.String("messaging.message.content_type", msg.Header.Get("Content-Type")),
+		),
+	)
+	defer span.End()
+
+	logger := slog.With("subject", msg.Subject)
+	if span.SpanContext().IsValid() {
+		logger = logger.With("trace_id", span.SpanContext().TraceID().String())
+	}
+	logger.Debug("received event")
+
+	if handler, ok := w.lifecycle[msg.Subject]; ok {
+		w.handleLifecycleEvent(ctx, logger, msg, handler)
+		return
+	}
+
+	// Parse event by Content-Type. JSON is validated against its schema
+	// version and older versions are upcast.
+	event, err := decodeEvent(msg, startTime)
+	if err != nil {
+		eventsReceived.WithLabelValues(msg.Subject, actionLabel(0)).Inc()
+		eventsInvalid.WithLabelValues(msg.Subject, actionLabel(0)).Inc()
+		span.SetStatus(codes.Error, "invalid payload")
+		span.RecordError(err)
 
-	// Parse event
-	var event models.NotificationEvent
-	if err := json.Unmarshal(msg.Data, &event); err != nil {
-		log.Printf("❌ Failed to unmarshal event: %v", err)
-		log.Printf("   Raw data: %s", string(msg.Data))
+		var schemaErr *schema.Error
+		if errors.As(err, &schemaErr) {
+			logger.Warn("event does not match its schema", "schema_version", schemaErr.Version, "fields", schemaErr.Fields, "raw", string(msg.Data))
+		} else {
+			logger.Error("failed to unmarshal event", "error", err, "raw", string(msg.Data))
+		}
+		w.deadLetter(logger, msg, models.DeadLetterInvalid, err)
 		return
 	}
 
+	logger = logger.With(
+		"owner", event.Owner,
+		"action", event.Action,
+		"resource_id", event.ResourceID,
+	)
+
+	span.SetAttributes(
+		attribute.Int("notification.schema_version", event.SchemaVersion),
+		attribute.String("notification.owner", event.Owner),
+		attribute.Int("notification.action", event.Action),
+		attribute.String("notification.resource_id", event.ResourceID),
+	)
+
+	action := actionLabel(event.Action)
+	eventsReceived.WithLabelValues(msg.Subject, action).Inc()
+
 	// Validate event
-	if err := w.validateEvent(&event); err != nil {
-		log.Printf("❌ Invalid event: %v", err)
+	_, validateSpan := tracer.Start(ctx, "validate event")
+	err = w.validateEvent(msg.Subject, &event)
+	if err != nil {
+		validateSpan.SetStatus(codes.Error, err.Error())
+	}
+	validateSpan.End()
+
+	if err != nil {
+		span.SetStatus(codes.Error, "invalid event")
+		eventsInvalid.WithLabelValues(msg.Subject, action).Inc()
+		logger.Warn("invalid event", "error", err)
+		w.deadLetter(logger, msg, models.DeadLetterInvalid, err)
 		return
 	}
 
 	// Skip if user is triggering action on their own content
 	if event.Owner == event.TriggerUser {
-		log.Printf("⏭️  Skipping self-notification: owner=%s, trigger=%s", event.Owner, event.TriggerUser)
+		span.SetAttributes(attribute.String("notification.outcome", "self_skipped"))
+		eventsSelfSkipped.WithLabelValues(msg.Subject, action).Inc()
+		logger.Debug("skipping self-notification", "trigger_user", event.TriggerUser)
 		return
 	}
 
+	// Skip redelivered or re-published events
+	eventID := event.EventID
+	if eventID == "" && msg.Header != nil {
+		eventID = msg.Header.Get(nats.MsgIdHdr)
+	}
+
+	if eventID != "" {
+		logger = logger.With("event_id", eventID)
+		span.SetAttributes(attribute.String("messaging.message.id", eventID))
+	}
+
+	if eventID != "" && w.idempotency != nil {
+		claimed, err := w.idempotency.Claim(ctx, eventID)
+		if err != nil {
+			// Better to risk a duplicate than drop the event
+			logger.Warn("failed to claim event id", "error", err)
+		} else if !claimed {
+			span.SetAttributes(attribute.String("notification.outcome", "redelivered"))
+			eventsRedelivered.WithLabelValues(msg.Subject, action).Inc()
+			logger.Info("event already processed, skipping")
+			return
+		}
+	}
+
 	// Convert event to notification
-	notification := models.Notification{
+	notification := notificationFromEvent(event, eventID)
+
+	logger = logger.With("action_key", notification.GenerateActionKey())
+
+	// Create notification in DynamoDB
+	err = w.notificationService.CreateNotification(ctx, notification)
+	if errors.Is(err, ErrDuplicateNotification) {
+		span.SetAttributes(attribute.String("notification.outcome", "deduplicated"))
+		eventsDeduplicated.WithLabelValues(msg.Subject, action).Inc()
+		logger.Info("duplicate notification skipped", "duration", time.Since(startTime))
+		return
+	}
+
+	if err != nil {
+		span.SetStatus(codes.Error, "create failed")
+		span.RecordError(err)
+		notificationsFailed.WithLabelValues(msg.Subject, action).Inc()
+		logger.Error("failed to create notification", "error", err, "duration", time.Since(startTime))
+
+		// Let a redelivery of this event try again
+		if eventID != "" && w.idempotency != nil {
+			if err := w.idempotency.Release(ctx, eventID); err != nil {
+				logger.Warn("failed to release event id", "error", err)
+			}
+		}
+
+		w.deadLetter(logger, msg, models.DeadLetterFailed, err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("notification.outcome", "created"))
+	notificationsCreated.WithLabelValues(msg.Subject, action).Inc()
+
+	logger.Info("processed notification", "duration", time.Since(startTime))
+}
+
+// notificationFromEvent builds the notification an event creates, whatever
+// encoding the event arrived in
+func notificationFromEvent(event models.NotificationEvent, eventID string) models.Notification {
+	return models.Notification{
+		Id:           uuid.New().String(),
 		Owner:        event.Owner,
 		UserId:       event.TriggerUser,
 		UserName:     event.Username,
@@ -88,44 +330,87 @@ func (w *NotificationWorker) handleEvent(msg *nats.Msg) {
 		Action:       event.Action,
 		ResourceType: event.ResourceType,
 		ResourceId:   event.ResourceID,
+		SourceId:     event.SourceID,
 		Excerpt:      event.Excerpt,
+		Locale:       event.Locale,
+		EventId:      eventID,
 		CreatedAt:    time.Unix(event.CreatedAt, 0),
 	}
+}
 
-	// Create notification in DynamoDB
-	if err := w.notificationService.CreateNotification(notification); err != nil {
-		log.Printf("❌ Failed to create notification: %v", err)
+// handleLifecycleEvent runs a lifecycle handler and dead-letters the
+// event if it fails
+func (w *NotificationWorker) handleLifecycleEvent(ctx context.Context, logger *slog.Logger, msg *nats.Msg, handler LifecycleHandler) {
+	span := trace.SpanFromContext(ctx)
 
-		// TODO: Implement retry logic or dead letter queue
-		// For now, just log the error
+	err := handler(ctx, msg)
+	if err == nil {
+		lifecycleEvents.WithLabelValues(msg.Subject, "processed").Inc()
 		return
 	}
 
-	duration := time.Since(startTime)
-	log.Printf("✅ Processed notification in %v (owner=%s, action=%d, resource=%s)",
-		duration, event.Owner, event.Action, event.ResourceID)
+	span.SetStatus(codes.Error, "lifecycle event failed")
+	span.RecordError(err)
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		lifecycleEvents.WithLabelValues(msg.Subject, "invalid").Inc()
+		logger.Warn("invalid lifecycle event", "error", err, "raw", string(msg.Data))
+		w.deadLetter(logger, msg, models.DeadLetterInvalid, err)
+		return
+	}
+
+	lifecycleEvents.WithLabelValues(msg.Subject, "failed").Inc()
+	logger.Error("failed to process lifecycle event", "error", err)
+	w.deadLetter(logger, msg, models.DeadLetterFailed, err)
 }
 
-// validateEvent validates the notification event
-func (w *NotificationWorker) validateEvent(event *models.NotificationEvent) error {
-	if event.Owner == "" {
-		return &ValidationError{Field: "owner", Message: "owner is required"}
+// deadLetter records a message that could not be processed, if a dead
+// letter store is configured
+func (w *NotificationWorker) deadLetter(logger *slog.Logger, msg *nats.Msg, reason string, cause error) {
+	if w.deadLetters == nil {
+		return
 	}
 
-	if event.TriggerUser == "" {
-		return &ValidationError{Field: "trigger_user", Message: "trigger_user is required"}
+	letter := NewDeadLetter(msg, reason, cause)
+	if err := w.deadLetters.RecordDeadLetter(letter); err != nil {
+		logger.Error("failed to record dead letter", "error", err)
+		return
 	}
 
+	eventsDeadLettered.WithLabelValues(msg.Subject, reason).Inc()
+	logger.Info("event sent to dead letter queue", "dead_letter_id", letter.Id, "reason", reason)
+}
+
+// validateEvent validates a notification event published on subject
+func (w *NotificationWorker) validateEvent(subject string, event *models.NotificationEvent) error {
 	if event.Action == 0 {
 		return &ValidationError{Field: "action", Message: "action is required"}
 	}
 
-	if event.ResourceID == "" {
-		return &ValidationError{Field: "resource_id", Message: "resource_id is required"}
+	// The action registry decides what else an event needs
+	action, ok := models.LookupAction(event.Action)
+	if !ok {
+		return &ValidationError{Field: "action", Message: fmt.Sprintf("unknown action %d", event.Action)}
+	}
+	if action.FanOut {
+		return &ValidationError{Field: "action", Message: action.Name + " is only written by fan-outs, not per-owner events"}
+	}
+	if subject != action.Subject {
+		return &ValidationError{Field: "action", Message: fmt.Sprintf("%s events are published on %s, not %s", action.Name, action.Subject, subject)}
 	}
 
-	if event.ResourceType == "" {
-		return &ValidationError{Field: "resource_type", Message: "resource_type is required"}
+	for _, field := range action.RequiredFields {
+		if strings.TrimSpace(event.Field(field)) == "" {
+			return &ValidationError{Field: field, Message: field + " is required"}
+		}
+	}
+
+	if !action.AllowsResourceType(event.ResourceType) {
+		return &ValidationError{
+			Field:   "resource_type",
+			Message: fmt.Sprintf("%s does not apply to %s (allowed: %s)", action.Name, event.ResourceType, strings.Join(action.ResourceTypes, ", ")),
+		}
 	}
 
 	return nil
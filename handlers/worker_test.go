@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// startTestNATS runs an in-process NATS server and connects to it
+func startTestNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(ns.Shutdown)
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// waitFor receives from a channel or fails the test
+func waitFor(t *testing.T, ch <-chan string, what string) string {
+	t.Helper()
+
+	select {
+	case subject := <-ch:
+		return subject
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		return ""
+	}
+}
+
+func TestFanOutDoesNotBlockOtherEvents(t *testing.T) {
+	nc := startTestNATS(t)
+	worker := NewNotificationWorker(nc, nil)
+
+	started := make(chan string, 10)
+	release := make(chan struct{})
+	worker.HandleAction(models.ActionNewPost, func(ctx context.Context, msg *nats.Msg) error {
+		started <- msg.Subject
+		<-release
+		return nil
+	})
+
+	handled := make(chan string, 10)
+	worker.HandleLifecycle(SubjectNotificationDismissed, func(ctx context.Context, msg *nats.Msg) error {
+		handled <- msg.Subject
+		return nil
+	})
+
+	if err := worker.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer func() {
+		close(release)
+		worker.Stop()
+	}()
+
+	nc.Publish(models.ActionSubject(models.ActionNewPost), []byte(`{"trigger_user":"user-1","resource_id":"post-1"}`))
+	waitFor(t, started, "the fan-out to start")
+
+	// The fan-out is still running
+	nc.Publish(SubjectNotificationDismissed, []byte(`{"owner":"user-2","id":"n-1"}`))
+	waitFor(t, handled, "the dismissal behind a running fan-out")
+
+	select {
+	case subject := <-started:
+		t.Errorf("%s handled twice", subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestValidateEventRejectsFanOutActions(t *testing.T) {
+	worker := NewNotificationWorker(nil, nil)
+
+	for _, id := range []int{models.ActionNewPost, models.ActionBroadcast} {
+		action, _ := models.LookupAction(id)
+		event := requiredOnlyEvent(action)
+
+		err := worker.validateEvent(action.Subject, &event)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Field != "action" {
+			t.Errorf("%s: validateEvent = %v, want an action validation error", action.Name, err)
+		}
+	}
+
+	action, _ := models.LookupAction(models.ActionLikePost)
+	event := requiredOnlyEvent(action)
+	if err := worker.validateEvent(action.Subject, &event); err != nil {
+		t.Errorf("like_post: validateEvent = %v, want nil", err)
+	}
+}
+
+func TestValidateEventRejectsActionOnAnotherSubject(t *testing.T) {
+	worker := NewNotificationWorker(nil, nil)
+
+	follow, _ := models.LookupAction(models.ActionFollow)
+	event := requiredOnlyEvent(follow)
+
+	err := worker.validateEvent("notifications.post.like", &event)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "action" {
+		t.Errorf("validateEvent = %v, want an action validation error", err)
+	}
+}
+
+func TestStartSubscribesToRegistrySubjects(t *testing.T) {
+	nc := startTestNATS(t)
+	worker := NewNotificationWorker(nc, nil)
+	worker.HandleLifecycle(SubjectNotificationDismissed, func(ctx context.Context, msg *nats.Msg) error { return nil })
+
+	if err := worker.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer worker.Stop()
+
+	subjects := make(map[string]bool)
+	for _, sub := range worker.subscriptions {
+		subjects[sub.Subject] = true
+	}
+	for _, action := range models.ActionTypes() {
+		if subjects[action.Subject] == action.FanOut {
+			t.Errorf("%s subscribed = %v, want %v", action.Subject, subjects[action.Subject], !action.FanOut)
+		}
+	}
+	if !subjects[SubjectNotificationDismissed] || subjects["notifications.>"] {
+		t.Errorf("subjects = %v, want the lifecycle subject and no wildcard", subjects)
+	}
+	if !worker.SubscriptionValid() {
+		t.Error("SubscriptionValid() = false after Start")
+	}
+}
+
+// gatedAudience returns a first page of members, then holds the second
+// until the gate opens
+type gatedAudience struct {
+	waiting chan string
+	gate    chan struct{}
+}
+
+func (a *gatedAudience) ListMembers(ctx context.Context, segment, cursor string, limit int32) ([]string, string, error) {
+	if cursor == "" {
+		return []string{"user-1", "user-2"}, "user-2", nil
+	}
+	a.waiting <- segment
+	<-a.gate
+	return []string{"user-3"}, "", nil
+}
+
+func TestRevocationStopsBroadcastInFlight(t *testing.T) {
+	nc := startTestNATS(t)
+	service, store, triggered := newTestMemoryService(t)
+	go func() {
+		for range triggered {
+		}
+	}()
+
+	audience := &gatedAudience{waiting: make(chan string, 1), gate: make(chan struct{})}
+	broadcasts := NewBroadcastService(service, audience, NewMemoryCheckpointStore(), 0)
+
+	sent := make(chan string, 1)
+	revoked := make(chan string, 1)
+	worker := NewNotificationWorker(nc, service)
+	worker.HandleAction(models.ActionBroadcast, func(ctx context.Context, msg *nats.Msg) error {
+		defer func() { sent <- msg.Subject }()
+		return broadcasts.HandleBroadcast(ctx, msg)
+	})
+	worker.HandleLifecycle(SubjectBroadcastRevoked, func(ctx context.Context, msg *nats.Msg) error {
+		defer func() { revoked <- msg.Subject }()
+		return broadcasts.HandleBroadcastRevoked(ctx, msg)
+	})
+
+	if err := worker.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer worker.Stop()
+
+	nc.Publish(models.ActionSubject(models.ActionBroadcast), []byte(`{"broadcast_id":"bc-1","message":"Hi","audience":{"segment":"beta"}}`))
+	waitFor(t, audience.waiting, "the broadcast's second page")
+
+	// The broadcast is still sending
+	nc.Publish(SubjectBroadcastRevoked, []byte(`{"broadcast_id":"bc-1"}`))
+	waitFor(t, revoked, "the revocation behind a running broadcast")
+
+	close(audience.gate)
+	waitFor(t, sent, "the broadcast to stop")
+
+	for _, owner := range []string{"user-1", "user-2", "user-3"} {
+		if ids := storedIDs(t, store, owner); len(ids) != 0 {
+			t.Errorf("%s still has broadcast notifications %v", owner, ids)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aslotsu/notification-worker/tracing"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dynamoDBStandIn answers the DynamoDB JSON protocol: empty Query pages
+// and successful writes. It records the X-Amz-Target of each call.
+type dynamoDBStandIn struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newDynamoDBStandIn(t *testing.T) *dynamoDBStandIn {
+	t.Helper()
+
+	s := &dynamoDBStandIn{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		s.mu.Lock()
+		s.targets = append(s.targets, target)
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if strings.HasSuffix(target, ".Query") {
+			w.Write([]byte(`{"Count":0,"Items":[],"ScannedCount":0}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// store returns a notification store talking to the stand-in
+func (s *dynamoDBStandIn) store() *DynamoDBNotificationStore {
+	return testDynamoDBStore(s.server.URL)
+}
+
+// pusherRequest is a call the Pusher stand-in received
+type pusherRequest struct {
+	Path string
+	Body []byte
+}
+
+// newPusherStandIn accepts Pusher triggers and signals each one
+func newPusherStandIn(t *testing.T) (*httptest.Server, <-chan pusherRequest) {
+	t.Helper()
+
+	triggered := make(chan pusherRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+		triggered <- pusherRequest{Path: r.URL.Path, Body: body}
+	}))
+	t.Cleanup(server.Close)
+	return server, triggered
+}
+
+// usePusherStandIn points a service's Pusher client at a stand-in
+func usePusherStandIn(t *testing.T, service *NotificationService) <-chan pusherRequest {
+	t.Helper()
+
+	server, triggered := newPusherStandIn(t)
+	serverURL, _ := url.Parse(server.URL)
+	service.pusherClient.Host = serverURL.Host
+	service.pusherClient.Secure = false
+	return triggered
+}
+
+// installTestTracing routes the global tracer provider and W3C propagator
+// to an in-memory exporter for the duration of a test
+func installTestTracing(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+
+	if _, err := tracing.Setup(context.Background(), "", "test", 1); err != nil {
+		t.Fatalf("tracing.Setup: %v", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewTracerProvider(exporter, "test", 1)
+	otel.SetTracerProvider(provider)
+
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return provider, exporter
+}
+
+// spanNamed returns the first exported span with a name
+func spanNamed(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func TestHandleEventContinuesProducerTrace(t *testing.T) {
+	provider, exporter := installTestTracing(t)
+
+	dynamo := newDynamoDBStandIn(t)
+	service := NewNotificationServiceWithStore(dynamo.store(), "app-1", "key", "secret", "")
+	triggered := usePusherStandIn(t, service)
+
+	worker := NewNotificationWorker(nil, service)
+
+	// A producer publishes inside its own span with W3C headers
+	producerCtx, producerSpan := otel.Tracer("producer").Start(context.Background(), "publish notifications.post.like",
+		trace.WithSpanKind(trace.SpanKindProducer))
+
+	data, _ := json.Marshal(map[string]any{
+		"schema_version": 2,
+		"owner":          "owner-1",
+		"trigger_user":   "user-2",
+		"username":       "Jane",
+		"action":         1,
+		"resource_type":  "POST",
+		"resource_id":    "post-1",
+		"created_at":     time.Now().Unix(),
+	})
+	msg := nats.NewMsg("notifications.post.like")
+	msg.Data = data
+	msg.Header.Set("Content-Type", "application/json")
+	tracing.Inject(producerCtx, msg)
+	producerSpan.End()
+
+	if msg.Header.Get("traceparent") == "" {
+		t.Fatal("tracing.Inject wrote no traceparent header")
+	}
+
+	worker.handleEvent(msg)
+
+	// Pusher is triggered in the background
+	select {
+	case <-triggered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pusher was never triggered")
+	}
+
+	var spans tracetest.SpanStubs
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		provider.ForceFlush(context.Background())
+		spans = exporter.GetSpans()
+		if _, ok := spanNamed(spans, "pusher trigger"); ok || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	producer := producerSpan.SpanContext()
+	consumer, ok := spanNamed(spans, "process notifications.post.like")
+	if !ok {
+		t.Fatalf("no consumer span among %d spans", len(spans))
+	}
+	if consumer.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("consumer span kind = %v", consumer.SpanKind)
+	}
+	if consumer.SpanContext.TraceID() != producer.TraceID() {
+		t.Errorf("consumer trace = %s, want the producer's %s", consumer.SpanContext.TraceID(), producer.TraceID())
+	}
+	if consumer.Parent.SpanID() != producer.SpanID() || !consumer.Parent.IsRemote() {
+		t.Errorf("consumer parent = %s (remote %v), want the producer span %s", consumer.Parent.SpanID(), consumer.Parent.IsRemote(), producer.SpanID())
+	}
+
+	for _, name := range []string{"validate event", "DynamoDB PutItem", "pusher trigger"} {
+		child, ok := spanNamed(spans, name)
+		if !ok {
+			t.Errorf("no %q span recorded", name)
+			continue
+		}
+		if child.SpanContext.TraceID() != producer.TraceID() {
+			t.Errorf("%q trace = %s, want %s", name, child.SpanContext.TraceID(), producer.TraceID())
+		}
+		if child.Parent.SpanID() != consumer.SpanContext.SpanID() {
+			t.Errorf("%q parent = %s, want the consumer span %s", name, child.Parent.SpanID(), consumer.SpanContext.SpanID())
+		}
+	}
+
+	put, _ := spanNamed(spans, "DynamoDB PutItem")
+	if put.SpanKind != trace.SpanKindClient {
+		t.Errorf("PutItem span kind = %v, want client", put.SpanKind)
+	}
+
+	dynamo.mu.Lock()
+	targets := strings.Join(dynamo.targets, ",")
+	dynamo.mu.Unlock()
+	if targets != "DynamoDB_20120810.PutItem" {
+		t.Errorf("DynamoDB calls = %s, want a single conditional PutItem", targets)
+	}
+}
@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Controller owns the process-wide log level and lets it change at runtime
+type Controller struct {
+	level   *slog.LevelVar
+	initial slog.Level
+}
+
+// Setup installs the default slog logger.
+// Production logs JSON for the log aggregator, other environments log text.
+func Setup(environment, level string) (*Controller, error) {
+	return SetupWriter(os.Stdout, environment, level)
+}
+
+// SetupWriter is Setup with an explicit output
+func SetupWriter(w io.Writer, environment, level string) (*Controller, error) {
+	parsed, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	levelVar := new(slog.LevelVar)
+	levelVar.Set(parsed)
+
+	opts := &slog.HandlerOptions{Level: levelVar}
+
+	var handler slog.Handler
+	if environment == "production" {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	slog.SetDefault(slog.New(handler).With("service", "notification-worker"))
+
+	return &Controller{level: levelVar, initial: parsed}, nil
+}
+
+// ParseLevel parses debug, info, warn/warning or error (case-insensitive)
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// Level returns the current log level
+func (c *Controller) Level() slog.Level {
+	return c.level.Level()
+}
+
+// SetLevel changes the log level
+func (c *Controller) SetLevel(level slog.Level) {
+	if c.level.Level() == level {
+		return
+	}
+	c.level.Set(level)
+	slog.Info("log level changed", "level", level.String())
+}
+
+// WatchSignals toggles debug logging on SIGUSR1 and restores the
+// configured level on SIGUSR2
+func (c *Controller) WatchSignals() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range sigCh {
+			if sig == syscall.SIGUSR1 {
+				c.SetLevel(slog.LevelDebug)
+			} else {
+				c.SetLevel(c.initial)
+			}
+		}
+	}()
+}
+
+// Handler serves the current level on GET and changes it on PUT/POST
+// with a body like {"level":"debug"}
+func (c *Controller) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1024)).Decode(&req); err != nil {
+				http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			level, err := ParseLevel(req.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c.SetLevel(level)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": strings.ToLower(c.Level().String())})
+	})
+}
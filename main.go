@@ -1,103 +1,358 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/aslotsu/notification-worker/config"
 	"github.com/aslotsu/notification-worker/handlers"
+	"github.com/aslotsu/notification-worker/logging"
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aslotsu/notification-worker/tracing"
 	"github.com/nats-io/nats.go"
 )
 
+// command is a subcommand of the notification-worker binary
+type command struct {
+	name    string
+	summary string
+	run     func(cfg *config.Config, args []string) error
+}
+
+// commands lists every subcommand; "serve" runs when none is given
+var commands = []command{
+	{"serve", "Run the notification worker", runServe},
+	{"publish", "Publish a notification event", runPublishCommand},
+	{"query", "List a user's notifications", runQueryCommand},
+	{"mark-read", "Mark notifications as read", runMarkReadCommand},
+	{"dismiss", "Dismiss a notification", runDismissCommand},
+	{"dlq", "Inspect the dead letter queue", runDLQCommand},
+	{"replay", "Replay dead letters through the worker", runReplayCommand},
+	{"table", "Inspect the notifications table", runTableCommand},
+	{"export", "Export everything held about a user", runExportCommand},
+	{"schema", "Export event JSON Schemas or validate a payload", runSchemaCommand},
+}
+
 func main() {
-	log.Println("🚀 Exobook Notification Worker Starting...")
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage()
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		// Every subcommand is driven by the same configuration
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			fatal("failed to load config", err)
+		}
+
+		// Tools only log problems; serve sets up its own logging
+		if cmd.name != "serve" {
+			slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+		}
+
+		if err := cmd.run(cfg, args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Load configuration
-	cfg, err := config.LoadConfig()
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// usage lists the subcommands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: notification-worker [command] [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run \"notification-worker <command> -h\" for a command's flags.")
+}
+
+// runServe runs the worker until SIGINT or SIGTERM
+func runServe(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	dev := fs.Bool("dev", false, "run offline: embedded NATS, in-memory (or NOTIFICATIONS_FILE) store and log-only delivery")
+	devNATSPort := fs.Int("dev-nats-port", 4222, "embedded NATS port in dev mode (-1 picks a free port)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Structured logging: JSON in production, text elsewhere
+	logLevel, err := logging.Setup(cfg.Environment, cfg.LogLevel)
 	if err != nil {
-		log.Fatalf("❌ Failed to load config: %v", err)
+		fatal("invalid LOG_LEVEL", err)
 	}
+	logLevel.WatchSignals()
 
-	log.Printf("✅ Configuration loaded (env=%s, region=%s)", cfg.Environment, cfg.AWSRegion)
+	slog.Info("notification worker starting",
+		"env", cfg.Environment,
+		"region", cfg.AWSRegion,
+		"log_level", logLevel.Level().String(),
+		"dev", *dev,
+	)
 
-	// Connect to NATS
-	log.Printf("📡 Connecting to NATS at %s...", cfg.NatsURL)
+	// Tracing: continue producers' traces and export spans over OTLP
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.OTLPEndpoint, cfg.Environment, cfg.TraceSampleRatio)
+	if err != nil {
+		fatal("failed to set up tracing", err)
+	}
 
-	var nc *nats.Conn
-	if cfg.NatsCredsFile != "" {
-		// Connect with credentials file
-		nc, err = nats.Connect(
-			cfg.NatsURL,
-			nats.UserCredentials(cfg.NatsCredsFile),
-			nats.Name("notification-worker"),
-			nats.ReconnectWait(nats.DefaultReconnectWait),
-			nats.MaxReconnects(-1), // Unlimited reconnects
-		)
-	} else {
-		// Connect without credentials (for local dev)
-		nc, err = nats.Connect(
-			cfg.NatsURL,
-			nats.Name("notification-worker"),
-		)
+	// Dev mode runs its own NATS server and never touches AWS or Pusher
+	if *dev {
+		ns, err := startDevNATS(*devNATSPort)
+		if err != nil {
+			fatal("failed to start embedded NATS", err)
+		}
+		defer ns.Shutdown()
+
+		cfg.NatsURL = ns.ClientURL()
+		cfg.NatsCredsFile = ""
+		cfg.PusherAppID, cfg.PusherSecret = "", ""
+		cfg.IdempotencyTable = ""
+		cfg.CheckpointsTable = ""
 	}
 
+	// Connect to NATS
+	slog.Info("connecting to NATS", "url", cfg.NatsURL)
+
+	nc, err := connectNATS(cfg)
 	if err != nil {
-		log.Fatalf("❌ Failed to connect to NATS: %v", err)
+		fatal("failed to connect to NATS", err)
 	}
 	defer nc.Close()
 
-	log.Println("✅ Connected to NATS")
+	slog.Info("connected to NATS", "url", nc.ConnectedUrlRedacted())
 
 	// Setup connection status callbacks
 	nc.SetDisconnectErrHandler(func(nc *nats.Conn, err error) {
 		if err != nil {
-			log.Printf("⚠️  Disconnected from NATS: %v", err)
+			slog.Warn("disconnected from NATS", "error", err)
 		}
 	})
 
 	nc.SetReconnectHandler(func(nc *nats.Conn) {
-		log.Printf("🔄 Reconnected to NATS at %s", nc.ConnectedUrl())
+		slog.Info("reconnected to NATS", "url", nc.ConnectedUrlRedacted())
 	})
 
 	nc.SetClosedHandler(func(nc *nats.Conn) {
-		log.Println("🔌 NATS connection closed")
+		slog.Info("NATS connection closed")
 	})
 
+	handlers.RegisterNATSMetrics(nc)
+
+	// Serve operational endpoints
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handlers.MetricsHandler())
+	mux.HandleFunc("/healthz", handlers.HandleHealthz)
+
+	httpServer := &http.Server{
+		Addr:              cfg.HTTPAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		slog.Info("serving HTTP endpoints", "addr", cfg.HTTPAddr)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("HTTP server failed", err)
+		}
+	}()
+
+	// Admin endpoints change how the worker runs, so they get their own
+	// listener, bound to localhost by default
+	adminServer := newAdminServer(cfg.AdminAddr, logLevel)
+
 	// Initialize notification service
-	log.Printf("💾 Initializing DynamoDB notification service (table=%s)...", cfg.NotifTableName)
+	store, err := newNotificationStore(cfg, *dev)
+	if err != nil {
+		fatal("failed to initialize notification store", err)
+	}
+
+	// Create, migrate or check the notifications table when asked
+	if dynamoStore, ok := store.(*handlers.DynamoDBNotificationStore); ok {
+		if err := prepareTable(cfg, dynamoStore); err != nil {
+			fatal("notifications table is not ready", err)
+		}
+	}
 
-	notifService, err := handlers.NewNotificationService(
-		cfg.AWSRegion,
-		cfg.NotifTableName,
+	notifService := handlers.NewNotificationServiceWithStore(
+		store,
 		cfg.PusherAppID,
 		cfg.PusherKey,
 		cfg.PusherSecret,
 		cfg.PusherCluster,
 	)
+
+	// Render notification titles and bodies server-side
+	renderer, err := handlers.NewMessageRenderer(cfg.DefaultLocale)
 	if err != nil {
-		log.Fatalf("❌ Failed to initialize notification service: %v", err)
+		fatal("failed to load message templates", err)
+	}
+	notifService.SetMessageRenderer(renderer)
+
+	// Keep trigger user snapshots current when profiles change
+	notifService.SetSnapshotRefreshWindow(cfg.SnapshotWindow)
+	if cfg.ProfileCacheSize > 0 {
+		notifService.SetProfileCache(handlers.NewProfileCache(cfg.ProfileCacheSize))
 	}
 
-	log.Println("✅ Notification service initialized")
+	slog.Info("notification service initialized")
+
+	// Dev mode only logs deliveries; other channels need AWS or credentials
+	var digestJob *handlers.DigestJob
+	var webhookStore handlers.WebhookStore
+	if *dev {
+		notifService.AddDeliverer(handlers.LogDeliverer{})
+	} else {
+		// Start email digests if SMTP is configured
+		if cfg.DigestEnabled() {
+			digestJob, err = newDigestJob(cfg, notifService, renderer)
+			if err != nil {
+				fatal("failed to initialize email digests", err)
+			}
+			digestJob.Start()
+		} else {
+			slog.Warn("SMTP_HOST not set, email digests disabled")
+		}
+
+		// Register web push if VAPID keys are configured
+		if cfg.WebPushEnabled() {
+			if err := setupWebPush(cfg, nc, notifService); err != nil {
+				fatal("failed to initialize web push", err)
+			}
+		} else {
+			slog.Warn("VAPID_PRIVATE_KEY not set, web push disabled")
+		}
+
+		// Register mobile push if FCM or APNs is configured
+		if cfg.MobilePushEnabled() {
+			if err := setupMobilePush(cfg, nc, notifService, renderer); err != nil {
+				fatal("failed to initialize mobile push", err)
+			}
+		} else {
+			slog.Warn("FCM/APNs credentials not set, mobile push disabled")
+		}
+
+		// Register outgoing webhooks if enabled
+		if cfg.WebhooksActive() {
+			webhookStore, err = setupWebhooks(cfg, nc, notifService)
+			if err != nil {
+				fatal("failed to initialize webhooks", err)
+			}
+		}
+	}
 
 	// Create and start worker
 	worker := handlers.NewNotificationWorker(nc, notifService)
 
+	// Lifecycle events change existing notifications
+	worker.HandleLifecycle(handlers.SubjectResourceDeleted, notifService.HandleResourceDeleted)
+	worker.HandleLifecycle(handlers.SubjectNotificationDismissed, notifService.HandleNotificationDismissed)
+	worker.HandleLifecycle(handlers.SubjectProfileUpdated, notifService.HandleProfileUpdated)
+
+	// Long-running jobs (purges, fan-outs) resume from checkpoints
+	checkpoints, err := newCheckpointStore(cfg)
+	if err != nil {
+		fatal("failed to initialize checkpoint store", err)
+	}
+
+	privacy, err := newPrivacyService(cfg, notifService, checkpoints, *dev)
+	if err != nil {
+		fatal("failed to initialize privacy service", err)
+	}
+	if webhookStore != nil {
+		// The same store the deliverer logs to, in-memory logs included
+		privacy.SetWebhookDeliveryStore(webhookStore)
+	}
+	worker.HandleLifecycle(handlers.SubjectUserDeleted, privacy.HandleUserDeleted)
+
+	// Notify users mentioned in new posts and comments
+	if cfg.MentionsEnabled {
+		mentions, err := newMentionService(cfg, notifService, *dev)
+		if err != nil {
+			fatal("failed to initialize mentions", err)
+		}
+		worker.HandleLifecycle(handlers.SubjectContentCreated, mentions.HandleContentCreated)
+	}
+
+	// Notify followers of new posts
+	if cfg.FanOutEnabled {
+		fanOut, err := newFanOutService(cfg, notifService, checkpoints, *dev)
+		if err != nil {
+			fatal("failed to initialize fan-out", err)
+		}
+		worker.HandleAction(models.ActionNewPost, fanOut.HandleFanOut)
+	}
+
+	// Send announcements to all users or a segment
+	if cfg.BroadcastEnabled {
+		broadcasts, err := newBroadcastService(cfg, notifService, checkpoints, *dev)
+		if err != nil {
+			fatal("failed to initialize broadcasts", err)
+		}
+		worker.HandleAction(models.ActionBroadcast, broadcasts.HandleBroadcast)
+		worker.HandleLifecycle(handlers.SubjectBroadcastRevoked, broadcasts.HandleBroadcastRevoked)
+	}
+
+	// Remember processed event ids so redeliveries are skipped
+	idempotency, err := newIdempotencyStore(cfg)
+	if err != nil {
+		fatal("failed to initialize idempotency store", err)
+	}
+	worker.SetIdempotencyStore(idempotency)
+
+	// Keep events that fail processing for inspection and replay
+	if cfg.DLQEnabled && !*dev {
+		deadLetters, err := handlers.NewDeadLetterService(cfg.AWSRegion, cfg.DLQTable)
+		if err != nil {
+			fatal("failed to initialize dead letter queue", err)
+		}
+		worker.SetDeadLetterStore(deadLetters)
+		slog.Info("dead letter queue enabled", "table", cfg.DLQTable)
+	}
+
 	if err := worker.Start(); err != nil {
-		log.Fatalf("❌ Failed to start worker: %v", err)
+		fatal("failed to start worker", err)
 	}
 
-	log.Println("🎉 Notification worker is running!")
-	log.Println("📬 Listening for events on: notifications.>")
-	log.Println("   - notifications.post.like")
-	log.Println("   - notifications.post.unlike")
-	log.Println("   - notifications.comment.like")
-	log.Println("   - notifications.reply.post")
-	log.Println("   - notifications.reply.comment")
-	log.Println()
-	log.Println("Press Ctrl+C to stop...")
+	// Readiness is only registered once the worker is subscribed;
+	// until then /readyz answers 404 and probes keep waiting
+	health := handlers.NewHealthChecker(nc, worker, notifService, cfg.RequirePusher)
+	mux.HandleFunc("/readyz", health.HandleReadyz)
+
+	slog.Info("notification worker is running")
+
+	if *dev {
+		printDevUsage(cfg)
+	}
 
 	// Wait for interrupt signal
 	sigCh := make(chan os.Signal, 1)
@@ -106,13 +361,389 @@ func main() {
 	// Block until signal received
 	<-sigCh
 
-	log.Println()
-	log.Println("🛑 Shutdown signal received, cleaning up...")
+	slog.Info("shutdown signal received, cleaning up")
+
+	// Fail readiness first so the platform stops routing to this instance
+	health.SetDraining()
+	slog.Info("draining", "delay", cfg.DrainDelay)
+	time.Sleep(cfg.DrainDelay)
 
 	// Graceful shutdown
 	if err := worker.Stop(); err != nil {
-		log.Printf("⚠️  Error stopping worker: %v", err)
+		slog.Warn("error stopping worker", "error", err)
+	}
+
+	if digestJob != nil {
+		digestJob.Stop()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("error stopping HTTP server", "error", err)
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("error stopping admin server", "error", err)
+		}
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Warn("error flushing traces", "error", err)
+	}
+
+	slog.Info("notification worker stopped")
+	return nil
+}
+
+// connectNATS connects with the configured URL and credentials
+func connectNATS(cfg *config.Config) (*nats.Conn, error) {
+	if cfg.NatsCredsFile != "" {
+		// Connect with credentials file
+		return nats.Connect(
+			cfg.NatsURL,
+			nats.UserCredentials(cfg.NatsCredsFile),
+			nats.Name("notification-worker"),
+			nats.ReconnectWait(nats.DefaultReconnectWait),
+			nats.MaxReconnects(-1), // Unlimited reconnects
+		)
+	}
+
+	// Connect without credentials (for local dev)
+	return nats.Connect(
+		cfg.NatsURL,
+		nats.Name("notification-worker"),
+	)
+}
+
+// fatal logs an error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newAdminServer serves /admin/loglevel on addr, or nothing when addr is
+// empty. It isn't on HTTP_ADDR: anyone reaching that port could turn on
+// debug logs, which carry raw payloads.
+func newAdminServer(addr string, logLevel *logging.Controller) *http.Server {
+	if addr == "" {
+		slog.Info("admin endpoints disabled")
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/loglevel", logLevel.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		slog.Info("serving admin endpoints", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("admin server failed", err)
+		}
+	}()
+
+	return server
+}
+
+// newIdempotencyStore shares processed event ids through DynamoDB when
+// IDEMPOTENCY_TABLE is set, otherwise each instance remembers its own
+func newIdempotencyStore(cfg *config.Config) (handlers.IdempotencyStore, error) {
+	if cfg.IdempotencyTable == "" {
+		slog.Info("using in-memory idempotency store", "ttl", cfg.IdempotencyTTL)
+		return handlers.NewMemoryIdempotencyStore(cfg.IdempotencyTTL), nil
+	}
+
+	slog.Info("using DynamoDB idempotency store", "table", cfg.IdempotencyTable, "ttl", cfg.IdempotencyTTL)
+	return handlers.NewDynamoDBIdempotencyStore(cfg.AWSRegion, cfg.IdempotencyTable, cfg.IdempotencyTTL)
+}
+
+// newCheckpointStore shares job progress through DynamoDB when
+// CHECKPOINTS_TABLE is set, otherwise each instance keeps its own
+func newCheckpointStore(cfg *config.Config) (handlers.CheckpointStore, error) {
+	if cfg.CheckpointsTable == "" {
+		slog.Info("using in-memory checkpoint store")
+		return handlers.NewMemoryCheckpointStore(), nil
+	}
+
+	slog.Info("using DynamoDB checkpoint store", "table", cfg.CheckpointsTable)
+	return handlers.NewDynamoDBCheckpointStore(cfg.AWSRegion, cfg.CheckpointsTable)
+}
+
+// newPrivacyService wires user purges and exports. Preferences, devices,
+// push subscriptions, webhook deliveries and dead letters are covered when
+// their feature is configured, never in dev mode or with NOTIFICATIONS_FILE,
+// which keep no such records.
+func newPrivacyService(cfg *config.Config, notifService *handlers.NotificationService, checkpoints handlers.CheckpointStore, dev bool) (*handlers.PrivacyService, error) {
+	privacy := handlers.NewPrivacyService(notifService, checkpoints)
+	if dev || cfg.NotificationsFile != "" {
+		return privacy, nil
+	}
+
+	if cfg.DigestEnabled() {
+		prefService, err := handlers.NewPreferenceService(cfg.AWSRegion, cfg.PrefsTableName)
+		if err != nil {
+			return nil, err
+		}
+		privacy.SetPreferenceStore(prefService)
+	}
+
+	if cfg.WebPushEnabled() {
+		subService, err := handlers.NewPushSubscriptionService(cfg.AWSRegion, cfg.PushSubsTable)
+		if err != nil {
+			return nil, err
+		}
+		privacy.SetPushSubscriptionStore(subService)
+	}
+
+	if cfg.MobilePushEnabled() {
+		deviceService, err := handlers.NewDeviceService(cfg.AWSRegion, cfg.DevicesTable)
+		if err != nil {
+			return nil, err
+		}
+		privacy.SetDeviceStore(deviceService)
+	}
+
+	// serve replaces this with the deliverer's store, which may be in memory
+	if cfg.WebhooksActive() && cfg.WebhookEndpoints == "" {
+		webhookService, err := handlers.NewWebhookService(cfg.AWSRegion, cfg.WebhooksTable, cfg.WebhookLogTable)
+		if err != nil {
+			return nil, err
+		}
+		privacy.SetWebhookDeliveryStore(webhookService)
+	}
+
+	if cfg.DLQEnabled {
+		deadLetters, err := handlers.NewDeadLetterService(cfg.AWSRegion, cfg.DLQTable)
+		if err != nil {
+			return nil, err
+		}
+		privacy.SetDeadLetterStore(deadLetters)
+	}
+
+	return privacy, nil
+}
+
+// newMentionService resolves handles through HANDLES_TABLE behind a
+// cache. In dev mode a handle is the user id and nobody is blocked.
+func newMentionService(cfg *config.Config, notifService *handlers.NotificationService, dev bool) (*handlers.MentionService, error) {
+	if dev {
+		identity := handlers.HandleResolverFunc(func(ctx context.Context, handles []string) (map[string]string, error) {
+			resolved := make(map[string]string, len(handles))
+			for _, handle := range handles {
+				resolved[handle] = handle
+			}
+			return resolved, nil
+		})
+		slog.Info("mentions enabled, handles are user ids in dev mode")
+		return handlers.NewMentionService(notifService, identity, cfg.MaxMentions), nil
+	}
+
+	resolver, err := handlers.NewDynamoDBHandleResolver(cfg.AWSRegion, cfg.HandlesTable)
+	if err != nil {
+		return nil, err
+	}
+	cached := handlers.NewCachingHandleResolver(resolver, cfg.HandleCacheTTL, cfg.HandleCacheSize)
+	mentions := handlers.NewMentionService(notifService, cached, cfg.MaxMentions)
+
+	if cfg.BlocksTable != "" {
+		blocks, err := handlers.NewDynamoDBBlockList(cfg.AWSRegion, cfg.BlocksTable)
+		if err != nil {
+			return nil, err
+		}
+		mentions.SetBlockList(blocks)
+	}
+
+	slog.Info("mentions enabled", "handles_table", cfg.HandlesTable, "blocks_table", cfg.BlocksTable, "max_per_content", cfg.MaxMentions)
+	return mentions, nil
+}
+
+// newFanOutService reads followers from FOLLOWERS_TABLE. In dev mode the
+// sample trigger user is followed by the sample owner.
+func newFanOutService(cfg *config.Config, notifService *handlers.NotificationService, checkpoints handlers.CheckpointStore, dev bool) (*handlers.FanOutService, error) {
+	var followers handlers.FollowerSource
+	if dev {
+		followers = handlers.NewMemoryFollowerSource(map[string][]string{devTriggerUser: {devOwner}})
+		slog.Info("fan-out enabled", "followers", "dev", "max_followers", cfg.MaxFanOut)
+	} else {
+		source, err := handlers.NewDynamoDBFollowerSource(cfg.AWSRegion, cfg.FollowersTable)
+		if err != nil {
+			return nil, err
+		}
+		followers = source
+		slog.Info("fan-out enabled", "followers_table", cfg.FollowersTable, "max_followers", cfg.MaxFanOut)
+	}
+
+	return handlers.NewFanOutService(notifService, followers, checkpoints, cfg.MaxFanOut), nil
+}
+
+// newBroadcastService reads segments from SEGMENTS_TABLE. In dev mode
+// the "all" segment holds the sample owner and trigger user.
+func newBroadcastService(cfg *config.Config, notifService *handlers.NotificationService, checkpoints handlers.CheckpointStore, dev bool) (*handlers.BroadcastService, error) {
+	var audiences handlers.AudienceProvider
+	if dev {
+		audiences = handlers.NewMemorySegmentProvider(map[string][]string{models.SegmentAll: {devOwner, devTriggerUser}})
+		slog.Info("broadcasts enabled", "segments", "dev", "rate", cfg.BroadcastRate)
+	} else {
+		provider, err := handlers.NewDynamoDBSegmentProvider(cfg.AWSRegion, cfg.SegmentsTable)
+		if err != nil {
+			return nil, err
+		}
+		audiences = provider
+		slog.Info("broadcasts enabled", "segments_table", cfg.SegmentsTable, "rate", cfg.BroadcastRate)
+	}
+
+	return handlers.NewBroadcastService(notifService, audiences, checkpoints, cfg.BroadcastRate), nil
+}
+
+// prepareTable creates and migrates the notifications table if
+// TABLE_BOOTSTRAP is set, then validates it if TABLE_CHECK is set
+func prepareTable(cfg *config.Config, store *handlers.DynamoDBNotificationStore) error {
+	ctx := context.Background()
+
+	if cfg.TableBootstrap {
+		steps, err := store.EnsureTable(ctx)
+		for _, step := range steps {
+			slog.Info("table bootstrap", "table", cfg.NotifTableName, "step", step)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.TableCheck {
+		problems, err := store.ValidateTable(ctx)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			slog.Error("table check failed", "table", cfg.NotifTableName, "problem", problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d problem(s) with table %s, run \"notification-worker table migrate\"", len(problems), cfg.NotifTableName)
+		}
+		slog.Info("table check passed", "table", cfg.NotifTableName)
+	}
+
+	return nil
+}
+
+// newDigestJob wires the email digest job from configuration
+func newDigestJob(cfg *config.Config, notifService *handlers.NotificationService, messages *handlers.MessageRenderer) (*handlers.DigestJob, error) {
+	prefService, err := handlers.NewPreferenceService(cfg.AWSRegion, cfg.PrefsTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	mailer, err := handlers.NewSMTPMailer(
+		cfg.SMTPHost,
+		cfg.SMTPPort,
+		cfg.SMTPUsername,
+		cfg.SMTPPassword,
+		cfg.SMTPFrom,
+		cfg.SMTPTLSMode,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	renderer, err := handlers.NewDigestRenderer(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	return handlers.NewDigestJob(notifService, prefService, mailer, renderer, cfg.DigestInterval), nil
+}
+
+// setupWebPush registers the web push deliverer and subscription listener
+func setupWebPush(cfg *config.Config, nc *nats.Conn, notifService *handlers.NotificationService) error {
+	keys, err := handlers.NewVAPIDKeys(cfg.VAPIDSubject, cfg.VAPIDPublicKey, cfg.VAPIDPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	subService, err := handlers.NewPushSubscriptionService(cfg.AWSRegion, cfg.PushSubsTable)
+	if err != nil {
+		return err
+	}
+
+	if _, err := handlers.SubscribePushRegistrations(nc, subService); err != nil {
+		return err
+	}
+
+	var online handlers.OnlineChecker
+	if cfg.WebPushOffline {
+		online = notifService
+	}
+
+	notifService.AddDeliverer(handlers.NewWebPushDeliverer(subService, keys, online))
+	return nil
+}
+
+// setupMobilePush registers the mobile push deliverer and device registry listener
+func setupMobilePush(cfg *config.Config, nc *nats.Conn, notifService *handlers.NotificationService, renderer *handlers.MessageRenderer) error {
+	var senders []handlers.PushSender
+
+	if cfg.FCMCredentials != "" {
+		fcm, err := handlers.NewFCMSender(cfg.FCMCredentials, cfg.FCMBaseURL)
+		if err != nil {
+			return err
+		}
+		senders = append(senders, fcm)
+	}
+
+	if cfg.APNsKeyFile != "" {
+		apns, err := handlers.NewAPNsSender(cfg.APNsKeyFile, cfg.APNsKeyID, cfg.APNsTeamID, cfg.APNsTopic, cfg.APNsBaseURL)
+		if err != nil {
+			return err
+		}
+		senders = append(senders, apns)
+	}
+
+	deviceService, err := handlers.NewDeviceService(cfg.AWSRegion, cfg.DevicesTable)
+	if err != nil {
+		return err
+	}
+
+	if _, err := handlers.SubscribeDeviceRegistrations(nc, deviceService); err != nil {
+		return err
+	}
+
+	notifService.AddDeliverer(handlers.NewMobilePushDeliverer(deviceService, renderer, senders...))
+	return nil
+}
+
+// setupWebhooks registers the webhook deliverer and its admin subjects and
+// returns its store. Endpoints listed in WEBHOOK_ENDPOINTS use an
+// in-memory store, otherwise endpoints and the delivery log live in DynamoDB.
+func setupWebhooks(cfg *config.Config, nc *nats.Conn, notifService *handlers.NotificationService) (handlers.WebhookStore, error) {
+	var store handlers.WebhookStore
+
+	if cfg.WebhookEndpoints != "" {
+		endpoints, err := handlers.ParseWebhookEndpoints(cfg.WebhookEndpoints)
+		if err != nil {
+			return nil, err
+		}
+		store = handlers.NewMemoryWebhookStore(endpoints, 1000)
+		slog.Info("loaded webhook endpoints from config", "count", len(endpoints))
+	} else {
+		webhookService, err := handlers.NewWebhookService(cfg.AWSRegion, cfg.WebhooksTable, cfg.WebhookLogTable)
+		if err != nil {
+			return nil, err
+		}
+		store = webhookService
+	}
+
+	deliverer := handlers.NewWebhookDeliverer(store, cfg.WebhookAttempts, cfg.WebhookBackoff, cfg.WebhookDisableAfter)
+
+	if _, err := handlers.SubscribeWebhookAdmin(nc, deliverer, store); err != nil {
+		return nil, err
 	}
 
-	log.Println("👋 Notification worker stopped")
+	notifService.AddDeliverer(deliverer)
+	return store, nil
 }
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// WriteActionDocs writes the action registry as a Markdown reference
+func WriteActionDocs(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("# Notification Actions\n\n")
+	b.WriteString("<!-- Generated from the action registry in models/event.go by `make docs`. Do not edit. -->\n\n")
+	b.WriteString("Events with an action missing here are rejected.\n\n")
+	b.WriteString("| Action | Name | Subject | Resource types | Required fields | Dedup | Aggregation | Push | Email | Template | Retention |\n")
+	b.WriteString("|--------|------|---------|----------------|-----------------|-------|-------------|------|-------|----------|-----------|\n")
+
+	for _, a := range actionTypes {
+		fmt.Fprintf(&b, "| `%d` | `%s` | `%s` | %s | %s | %s | %s | %s | %s | `%s` | %s |\n",
+			a.ID,
+			a.Name,
+			a.Subject,
+			codeList(a.ResourceTypes),
+			codeList(a.RequiredFields),
+			a.Dedup,
+			a.Aggregation,
+			onOff(a.DefaultPreference.Push),
+			onOff(a.DefaultPreference.Email),
+			a.TemplateKey,
+			retention(a.Retention),
+		)
+	}
+
+	b.WriteString("\n")
+	for _, a := range actionTypes {
+		fmt.Fprintf(&b, "- `%s`: %s\n", a.Name, a.Description)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// codeList formats values as comma-separated inline code
+func codeList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "`" + v + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// retention formats a retention policy in days
+func retention(d time.Duration) string {
+	if d <= 0 {
+		return "until dismissed"
+	}
+	return fmt.Sprintf("%d days", d/day)
+}
+
+// onOff formats a default channel preference
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
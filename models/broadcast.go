@@ -0,0 +1,80 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// SegmentAll is the segment holding every user, used for "all" audiences
+const SegmentAll = "all"
+
+// BroadcastSender is the trigger user of broadcast notifications. userid
+// keys TriggerUserIndex, so it can't be left empty.
+const BroadcastSender = "exobook"
+
+// Audience says who receives a broadcast. Exactly one of All, UserIDs
+// and Segment is set.
+type Audience struct {
+	All     bool     `json:"all,omitempty"`      // Every user
+	UserIDs []string `json:"user_ids,omitempty"` // These users
+	Segment string   `json:"segment,omitempty"`  // Members of a named segment
+}
+
+// Validate checks that exactly one audience kind is set
+func (a Audience) Validate() error {
+	kinds := 0
+	if a.All {
+		kinds++
+	}
+	if len(a.UserIDs) > 0 {
+		kinds++
+	}
+	if strings.TrimSpace(a.Segment) != "" {
+		kinds++
+	}
+	if kinds != 1 {
+		return errors.New("exactly one of all, user_ids or segment is required")
+	}
+	return nil
+}
+
+// Kind names the audience for logs
+func (a Audience) Kind() string {
+	switch {
+	case a.All:
+		return "all"
+	case len(a.UserIDs) > 0:
+		return "user_ids"
+	default:
+		return "segment"
+	}
+}
+
+// BroadcastEvent is published to announce something (a new feature, a
+// maintenance window) to many users at once
+type BroadcastEvent struct {
+	EventID     string   `json:"event_id"`     // Optional, for tracing
+	BroadcastID string   `json:"broadcast_id"` // Stable id, used to revoke the broadcast
+	Title       string   `json:"title"`        // Optional title, "Announcement" when empty
+	Message     string   `json:"message"`      // Announcement text
+	Audience    Audience `json:"audience"`     // Who receives it
+	CreatedAt   int64    `json:"created_at"`   // Unix timestamp
+}
+
+// CheckpointID identifies the broadcast job
+func (e *BroadcastEvent) CheckpointID() string {
+	return "broadcast#" + e.BroadcastID
+}
+
+// BroadcastRevocationID is kept apart from the broadcast's own checkpoint
+// so a running broadcast saving progress can't overwrite a revocation
+func BroadcastRevocationID(broadcastID string) string {
+	return "broadcast-revoked#" + broadcastID
+}
+
+// BroadcastRevokedEvent withdraws a broadcast: writes stop and its
+// notifications are deleted
+type BroadcastRevokedEvent struct {
+	EventID     string `json:"event_id"`     // Optional, for tracing
+	BroadcastID string `json:"broadcast_id"` // Broadcast to withdraw
+}
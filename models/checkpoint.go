@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Checkpoint records the progress of a long-running job (purging a user,
+// fanning out to followers, ...) so it can resume where it stopped
+type Checkpoint struct {
+	Id        string         `dynamodbav:"id" json:"id"`                 // Job id, e.g. "purge#user-123"
+	Phase     string         `dynamodbav:"phase" json:"phase"`           // Current step of the job
+	Cursor    string         `dynamodbav:"cursor" json:"cursor"`         // Where to continue the phase, empty at its start
+	Counts    map[string]int `dynamodbav:"counts" json:"counts"`         // Items handled so far, by kind
+	Done      bool           `dynamodbav:"done" json:"done"`             // Job finished
+	UpdatedAt time.Time      `dynamodbav:"updated_at" json:"updated_at"` // Last saved
+	ExpiresAt int64          `dynamodbav:"expires_at" json:"expires_at"` // Unix time DynamoDB TTL deletes it after
+}
+
+// Add increments a count
+func (c *Checkpoint) Add(kind string, n int) {
+	if c.Counts == nil {
+		c.Counts = make(map[string]int)
+	}
+	c.Counts[kind] += n
+}
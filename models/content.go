@@ -0,0 +1,53 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContentCreatedEvent is published when a user creates a post or comment.
+// Users @mentioned in the body get a mention notification.
+type ContentCreatedEvent struct {
+	EventID       string `json:"event_id"`       // Optional, for tracing
+	AuthorID      string `json:"author_id"`      // User who wrote the content
+	AuthorName    string `json:"author_name"`    // Author's display name
+	AuthorPicture string `json:"author_picture"` // Author's profile picture
+	AuthorBio     string `json:"author_bio"`     // Author's bio
+	ResourceType  string `json:"resource_type"`  // POST or COMMENT
+	ResourceID    string `json:"resource_id"`    // The new post or comment
+	Body          string `json:"body"`           // Text to find mentions in
+	CreatedAt     int64  `json:"created_at"`     // Unix timestamp
+}
+
+// mentionPattern matches @handle not preceded by a word character, so
+// email addresses aren't mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\w{1,30})`)
+
+// Mentions returns the distinct @handles in the body, lowercased, in the
+// order they first appear
+func (e *ContentCreatedEvent) Mentions() []string {
+	seen := make(map[string]bool)
+	var handles []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(e.Body, -1) {
+		handle := strings.ToLower(match[1])
+		if !seen[handle] {
+			seen[handle] = true
+			handles = append(handles, handle)
+		}
+	}
+	return handles
+}
+
+// excerptLength is the most runes of the body kept as a mention's excerpt
+const excerptLength = 140
+
+// Excerpt returns the start of the body for the notification preview
+func (e *ContentCreatedEvent) Excerpt() string {
+	body := strings.Join(strings.Fields(e.Body), " ")
+	if utf8.RuneCountInString(body) <= excerptLength {
+		return body
+	}
+	runes := []rune(body)
+	return strings.TrimSpace(string(runes[:excerptLength-1])) + "…"
+}
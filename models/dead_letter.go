@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Dead letter reasons
+const (
+	DeadLetterInvalid = "invalid" // Undecodable or failed validation
+	DeadLetterFailed  = "failed"  // Valid but could not be stored
+)
+
+// DeadLetter is an event the worker could not process, kept so it can be
+// inspected and replayed. Stored in the dead letter table, keyed by id.
+type DeadLetter struct {
+	Id       string              `dynamodbav:"id" json:"id"`
+	Subject  string              `dynamodbav:"subject" json:"subject"`                     // Subject the event was received on
+	Headers  map[string][]string `dynamodbav:"headers,omitempty" json:"headers,omitempty"` // Original NATS headers
+	Data     []byte              `dynamodbav:"data" json:"data"`                           // Original payload
+	Reason   string              `dynamodbav:"reason" json:"reason"`                       // invalid or failed
+	Error    string              `dynamodbav:"error" json:"error"`                         // Why processing failed
+	Replays  int                 `dynamodbav:"replays" json:"replays"`                     // Times the event was replayed
+	FailedAt time.Time           `dynamodbav:"failed_at" json:"failed_at"`
+}
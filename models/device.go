@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Device platforms
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+// Device is a mobile device registered for OS push notifications
+// Stored in the devices table, keyed by owner and token
+type Device struct {
+	Owner     string    `dynamodbav:"owner" json:"owner"`           // User the device belongs to
+	Token     string    `dynamodbav:"token" json:"token"`           // APNs device token or FCM registration token
+	Platform  string    `dynamodbav:"platform" json:"platform"`     // ios or android
+	Locale    string    `dynamodbav:"locale" json:"locale"`         // Preferred language (en, fr, ...)
+	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"` // When the device was registered
+}
@@ -1,18 +1,27 @@
 package models
 
+import (
+	"strconv"
+	"time"
+)
+
 // NotificationEvent represents an event published to NATS
 // that should trigger a notification creation
 type NotificationEvent struct {
-	Owner        string `json:"owner"`         // User who receives the notification
-	TriggerUser  string `json:"trigger_user"`  // User who triggered the action
-	Username     string `json:"username"`      // Trigger user's display name
-	UserPicture  string `json:"user_picture"`  // Trigger user's profile picture
-	UserBio      string `json:"user_bio"`      // Trigger user's bio
-	Action       int    `json:"action"`        // Action type (1=like post, 2=like comment, etc.)
-	ResourceType string `json:"resource_type"` // Type of resource (POST, COMMENT, etc.)
-	ResourceID   string `json:"resource_id"`   // ID of the resource
-	Excerpt      string `json:"excerpt"`       // Optional excerpt/preview text
-	CreatedAt    int64  `json:"created_at"`    // Unix timestamp
+	SchemaVersion int    `json:"schema_version"` // Payload schema version (see the schema package), 1 when missing
+	EventID       string `json:"event_id"`       // Optional unique id for idempotency (defaults to the Nats-Msg-Id header)
+	Owner         string `json:"owner"`          // User who receives the notification
+	TriggerUser   string `json:"trigger_user"`   // User who triggered the action
+	Username      string `json:"username"`       // Trigger user's display name
+	UserPicture   string `json:"user_picture"`   // Trigger user's profile picture
+	UserBio       string `json:"user_bio"`       // Trigger user's bio
+	Action        int    `json:"action"`         // Action type (1=like post, 2=like comment, etc.)
+	ResourceType  string `json:"resource_type"`  // Type of resource (POST, COMMENT, etc.)
+	ResourceID    string `json:"resource_id"`    // ID of the resource
+	SourceID      string `json:"source_id"`      // ID of the reply/comment that caused a reply or mention
+	Excerpt       string `json:"excerpt"`        // Optional excerpt/preview text
+	Locale        string `json:"locale"`         // Optional owner locale for rendered text (en, fr, ...)
+	CreatedAt     int64  `json:"created_at"`     // Unix timestamp
 }
 
 // Action types
@@ -23,11 +32,326 @@ const (
 	ActionReplyComment = 4
 	ActionMention      = 5
 	ActionFollow       = 6
+	ActionSystem       = 7
+	ActionNewPost      = 8
+	ActionBroadcast    = 9
+)
+
+// DedupPolicy decides which notifications for the same action collapse into one
+type DedupPolicy int
+
+const (
+	// DedupForever keeps one notification per trigger user, resource and action
+	DedupForever DedupPolicy = iota
+	// DedupBySource keeps one notification per reply/comment (SourceID)
+	DedupBySource
+	// DedupNever creates a notification for every event
+	DedupNever
+)
+
+// String returns the policy name used in docs
+func (p DedupPolicy) String() string {
+	switch p {
+	case DedupBySource:
+		return "by source"
+	case DedupNever:
+		return "never"
+	default:
+		return "forever"
+	}
+}
+
+// AggregationPolicy decides how notifications are grouped when rendered
+// together, e.g. in digests ("Jo and 3 others liked your post")
+type AggregationPolicy int
+
+const (
+	// AggregateByResource groups notifications for the same action and resource
+	AggregateByResource AggregationPolicy = iota
+	// AggregateNone shows every notification on its own
+	AggregateNone
 )
 
+// String returns the policy name used in docs
+func (p AggregationPolicy) String() string {
+	if p == AggregateNone {
+		return "none"
+	}
+	return "by resource"
+}
+
+// ChannelPreference says which channels deliver an action by default
+type ChannelPreference struct {
+	Push  bool // Web and mobile push
+	Email bool // Email digests
+}
+
+// ActionType describes an action: how events are validated, deduplicated,
+// grouped, delivered and rendered
+type ActionType struct {
+	ID                int
+	Name              string   // Stable name used in docs and logs
+	Description       string   // One line for docs
+	Subject           string   // Subject producers publish the event on
+	ResourceTypes     []string // Allowed resource_type values
+	RequiredFields    []string // Event fields that must be set (JSON names)
+	Dedup             DedupPolicy
+	Aggregation       AggregationPolicy
+	DefaultPreference ChannelPreference
+	TemplateKey       string        // Message catalog key
+	Retention         time.Duration // How long notifications are kept, 0 keeps them until dismissed
+	FanOut            bool          // Only written by fan-outs and broadcasts, never by a per-owner event
+}
+
+// AllowsResourceType returns true if the action applies to a resource type
+func (a ActionType) AllowsResourceType(resourceType string) bool {
+	for _, allowed := range a.ResourceTypes {
+		if allowed == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// day is the unit retention policies are written in
+const day = 24 * time.Hour
+
+// userEventFields are required by every action triggered by a user
+var userEventFields = []string{"owner", "trigger_user", "resource_type", "resource_id"}
+
+// actionTypes is the action registry, in ID order
+var actionTypes = []ActionType{
+	{
+		ID:                ActionLikePost,
+		Name:              "like_post",
+		Description:       "A user liked the owner's post",
+		Subject:           "notifications.post.like",
+		ResourceTypes:     []string{ResourceTypePost},
+		RequiredFields:    userEventFields,
+		Dedup:             DedupForever,
+		Aggregation:       AggregateByResource,
+		DefaultPreference: ChannelPreference{Push: true, Email: true},
+		TemplateKey:       "like_post",
+		Retention:         30 * day,
+	},
+	{
+		ID:                ActionLikeComment,
+		Name:              "like_comment",
+		Description:       "A user liked the owner's comment",
+		Subject:           "notifications.comment.like",
+		ResourceTypes:     []string{ResourceTypeComment},
+		RequiredFields:    userEventFields,
+		Dedup:             DedupForever,
+		Aggregation:       AggregateByResource,
+		DefaultPreference: ChannelPreference{Push: true, Email: true},
+		TemplateKey:       "like_comment",
+		Retention:         30 * day,
+	},
+	{
+		ID:                ActionReplyPost,
+		Name:              "reply_post",
+		Description:       "A user replied to the owner's post",
+		Subject:           "notifications.reply.post",
+		ResourceTypes:     []string{ResourceTypePost},
+		RequiredFields:    userEventFields,
+		Dedup:             DedupBySource,
+		Aggregation:       AggregateByResource,
+		DefaultPreference: ChannelPreference{Push: true, Email: true},
+		TemplateKey:       "reply_post",
+		Retention:         90 * day,
+	},
+	{
+		ID:                ActionReplyComment,
+		Name:              "reply_comment",
+		Description:       "A user replied to the owner's comment",
+		Subject:           "notifications.reply.comment",
+		ResourceTypes:     []string{ResourceTypeComment},
+		RequiredFields:    userEventFields,
+		Dedup:             DedupBySource,
+		Aggregation:       AggregateByResource,
+		DefaultPreference: ChannelPreference{Push: true, Email: true},
+		TemplateKey:       "reply_comment",
+		Retention:         90 * day,
+	},
+	{
+		ID:                ActionMention,
+		Name:              "mention",
+		Description:       "A user mentioned the owner in a post or comment",
+		Subject:           "notifications.mention",
+		ResourceTypes:     []string{ResourceTypePost, ResourceTypeComment},
+		RequiredFields:    userEventFields,
+		Dedup:             DedupBySource,
+		Aggregation:       AggregateByResource,
+		DefaultPreference: ChannelPreference{Push: true, Email: true},
+		TemplateKey:       "mention",
+		Retention:         90 * day,
+	},
+	{
+		ID:                ActionFollow,
+		Name:              "follow",
+		Description:       "A user started following the owner",
+		Subject:           "notifications.user.follow",
+		ResourceTypes:     []string{ResourceTypeUser},
+		RequiredFields:    userEventFields,
+		Dedup:             DedupForever,
+		Aggregation:       AggregateByResource,
+		DefaultPreference: ChannelPreference{Push: true, Email: true},
+		TemplateKey:       "follow",
+		Retention:         90 * day,
+	},
+	{
+		ID:                ActionSystem,
+		Name:              "system",
+		Description:       "A notice from Exobook; the excerpt is the message",
+		Subject:           "notifications.system",
+		ResourceTypes:     []string{ResourceTypeSystem},
+		RequiredFields:    []string{"owner", "resource_type", "resource_id", "excerpt"},
+		Dedup:             DedupNever,
+		Aggregation:       AggregateNone,
+		DefaultPreference: ChannelPreference{Push: true, Email: false},
+		TemplateKey:       "system",
+		Retention:         0, // Until dismissed
+	},
+	{
+		ID:                ActionNewPost,
+		Name:              "new_post",
+		Description:       "A user the owner follows published a post; fanned out to every follower",
+		Subject:           "notifications.fanout.post",
+		ResourceTypes:     []string{ResourceTypePost},
+		RequiredFields:    []string{"trigger_user", "resource_type", "resource_id"},
+		Dedup:             DedupForever,
+		Aggregation:       AggregateNone,
+		DefaultPreference: ChannelPreference{Push: false, Email: true},
+		TemplateKey:       "new_post",
+		Retention:         30 * day,
+		FanOut:            true,
+	},
+	{
+		ID:                ActionBroadcast,
+		Name:              "broadcast",
+		Description:       "An announcement from Exobook to all users or a segment; resource_id is the broadcast id",
+		Subject:           "notifications.system.broadcast",
+		ResourceTypes:     []string{ResourceTypeBroadcast},
+		RequiredFields:    []string{"resource_type", "resource_id", "excerpt"},
+		Dedup:             DedupForever,
+		Aggregation:       AggregateNone,
+		DefaultPreference: ChannelPreference{Push: false, Email: false},
+		TemplateKey:       "broadcast",
+		Retention:         30 * day,
+		FanOut:            true,
+	},
+}
+
+// ActionTypes returns every registered action, in ID order
+func ActionTypes() []ActionType {
+	return append([]ActionType(nil), actionTypes...)
+}
+
+// LookupAction returns the registered action with the given ID
+func LookupAction(id int) (ActionType, bool) {
+	for _, action := range actionTypes {
+		if action.ID == id {
+			return action, true
+		}
+	}
+	return ActionType{}, false
+}
+
+// LookupActionByName returns the registered action with the given name
+func LookupActionByName(name string) (ActionType, bool) {
+	for _, action := range actionTypes {
+		if action.Name == name {
+			return action, true
+		}
+	}
+	return ActionType{}, false
+}
+
+// ActionSubject returns the subject an action's events are published on,
+// empty for unknown actions
+func ActionSubject(action int) string {
+	a, _ := LookupAction(action)
+	return a.Subject
+}
+
+// DedupPolicyFor returns the dedup policy of an action.
+// Unknown actions dedup forever.
+func DedupPolicyFor(action int) DedupPolicy {
+	if a, ok := LookupAction(action); ok {
+		return a.Dedup
+	}
+	return DedupForever
+}
+
+// DefaultPreferenceFor returns the channels that deliver an action by
+// default. Unknown actions are delivered everywhere.
+func DefaultPreferenceFor(action int) ChannelPreference {
+	if a, ok := LookupAction(action); ok {
+		return a.DefaultPreference
+	}
+	return ChannelPreference{Push: true, Email: true}
+}
+
+// RetentionFor returns how long notifications for an action are kept.
+// Unknown actions are kept until dismissed.
+func RetentionFor(action int) time.Duration {
+	if a, ok := LookupAction(action); ok {
+		return a.Retention
+	}
+	return 0
+}
+
+// Field returns an event field by its JSON name, formatted as a string.
+// Zero numbers come back empty so they count as missing.
+func (e *NotificationEvent) Field(name string) string {
+	switch name {
+	case "schema_version":
+		if e.SchemaVersion == 0 {
+			return ""
+		}
+		return strconv.Itoa(e.SchemaVersion)
+	case "event_id":
+		return e.EventID
+	case "owner":
+		return e.Owner
+	case "trigger_user":
+		return e.TriggerUser
+	case "username":
+		return e.Username
+	case "user_picture":
+		return e.UserPicture
+	case "user_bio":
+		return e.UserBio
+	case "action":
+		if e.Action == 0 {
+			return ""
+		}
+		return strconv.Itoa(e.Action)
+	case "resource_type":
+		return e.ResourceType
+	case "resource_id":
+		return e.ResourceID
+	case "source_id":
+		return e.SourceID
+	case "excerpt":
+		return e.Excerpt
+	case "locale":
+		return e.Locale
+	case "created_at":
+		if e.CreatedAt == 0 {
+			return ""
+		}
+		return strconv.FormatInt(e.CreatedAt, 10)
+	default:
+		return ""
+	}
+}
+
 // Resource types
 const (
-	ResourceTypePost    = "POST"
-	ResourceTypeComment = "COMMENT"
-	ResourceTypeUser    = "USER"
+	ResourceTypePost      = "POST"
+	ResourceTypeComment   = "COMMENT"
+	ResourceTypeUser      = "USER"
+	ResourceTypeSystem    = "SYSTEM"
+	ResourceTypeBroadcast = "BROADCAST"
 )
@@ -0,0 +1,21 @@
+package models
+
+// FanOutEvent is published when a user creates a post. Instead of one
+// owner, every follower of the trigger user is notified.
+type FanOutEvent struct {
+	EventID      string `json:"event_id"`      // Optional, for tracing
+	TriggerUser  string `json:"trigger_user"`  // User who posted
+	Username     string `json:"username"`      // Trigger user's display name
+	UserPicture  string `json:"user_picture"`  // Trigger user's profile picture
+	UserBio      string `json:"user_bio"`      // Trigger user's bio
+	ResourceType string `json:"resource_type"` // POST
+	ResourceID   string `json:"resource_id"`   // The new post
+	Excerpt      string `json:"excerpt"`       // Optional preview text
+	CreatedAt    int64  `json:"created_at"`    // Unix timestamp
+}
+
+// CheckpointID identifies the fan-out job. It depends on the post, not
+// the event id, so a re-published event resumes or skips the same job.
+func (e *FanOutEvent) CheckpointID() string {
+	return "fanout#" + e.TriggerUser + "#" + e.ResourceID
+}
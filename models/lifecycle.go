@@ -0,0 +1,75 @@
+package models
+
+// ResourceDeletedEvent is published when a post or comment is deleted.
+// Every notification about it, or caused by it, is removed.
+type ResourceDeletedEvent struct {
+	EventID      string `json:"event_id"`      // Optional, for tracing
+	ResourceType string `json:"resource_type"` // Optional, narrows resource_id matches (POST, COMMENT, ...)
+	ResourceID   string `json:"resource_id"`   // Deleted post or comment
+}
+
+// Reasons notifications are removed, sent to clients in notification-removed
+const (
+	RemovedResourceDeleted = "resource_deleted"
+	RemovedUserDeleted     = "user_deleted"
+	RemovedRevoked         = "revoked"
+	RemovedDismissed       = "dismissed"
+)
+
+// NotificationDismissedEvent is published when a user dismisses one of
+// their notifications. It's the only way notifications kept until
+// dismissed, such as system notices, go away.
+type NotificationDismissedEvent struct {
+	EventID string `json:"event_id"` // Optional, for tracing
+	Owner   string `json:"owner"`    // User the notification belongs to
+	ID      string `json:"id"`       // Dismissed notification
+}
+
+// UserDeletedEvent is published when a user deletes their account.
+// Notifications they own or triggered, and their per-user records, are purged.
+type UserDeletedEvent struct {
+	EventID string `json:"event_id"` // Optional, for tracing
+	UserID  string `json:"user_id"`  // Deleted user
+}
+
+// ProfileUpdate is a change to a user's public profile. Fields left out
+// are unchanged; an empty string clears the field.
+type ProfileUpdate struct {
+	UserName *string `json:"username,omitempty"` // New display name
+	UserPic  *string `json:"userpic,omitempty"`  // New picture URL
+	UserBio  *string `json:"userbio,omitempty"`  // New bio
+}
+
+// Empty reports whether the update changes nothing
+func (p ProfileUpdate) Empty() bool {
+	return p.UserName == nil && p.UserPic == nil && p.UserBio == nil
+}
+
+// Apply copies the update onto a notification's trigger user snapshot
+// and reports whether anything changed
+func (p ProfileUpdate) Apply(n *Notification) bool {
+	changed := false
+	for _, field := range []struct {
+		value  *string
+		target *string
+	}{
+		{p.UserName, &n.UserName},
+		{p.UserPic, &n.UserPic},
+		{p.UserBio, &n.UserBio},
+	} {
+		if field.value != nil && *field.value != *field.target {
+			*field.target = *field.value
+			changed = true
+		}
+	}
+	return changed
+}
+
+// ProfileUpdatedEvent is published when a user changes their name,
+// picture or bio. Notifications they triggered copy those fields at
+// creation, so the snapshots are refreshed.
+type ProfileUpdatedEvent struct {
+	EventID string `json:"event_id"` // Optional, for tracing
+	UserID  string `json:"user_id"`  // User whose profile changed
+	ProfileUpdate
+}
@@ -17,15 +17,64 @@ type Notification struct {
 	Action       int       `dynamodbav:"action" json:"action"`             // Action type
 	ResourceType string    `dynamodbav:"resource_type" json:"resource_type"` // POST, COMMENT, etc.
 	ResourceId   string    `dynamodbav:"resource_id" json:"resource_id"`   // ID of the resource
+	SourceId     string    `dynamodbav:"source_id,omitempty" json:"source_id,omitempty"` // Reply/comment that caused it
 	Excerpt      string    `dynamodbav:"excerpt" json:"excerpt"`           // Optional preview text
+	Title        string    `dynamodbav:"title" json:"title"`               // Rendered title
+	Body         string    `dynamodbav:"body" json:"body"`                 // Rendered body
+	Locale       string    `dynamodbav:"locale" json:"locale"`             // Locale title and body were rendered in
 	ActionKey    string    `dynamodbav:"action_key" json:"action_key"`     // Composite key for deduplication
+	EventId      string    `dynamodbav:"event_id,omitempty" json:"event_id,omitempty"` // Id of the event that created it
 	ReadStatus   bool      `dynamodbav:"read_status" json:"read_status"`   // Read status
 	CreatedAt    time.Time `dynamodbav:"created_at" json:"created_at"`     // Time (stored as String in DynamoDB)
+	ExpiresAt    int64     `dynamodbav:"expires_at,omitempty" json:"expires_at,omitempty"` // Unix time DynamoDB TTL deletes it after, 0 never
 }
 
-// GenerateActionKey creates a unique key for deduplication
-// Format: {userid}#{resource_id}#{action}#{timestamp}
-// The timestamp is always set to zero time for deduplication purposes
+// SetExpiry sets ExpiresAt from the action's retention policy, counted
+// from creation. Actions kept until dismissed get no expiry.
+func (n *Notification) SetExpiry() {
+	retention := RetentionFor(n.Action)
+	if retention <= 0 {
+		n.ExpiresAt = 0
+		return
+	}
+	n.ExpiresAt = n.CreatedAt.Add(retention).Unix()
+}
+
+// Expired reports whether the notification is past its expiry. DynamoDB
+// deletes expired items within a few days, so reads must skip them.
+func (n *Notification) Expired(now time.Time) bool {
+	return n.ExpiresAt > 0 && n.ExpiresAt <= now.Unix()
+}
+
+// zeroTimeKey is the timestamp part of keys that dedup forever, kept for
+// compatibility with keys written by dynamodb-go-api
+const zeroTimeKey = "0001-01-01T00:00:00Z"
+
+// GenerateActionKey creates a key for deduplication
+// Format: {userid}#{resource_id}#{action}#{discriminator}
+// The discriminator follows the action's dedup policy: zero time for
+// actions that dedup forever (likes, follows), the reply/comment id for
+// replies and mentions, and the notification id for actions that never
+// dedup. Replies without a source id fall back to the event id, then the
+// creation time.
 func (n *Notification) GenerateActionKey() string {
-	return fmt.Sprintf("%s#%s#%d#%s", n.UserId, n.ResourceId, n.Action, "0001-01-01T00:00:00Z")
+	return fmt.Sprintf("%s#%s#%d#%s", n.UserId, n.ResourceId, n.Action, n.dedupDiscriminator())
+}
+
+// dedupDiscriminator returns the last part of the action key
+func (n *Notification) dedupDiscriminator() string {
+	switch DedupPolicyFor(n.Action) {
+	case DedupBySource:
+		if n.SourceId != "" {
+			return n.SourceId
+		}
+		if n.EventId != "" {
+			return n.EventId
+		}
+		return n.CreatedAt.UTC().Format(time.RFC3339)
+	case DedupNever:
+		return n.Id
+	default:
+		return zeroTimeKey
+	}
 }
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// DigestFrequency controls how often a user receives an email digest
+type DigestFrequency string
+
+// Digest frequencies
+const (
+	DigestOff    DigestFrequency = "off"
+	DigestDaily  DigestFrequency = "daily"
+	DigestWeekly DigestFrequency = "weekly"
+)
+
+// Period returns how long to wait between two digests.
+// It returns zero for DigestOff and unknown values.
+func (f DigestFrequency) Period() time.Duration {
+	switch f {
+	case DigestDaily:
+		return 24 * time.Hour
+	case DigestWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// NotificationPreference holds a user's delivery preferences
+// Stored in the notification preferences table, keyed by owner
+type NotificationPreference struct {
+	Owner            string          `dynamodbav:"owner" json:"owner"`                             // User the preferences belong to
+	Email            string          `dynamodbav:"email" json:"email"`                             // Address digests are sent to
+	Locale           string          `dynamodbav:"locale" json:"locale"`                           // Language for digest text (en, fr, ...)
+	DigestFrequency  DigestFrequency `dynamodbav:"digest_frequency" json:"digest_frequency"`       // off, daily or weekly
+	LastDigestSentAt time.Time       `dynamodbav:"last_digest_sent_at" json:"last_digest_sent_at"` // Time of the last digest
+}
+
+// DigestDue returns true if a digest should be sent at the given time
+func (p *NotificationPreference) DigestDue(now time.Time) bool {
+	period := p.DigestFrequency.Period()
+	if period == 0 || p.Email == "" {
+		return false
+	}
+	return !now.Before(p.LastDigestSentAt.Add(period))
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// UserArchive is everything the notification system holds about a user,
+// exported for privacy requests
+type UserArchive struct {
+	UserID            string                  `json:"user_id"`
+	ExportedAt        time.Time               `json:"exported_at"`
+	Notifications     []Notification          `json:"notifications"`                // Notifications the user received
+	Triggered         []Notification          `json:"triggered"`                    // Notifications others received about the user's actions
+	Preferences       *NotificationPreference `json:"preferences,omitempty"`        // Digest preferences, if any
+	Devices           []Device                `json:"devices,omitempty"`            // Mobile devices
+	PushSubscriptions []PushSubscription      `json:"push_subscriptions,omitempty"` // Browser push subscriptions
+	WebhookDeliveries []WebhookDelivery       `json:"webhook_deliveries,omitempty"` // Logged webhook deliveries of the user's notifications
+	DeadLetters       []DeadLetter            `json:"dead_letters,omitempty"`       // Failed events that mention the user
+}
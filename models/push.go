@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// PushSubscription is a browser Web Push subscription for a user
+// Stored in the push subscriptions table, keyed by owner and endpoint
+type PushSubscription struct {
+	Owner     string    `dynamodbav:"owner" json:"owner"`           // User the browser belongs to
+	Endpoint  string    `dynamodbav:"endpoint" json:"endpoint"`     // Push service URL from PushSubscription.endpoint
+	P256dh    string    `dynamodbav:"p256dh" json:"p256dh"`         // Browser public key (base64url)
+	Auth      string    `dynamodbav:"auth" json:"auth"`             // Browser auth secret (base64url)
+	CreatedAt time.Time `dynamodbav:"created_at" json:"created_at"` // When the subscription was registered
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// WebhookEndpoint is a partner or internal URL that receives signed callbacks
+// Stored in the webhooks table, keyed by id
+type WebhookEndpoint struct {
+	Id                  string    `dynamodbav:"id" json:"id"`
+	URL                 string    `dynamodbav:"url" json:"url"`                                   // Callback URL
+	Secret              string    `dynamodbav:"secret" json:"-"`                                  // HMAC-SHA256 signing secret
+	Actions             []int     `dynamodbav:"actions,omitempty" json:"actions,omitempty"`       // Only send these actions (all when empty)
+	Disabled            bool      `dynamodbav:"disabled" json:"disabled"`                         // Set after too many failures
+	ConsecutiveFailures int       `dynamodbav:"consecutive_failures" json:"consecutive_failures"` // Reset on success
+	CreatedAt           time.Time `dynamodbav:"created_at" json:"created_at"`
+}
+
+// Accepts returns true if the endpoint wants notifications for the action
+func (e *WebhookEndpoint) Accepts(action int) bool {
+	if len(e.Actions) == 0 {
+		return true
+	}
+	for _, a := range e.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook delivery statuses
+const (
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is an entry in the webhook delivery log
+// Stored in the webhook deliveries table, keyed by id
+type WebhookDelivery struct {
+	Id             string    `dynamodbav:"id" json:"id"`
+	EndpointId     string    `dynamodbav:"endpoint_id" json:"endpoint_id"`
+	NotificationId string    `dynamodbav:"notification_id" json:"notification_id"`
+	Owner          string    `dynamodbav:"owner,omitempty" json:"owner,omitempty"`   // Notification owner, for privacy purges
+	UserId         string    `dynamodbav:"userid,omitempty" json:"userid,omitempty"` // User who triggered the notification
+	Payload        string    `dynamodbav:"payload" json:"payload"`                   // Exact body that was signed and sent
+	Status         string    `dynamodbav:"status" json:"status"`                     // succeeded or failed
+	Attempts       int       `dynamodbav:"attempts" json:"attempts"`                 // Number of HTTP attempts
+	ResponseCode   int       `dynamodbav:"response_code" json:"response_code"`       // Last HTTP status (0 on network error)
+	Error          string    `dynamodbav:"error,omitempty" json:"error,omitempty"`
+	CreatedAt      time.Time `dynamodbav:"created_at" json:"created_at"`
+	LastAttemptAt  time.Time `dynamodbav:"last_attempt_at" json:"last_attempt_at"`
+}
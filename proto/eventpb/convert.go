@@ -0,0 +1,43 @@
+package eventpb
+
+import "github.com/aslotsu/notification-worker/models"
+
+// ToModel converts a decoded message to the worker's event struct
+func (e *NotificationEvent) ToModel() models.NotificationEvent {
+	return models.NotificationEvent{
+		SchemaVersion: int(e.GetSchemaVersion()),
+		EventID:       e.GetEventId(),
+		Owner:         e.GetOwner(),
+		TriggerUser:   e.GetTriggerUser(),
+		Username:      e.GetUsername(),
+		UserPicture:   e.GetUserPicture(),
+		UserBio:       e.GetUserBio(),
+		Action:        int(e.GetAction()),
+		ResourceType:  e.GetResourceType(),
+		ResourceID:    e.GetResourceId(),
+		SourceID:      e.GetSourceId(),
+		Excerpt:       e.GetExcerpt(),
+		Locale:        e.GetLocale(),
+		CreatedAt:     e.GetCreatedAt(),
+	}
+}
+
+// FromModel converts an event struct to a message, for Go producers
+func FromModel(event models.NotificationEvent) *NotificationEvent {
+	return &NotificationEvent{
+		SchemaVersion: int32(event.SchemaVersion),
+		EventId:       event.EventID,
+		Owner:         event.Owner,
+		TriggerUser:   event.TriggerUser,
+		Username:      event.Username,
+		UserPicture:   event.UserPicture,
+		UserBio:       event.UserBio,
+		Action:        int32(event.Action),
+		ResourceType:  event.ResourceType,
+		ResourceId:    event.ResourceID,
+		SourceId:      event.SourceID,
+		Excerpt:       event.Excerpt,
+		Locale:        event.Locale,
+		CreatedAt:     event.CreatedAt,
+	}
+}
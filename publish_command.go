@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aslotsu/notification-worker/config"
+	"github.com/aslotsu/notification-worker/handlers"
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/aslotsu/notification-worker/proto/eventpb"
+	"github.com/aslotsu/notification-worker/schema"
+	"github.com/aslotsu/notification-worker/tracing"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"google.golang.org/protobuf/proto"
+)
+
+// runPublishCommand builds an event from a JSON file and/or flags and
+// publishes it. Flags override fields read from the file.
+func runPublishCommand(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	file := fs.String("file", "", "read the event from a JSON file (- for stdin)")
+	subject := fs.String("subject", "", "subject to publish on (default: the action's subject)")
+	encoding := fs.String("encoding", "json", "payload encoding: json or protobuf")
+	action := fs.String("action", "", "action id or name, e.g. 1 or like_post")
+	owner := fs.String("owner", "", "user receiving the notification")
+	triggerUser := fs.String("trigger-user", "", "user who triggered the action")
+	username := fs.String("username", "", "trigger user's display name")
+	userPicture := fs.String("user-picture", "", "trigger user's picture URL")
+	userBio := fs.String("user-bio", "", "trigger user's bio")
+	resourceType := fs.String("resource-type", "", "resource type (default: the action's only resource type)")
+	resourceID := fs.String("resource-id", "", "resource id")
+	sourceID := fs.String("source-id", "", "reply/comment id for replies and mentions")
+	excerpt := fs.String("excerpt", "", "preview text")
+	locale := fs.String("locale", "", "owner locale")
+	eventID := fs.String("event-id", "", "event id (default: random)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var event models.NotificationEvent
+	if *file != "" {
+		if err := readEventFile(*file, &event); err != nil {
+			return err
+		}
+	}
+
+	// Flags override the file
+	setIf(&event.Owner, *owner)
+	setIf(&event.TriggerUser, *triggerUser)
+	setIf(&event.Username, *username)
+	setIf(&event.UserPicture, *userPicture)
+	setIf(&event.UserBio, *userBio)
+	setIf(&event.ResourceType, *resourceType)
+	setIf(&event.ResourceID, *resourceID)
+	setIf(&event.SourceID, *sourceID)
+	setIf(&event.Excerpt, *excerpt)
+	setIf(&event.Locale, *locale)
+	setIf(&event.EventID, *eventID)
+
+	if *action != "" {
+		actionType, err := parseAction(*action)
+		if err != nil {
+			return err
+		}
+		event.Action = actionType.ID
+	}
+
+	actionType, ok := models.LookupAction(event.Action)
+	if !ok {
+		return fmt.Errorf("unknown action %d, use -action with one of: %s", event.Action, actionNames())
+	}
+
+	if event.ResourceType == "" && len(actionType.ResourceTypes) == 1 {
+		event.ResourceType = actionType.ResourceTypes[0]
+	}
+	if event.EventID == "" {
+		event.EventID = uuid.New().String()
+	}
+	if event.CreatedAt == 0 {
+		event.CreatedAt = time.Now().Unix()
+	}
+	event.SchemaVersion = schema.CurrentVersion
+
+	if *subject == "" {
+		*subject = actionType.Subject
+	}
+
+	msg := nats.NewMsg(*subject)
+	msg.Header.Set(nats.MsgIdHdr, event.EventID)
+
+	switch *encoding {
+	case "json":
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		// Catch mistakes here rather than in the worker's logs
+		if _, err := schema.Decode(data, time.Now()); err != nil {
+			return err
+		}
+		msg.Data = data
+		msg.Header.Set("Content-Type", handlers.ContentTypeJSON)
+	case "protobuf":
+		data, err := proto.Marshal(eventpb.FromModel(event))
+		if err != nil {
+			return err
+		}
+		msg.Data = data
+		msg.Header.Set("Content-Type", handlers.ContentTypeProtobuf)
+	default:
+		return fmt.Errorf("unknown encoding %q (json or protobuf)", *encoding)
+	}
+
+	nc, err := connectNATS(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	tracing.Inject(context.Background(), msg)
+	if err := nc.PublishMsg(msg); err != nil {
+		return fmt.Errorf("failed to publish: %v", err)
+	}
+	if err := nc.Flush(); err != nil {
+		return fmt.Errorf("failed to flush: %v", err)
+	}
+
+	fmt.Printf("published %s event %s to %s (%d bytes, %s)\n", actionType.Name, event.EventID, *subject, len(msg.Data), *encoding)
+	fmt.Printf("  owner: %s, trigger user: %s, resource: %s %s\n", event.Owner, event.TriggerUser, event.ResourceType, event.ResourceID)
+	return nil
+}
+
+// readEventFile decodes a JSON event from a file or stdin
+func readEventFile(path string, event *models.NotificationEvent) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, event); err != nil {
+		return fmt.Errorf("invalid event file: %v", err)
+	}
+	return nil
+}
+
+// parseAction accepts an action id or name
+func parseAction(value string) (models.ActionType, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		if action, ok := models.LookupAction(id); ok {
+			return action, nil
+		}
+	} else if action, ok := models.LookupActionByName(value); ok {
+		return action, nil
+	}
+	return models.ActionType{}, fmt.Errorf("unknown action %q, expected one of: %s", value, actionNames())
+}
+
+// actionNames lists registered action names for error messages
+func actionNames() string {
+	var names []string
+	for _, action := range models.ActionTypes() {
+		names = append(names, fmt.Sprintf("%d=%s", action.ID, action.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// setIf overwrites dst when value is not empty
+func setIf(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aslotsu/notification-worker/config"
+	"github.com/aslotsu/notification-worker/handlers"
+	"github.com/aslotsu/notification-worker/models"
+)
+
+// newNotificationService creates the notification service for CLI
+// commands, reading NOTIFICATIONS_FILE when set
+func newNotificationService(cfg *config.Config) (*handlers.NotificationService, error) {
+	store, err := newNotificationStore(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return handlers.NewNotificationServiceWithStore(
+		store,
+		cfg.PusherAppID,
+		cfg.PusherKey,
+		cfg.PusherSecret,
+		cfg.PusherCluster,
+	), nil
+}
+
+// runQueryCommand lists a user's latest notifications
+func runQueryCommand(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	owner := fs.String("owner", "", "user whose notifications to list (required)")
+	limit := fs.Int("limit", 20, "maximum number of notifications")
+	unread := fs.Bool("unread", false, "only list unread notifications")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *owner == "" {
+		return errors.New("-owner is required")
+	}
+
+	notifService, err := newNotificationService(cfg)
+	if err != nil {
+		return err
+	}
+
+	notifications, err := notifService.GetNotificationsByOwner(*owner, int32(*limit))
+	if err != nil {
+		return err
+	}
+
+	if *unread {
+		filtered := notifications[:0]
+		for _, notif := range notifications {
+			if !notif.ReadStatus {
+				filtered = append(filtered, notif)
+			}
+		}
+		notifications = filtered
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(notifications)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED\tID\tACTION\tFROM\tREAD\tTEXT")
+	for _, notif := range notifications {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n",
+			notif.CreatedAt.Local().Format(time.DateTime),
+			notif.Id,
+			actionName(notif.Action),
+			notif.UserName,
+			notif.ReadStatus,
+			notificationText(notif),
+		)
+	}
+	return w.Flush()
+}
+
+// runMarkReadCommand marks one or all of a user's notifications as read
+func runMarkReadCommand(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("mark-read", flag.ContinueOnError)
+	owner := fs.String("owner", "", "user the notifications belong to (required)")
+	id := fs.String("id", "", "notification id to mark as read")
+	all := fs.Bool("all", false, "mark every unread notification as read")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *owner == "" {
+		return errors.New("-owner is required")
+	}
+	if (*id == "") == !*all {
+		return errors.New("exactly one of -id or -all is required")
+	}
+
+	notifService, err := newNotificationService(cfg)
+	if err != nil {
+		return err
+	}
+
+	if *all {
+		updated, err := notifService.MarkAllAsRead(*owner)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("marked %d notifications as read for %s\n", updated, *owner)
+		return nil
+	}
+
+	if err := notifService.MarkAsRead(*owner, *id); err != nil {
+		return err
+	}
+	fmt.Printf("marked %s as read\n", *id)
+	return nil
+}
+
+// runDismissCommand deletes one of a user's notifications, the way a
+// notifications.dismissed event does
+func runDismissCommand(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("dismiss", flag.ContinueOnError)
+	owner := fs.String("owner", "", "user the notification belongs to (required)")
+	id := fs.String("id", "", "notification id to dismiss (required)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *owner == "" || *id == "" {
+		return errors.New("-owner and -id are required")
+	}
+
+	notifService, err := newNotificationService(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := notifService.Dismiss(context.Background(), *owner, *id); err != nil {
+		return err
+	}
+	fmt.Printf("dismissed %s\n", *id)
+	return nil
+}
+
+// actionName returns the registered name of an action, or its number
+func actionName(action int) string {
+	if a, ok := models.LookupAction(action); ok {
+		return a.Name
+	}
+	return fmt.Sprint(action)
+}
+
+// notificationText returns the rendered text, or the excerpt for
+// notifications stored before rendering existed
+func notificationText(notif models.Notification) string {
+	switch {
+	case notif.Title != "" && notif.Body != "":
+		return notif.Title + ": " + notif.Body
+	case notif.Body != "":
+		return notif.Body
+	default:
+		return notif.Excerpt
+	}
+}
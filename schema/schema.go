@@ -0,0 +1,390 @@
+// Package schema versions the NotificationEvent payload. It publishes a
+// JSON Schema per version, validates incoming payloads against the
+// version they declare, and upcasts older versions to the current struct.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aslotsu/notification-worker/models"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// CurrentVersion is the schema version of models.NotificationEvent
+const CurrentVersion = 2
+
+// Versions lists every schema version the worker accepts, oldest first
+var Versions = []int{1, 2}
+
+// schemaBaseURL is the $id prefix of published schemas (docs/schemas)
+const schemaBaseURL = "https://github.com/aslotsu/notification-worker/docs/schemas/notification-event"
+
+// FieldError describes one invalid field
+type FieldError struct {
+	Field   string `json:"field"` // Dotted path, empty for the whole payload
+	Message string `json:"message"`
+}
+
+// Error is returned when a payload doesn't match its schema version
+type Error struct {
+	Version int // Zero when the version itself is invalid
+	Fields  []FieldError
+}
+
+func (e *Error) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		if f.Field == "" {
+			parts[i] = f.Message
+		} else {
+			parts[i] = f.Field + ": " + f.Message
+		}
+	}
+	if e.Version == 0 {
+		return "schema: " + strings.Join(parts, "; ")
+	}
+	return fmt.Sprintf("schema v%d: %s", e.Version, strings.Join(parts, "; "))
+}
+
+// URL returns the $id of a schema version
+func URL(version int) string {
+	return fmt.Sprintf("%s.v%d.json", schemaBaseURL, version)
+}
+
+// Document builds the JSON Schema of a version
+func Document(version int) (map[string]any, error) {
+	switch version {
+	case 1:
+		return documentV1(), nil
+	case 2:
+		return documentV2(), nil
+	default:
+		return nil, fmt.Errorf("unknown schema version %d", version)
+	}
+}
+
+// Export returns the indented JSON Schema of a version
+func Export(version int) ([]byte, error) {
+	doc, err := Document(version)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// documentV1 describes payloads sent before schema_version existed
+func documentV1() map[string]any {
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         URL(1),
+		"title":       "NotificationEvent v1",
+		"description": "Legacy notification event without schema_version.",
+		"type":        "object",
+		"properties": map[string]any{
+			"owner":         stringProperty("User who receives the notification"),
+			"trigger_user":  stringProperty("User who triggered the action"),
+			"username":      map[string]any{"type": "string", "description": "Trigger user's display name"},
+			"user_picture":  map[string]any{"type": "string", "description": "Trigger user's profile picture"},
+			"user_bio":      map[string]any{"type": "string", "description": "Trigger user's bio"},
+			"action":        map[string]any{"type": "integer", "minimum": 1, "description": "Action type"},
+			"resource_type": stringProperty("Type of resource, any case"),
+			"resource_id":   stringProperty("ID of the resource"),
+			"excerpt":       map[string]any{"type": "string", "description": "Optional preview text"},
+			"created_at":    map[string]any{"type": "integer", "description": "Unix timestamp, receive time when missing or zero"},
+		},
+		"required": []string{"owner", "trigger_user", "action", "resource_type", "resource_id"},
+	}
+}
+
+// documentV2 describes the current payload. Per-action rules come from
+// the action registry.
+func documentV2() map[string]any {
+	var actionIDs []int
+	var resourceTypes []string
+	var rules []any
+	seenResource := map[string]bool{}
+
+	for _, action := range models.ActionTypes() {
+		actionIDs = append(actionIDs, action.ID)
+		for _, rt := range action.ResourceTypes {
+			if !seenResource[rt] {
+				seenResource[rt] = true
+				resourceTypes = append(resourceTypes, rt)
+			}
+		}
+
+		rules = append(rules, map[string]any{
+			"if": map[string]any{
+				"required":   []string{"action"},
+				"properties": map[string]any{"action": map[string]any{"const": action.ID}},
+			},
+			"then": map[string]any{
+				"required": action.RequiredFields,
+				"properties": map[string]any{
+					"resource_type": map[string]any{"enum": action.ResourceTypes},
+				},
+			},
+		})
+	}
+	sort.Strings(resourceTypes)
+
+	return map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         URL(2),
+		"title":       "NotificationEvent v2",
+		"description": "Notification event published on notifications.>.",
+		"type":        "object",
+		"properties": map[string]any{
+			"schema_version": map[string]any{"const": 2},
+			"event_id":       map[string]any{"type": "string", "description": "Unique id for idempotency, defaults to the Nats-Msg-Id header"},
+			"owner":          stringProperty("User who receives the notification"),
+			"trigger_user":   map[string]any{"type": "string", "description": "User who triggered the action"},
+			"username":       map[string]any{"type": "string", "description": "Trigger user's display name"},
+			"user_picture":   map[string]any{"type": "string", "description": "Trigger user's profile picture"},
+			"user_bio":       map[string]any{"type": "string", "description": "Trigger user's bio"},
+			"action":         map[string]any{"enum": actionIDs, "description": "Action type, see docs/actions.md"},
+			"resource_type":  map[string]any{"enum": resourceTypes},
+			"resource_id":    map[string]any{"type": "string", "description": "ID of the resource"},
+			"source_id":      map[string]any{"type": "string", "description": "ID of the reply/comment that caused a reply or mention"},
+			"excerpt":        map[string]any{"type": "string", "description": "Optional preview text"},
+			"locale":         map[string]any{"type": "string", "description": "Owner locale for rendered text (en, fr, ...)"},
+			"created_at":     map[string]any{"type": "integer", "minimum": 1, "description": "Unix timestamp"},
+		},
+		"required":             []string{"schema_version", "owner", "action", "resource_type", "resource_id", "created_at"},
+		"additionalProperties": false,
+		"allOf":                rules,
+	}
+}
+
+// stringProperty is a required-looking string: present and not empty
+func stringProperty(description string) map[string]any {
+	return map[string]any{"type": "string", "minLength": 1, "description": description}
+}
+
+// compiled holds the compiled schema of every version
+var (
+	compileOnce sync.Once
+	compiled    map[int]*jsonschema.Schema
+	compileErr  error
+)
+
+// compile builds the validators the first time they're needed
+func compile() (map[int]*jsonschema.Schema, error) {
+	compileOnce.Do(func() {
+		compiler := jsonschema.NewCompiler()
+		compiler.Draft = jsonschema.Draft2020
+
+		schemas := make(map[int]*jsonschema.Schema, len(Versions))
+		for _, version := range Versions {
+			data, err := Export(version)
+			if err != nil {
+				compileErr = err
+				return
+			}
+			if err := compiler.AddResource(URL(version), bytes.NewReader(data)); err != nil {
+				compileErr = fmt.Errorf("failed to load schema v%d: %v", version, err)
+				return
+			}
+			schema, err := compiler.Compile(URL(version))
+			if err != nil {
+				compileErr = fmt.Errorf("failed to compile schema v%d: %v", version, err)
+				return
+			}
+			schemas[version] = schema
+		}
+		compiled = schemas
+	})
+	return compiled, compileErr
+}
+
+// upcasters convert a payload of version N to version N+1 in place
+var upcasters = map[int]func(doc map[string]any, receivedAt time.Time){
+	1: upcastV1,
+}
+
+// upcastV1 converts a legacy payload: resource types become upper case
+// and a missing or zero created_at becomes the time the event was received
+func upcastV1(doc map[string]any, receivedAt time.Time) {
+	if rt, ok := doc["resource_type"].(string); ok {
+		doc["resource_type"] = strings.ToUpper(rt)
+	}
+	if createdAt, ok := doc["created_at"].(json.Number); !ok || createdAt.String() == "0" {
+		doc["created_at"] = json.Number(fmt.Sprint(receivedAt.Unix()))
+	}
+	doc["schema_version"] = json.Number("2")
+}
+
+// Decode validates a JSON payload against the schema version it declares
+// (v1 when schema_version is missing) and upcasts it to the current event
+func Decode(data []byte, receivedAt time.Time) (models.NotificationEvent, error) {
+	doc, err := ParseDocument(data)
+	if err != nil {
+		return models.NotificationEvent{}, err
+	}
+
+	return DecodeDocument(doc, receivedAt)
+}
+
+// ParseDocument parses a JSON object keeping numbers as json.Number, the
+// form DecodeDocument and Validate expect
+func ParseDocument(data []byte) (map[string]any, error) {
+	var doc map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("invalid JSON: expected an object")
+	}
+	return doc, nil
+}
+
+// DecodeDocument validates and upcasts a parsed payload, see Decode
+func DecodeDocument(doc map[string]any, receivedAt time.Time) (models.NotificationEvent, error) {
+	var event models.NotificationEvent
+
+	version, err := declaredVersion(doc)
+	if err != nil {
+		return event, err
+	}
+
+	if err := Validate(version, doc); err != nil {
+		return event, err
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		upcasters[v](doc, receivedAt)
+	}
+
+	// Round-trip through JSON so struct tags stay the only mapping
+	upcast, err := json.Marshal(doc)
+	if err != nil {
+		return event, fmt.Errorf("failed to encode upcast event: %v", err)
+	}
+	if err := json.Unmarshal(upcast, &event); err != nil {
+		return event, fmt.Errorf("failed to decode upcast event: %v", err)
+	}
+
+	return event, nil
+}
+
+// declaredVersion reads schema_version, defaulting to 1 when missing or zero
+func declaredVersion(doc map[string]any) (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok {
+		return 1, nil
+	}
+
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, &Error{Fields: []FieldError{{Field: "schema_version", Message: "must be an integer"}}}
+	}
+
+	version, err := number.Int64()
+	if err == nil && version == 0 {
+		return 1, nil // Zero value from producers that don't set it yet
+	}
+	if err != nil || version < 1 || version > CurrentVersion {
+		return 0, &Error{Fields: []FieldError{{
+			Field:   "schema_version",
+			Message: fmt.Sprintf("unsupported version %s (supported: 1-%d)", number, CurrentVersion),
+		}}}
+	}
+
+	return int(version), nil
+}
+
+// Validate checks a decoded payload (decoded with UseNumber) against a version
+func Validate(version int, doc any) error {
+	schemas, err := compile()
+	if err != nil {
+		return err
+	}
+
+	schema, ok := schemas[version]
+	if !ok {
+		return fmt.Errorf("unknown schema version %d", version)
+	}
+
+	err = schema.Validate(doc)
+	if err == nil {
+		return nil
+	}
+
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+
+	return &Error{Version: version, Fields: fieldErrors(validationErr)}
+}
+
+// quotedName matches property names quoted in validator messages
+var quotedName = regexp.MustCompile(`'([^']+)'|"([^"]+)"`)
+
+// fieldErrors flattens a validation error into one entry per field
+func fieldErrors(err *jsonschema.ValidationError) []FieldError {
+	var fields []FieldError
+	seen := map[FieldError]bool{}
+
+	add := func(f FieldError) {
+		if !seen[f] {
+			seen[f] = true
+			fields = append(fields, f)
+		}
+	}
+
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+
+		path := strings.ReplaceAll(strings.TrimPrefix(e.InstanceLocation, "/"), "/", ".")
+
+		// Name the offending properties rather than their parent object
+		switch {
+		case strings.HasSuffix(e.KeywordLocation, "/required"):
+			for _, name := range propertyNames(e.Message) {
+				add(FieldError{Field: joinPath(path, name), Message: "is required"})
+			}
+		case strings.HasSuffix(e.KeywordLocation, "/additionalProperties"):
+			for _, name := range propertyNames(e.Message) {
+				add(FieldError{Field: joinPath(path, name), Message: "is not allowed"})
+			}
+		default:
+			add(FieldError{Field: path, Message: e.Message})
+		}
+	}
+	walk(err)
+
+	return fields
+}
+
+// propertyNames extracts quoted property names from a validator message
+func propertyNames(message string) []string {
+	var names []string
+	for _, match := range quotedName.FindAllStringSubmatch(message, -1) {
+		names = append(names, match[1]+match[2])
+	}
+	return names
+}
+
+// joinPath appends a property to a dotted path
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aslotsu/notification-worker/config"
+	"github.com/aslotsu/notification-worker/schema"
+)
+
+// runSchemaCommand implements "schema export" and "schema validate"
+func runSchemaCommand(_ *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: schema export [-version N] [-dir DIR] | schema validate FILE")
+	}
+
+	switch args[0] {
+	case "export":
+		return exportSchemas(args[1:])
+	case "validate":
+		return validateEventFile(args[1:])
+	default:
+		return fmt.Errorf("unknown schema command %q", args[0])
+	}
+}
+
+// exportSchemas prints one schema version, or writes every version to a directory
+func exportSchemas(args []string) error {
+	fs := flag.NewFlagSet("schema export", flag.ContinueOnError)
+	version := fs.Int("version", schema.CurrentVersion, "schema version to print")
+	dir := fs.String("dir", "", "write every version to DIR/notification-event.vN.json instead")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		data, err := schema.Export(*version)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(data))
+		return err
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	for _, v := range schema.Versions {
+		data, err := schema.Export(v)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(*dir, fmt.Sprintf("notification-event.v%d.json", v))
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+		fmt.Println("wrote", path)
+	}
+
+	return nil
+}
+
+// validateEventFile checks an event payload and prints it upcast to the current version
+func validateEventFile(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: schema validate FILE")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	event, err := schema.Decode(data, time.Now())
+	if err != nil {
+		var schemaErr *schema.Error
+		if !errors.As(err, &schemaErr) {
+			return err
+		}
+		for _, field := range schemaErr.Fields {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", field.Field, field.Message)
+		}
+		return errors.New("event is invalid")
+	}
+
+	fmt.Printf("valid, upcast to v%d: %+v\n", schema.CurrentVersion, event)
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aslotsu/notification-worker/config"
+	"github.com/aslotsu/notification-worker/handlers"
+)
+
+// runTableCommand implements "table describe|check|create|migrate"
+func runTableCommand(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		args = []string{"describe"}
+	}
+
+	switch args[0] {
+	case "describe":
+		fs := flag.NewFlagSet("table describe", flag.ContinueOnError)
+		asJSON := fs.Bool("json", false, "print JSON")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return describeTable(cfg, *asJSON)
+	case "check":
+		fs := flag.NewFlagSet("table check", flag.ContinueOnError)
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return checkTable(cfg)
+	case "create":
+		fs := flag.NewFlagSet("table create", flag.ContinueOnError)
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return createTable(cfg)
+	case "migrate":
+		fs := flag.NewFlagSet("table migrate", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "list pending migrations without applying them")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return migrateTable(cfg, *dryRun)
+	default:
+		return fmt.Errorf("unknown table command %q (describe, check, create, migrate)", args[0])
+	}
+}
+
+// describeTable prints the notifications table's schema
+func describeTable(cfg *config.Config, asJSON bool) error {
+	store, err := handlers.NewDynamoDBNotificationStore(cfg.AWSRegion, cfg.NotifTableName)
+	if err != nil {
+		return err
+	}
+
+	info, err := store.DescribeTableInfo(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	fmt.Printf("table:  %s (%s)\n", info.Name, info.Status)
+	fmt.Printf("key:    %s\n", strings.Join(info.KeySchema, ", "))
+	fmt.Printf("items:  ~%d\n", info.ItemCount)
+	fmt.Printf("schema: v%d (latest v%d)\n", info.SchemaVersion, handlers.LatestTableVersion())
+	if info.TTL == "" {
+		fmt.Println("ttl:    disabled")
+	} else {
+		fmt.Printf("ttl:    %s\n", info.TTL)
+	}
+	for _, index := range info.Indexes {
+		fmt.Printf("index:  %s (%s) %s\n", index.Name, index.Status, strings.Join(index.KeySchema, ", "))
+	}
+	return nil
+}
+
+// checkTable validates the table's key schema and indexes
+func checkTable(cfg *config.Config) error {
+	store, err := handlers.NewDynamoDBNotificationStore(cfg.AWSRegion, cfg.NotifTableName)
+	if err != nil {
+		return err
+	}
+
+	problems, err := store.ValidateTable(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", cfg.NotifTableName, problem)
+		}
+		return fmt.Errorf("table %s does not match the expected schema, run \"table create\" or \"table migrate\"", cfg.NotifTableName)
+	}
+
+	fmt.Printf("%s: ok\n", cfg.NotifTableName)
+	return nil
+}
+
+// createTable creates the table if needed, enables TTL and migrates it
+func createTable(cfg *config.Config) error {
+	store, err := handlers.NewDynamoDBNotificationStore(cfg.AWSRegion, cfg.NotifTableName)
+	if err != nil {
+		return err
+	}
+
+	steps, err := store.EnsureTable(context.Background())
+	for _, step := range steps {
+		fmt.Println(step)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(steps) == 0 {
+		fmt.Printf("table %s is up to date\n", cfg.NotifTableName)
+	}
+	return nil
+}
+
+// migrateTable applies or lists pending migrations
+func migrateTable(cfg *config.Config, dryRun bool) error {
+	store, err := handlers.NewDynamoDBNotificationStore(cfg.AWSRegion, cfg.NotifTableName)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := store.Migrate(context.Background(), dryRun)
+	for _, migration := range migrations {
+		verb := "applied"
+		if dryRun {
+			verb = "pending"
+		}
+		fmt.Printf("%s migration %d: %s (index %s)\n", verb, migration.Version, migration.Description, migration.AddIndex.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) == 0 {
+		fmt.Printf("table %s is at the latest version (v%d)\n", cfg.NotifTableName, handlers.LatestTableVersion())
+	}
+	return nil
+}